@@ -1,97 +1,125 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"io"
+	"context"
+	"crypto/tls"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/compress"
+	"github.com/RayanMalki/tcptohttp/internal/fileserver"
+	"github.com/RayanMalki/tcptohttp/internal/grpc"
+	"github.com/RayanMalki/tcptohttp/internal/proxy"
 	"github.com/RayanMalki/tcptohttp/internal/request"
 	"github.com/RayanMalki/tcptohttp/internal/response"
 	"github.com/RayanMalki/tcptohttp/internal/server"
+	"github.com/RayanMalki/tcptohttp/internal/sse"
+	"github.com/RayanMalki/tcptohttp/internal/websocket"
 )
 
 const port = 42069
 
-func myHandler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video/") {
-		filename := strings.TrimPrefix(req.RequestLine.RequestTarget, "/video/")
-		filepath := "assets/" + filename
+// httpbinUpstream is where requests under /httpbin/ are forwarded.
+const httpbinUpstream = "https://httpbin.org"
+
+var videoHandler = server.StripPrefix("/video", fileserver.NewDir("assets").Handle)
 
-		data, err := os.ReadFile(filepath)
+var httpbinHandler server.Handler
+
+var upgrader = websocket.NewUpgrader(websocket.WithCompression())
+
+// echoHandler echoes WebSocket messages back to the client.
+func echoHandler(w *response.Writer, req *request.Request) {
+	conn, err := upgrader.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		typ, data, err := conn.ReadMessage()
 		if err != nil {
-			w.WriteStatusLine(response.StatusBadRequest)
-			headers := response.GetDefaultHeaders(0)
-			headers.Set("Content-Type", "text/html")
-			w.WriteHeaders(headers)
-			w.WriteBody([]byte("<html><body><h1>Video Not Found</h1></body></html>"))
 			return
 		}
-
-		w.WriteStatusLine(response.StatusOK)
-		headers := response.GetDefaultHeaders(len(data))
-		headers.Set("Content-Type", "video/mp4")
-		w.WriteHeaders(headers)
-		w.WriteBody(data)
-		return
+		if err := conn.WriteMessage(typ, data); err != nil {
+			return
+		}
 	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-		url := "https://httpbin.org/" + path
+}
 
-		newReq, _ := http.NewRequest("GET", url, nil)
-		newReq.Header.Set("Accept-Encoding", "identity")
+// forwardHandler serves forward proxy requests. It stays nil, and such
+// requests are treated like any other, unless PROXY_ALLOWED_HOSTS is set.
+var forwardHandler server.Handler
 
-		client := &http.Client{}
-		resp, err := client.Do(newReq)
-		if err != nil {
-			w.WriteStatusLine(response.StatusInternalError)
-			headers := response.GetDefaultHeaders(0)
-			headers.Set("Content-Type", "text/html")
-			w.WriteHeaders(headers)
-			w.WriteBody([]byte("<html><body><h1>Proxy Error</h1></body></html>"))
+// clockHandler streams the server time once a second as Server-Sent Events.
+func clockHandler(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.WithHeartbeat(15*time.Second))
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if stream.Send(sse.Event{Event: "tick", Data: now.UTC().Format(time.RFC3339)}) != nil {
+				return
+			}
+		case <-stream.Done():
 			return
 		}
-		defer resp.Body.Close()
-
-		w.WriteStatusLine(response.StatusOK)
-		hdrs := response.GetDefaultHeaders(0)
-		delete(hdrs, "Content-Length")
-		hdrs.Set("Transfer-Encoding", "chunked")
-		hdrs.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-		hdrs.Set("Content-Type", resp.Header.Get("Content-Type"))
-		w.WriteHeaders(hdrs)
-
-		var fullBody []byte
-		buf := make([]byte, 1024)
-
-		for {
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				w.WriteChunkedBody(buf[:n])
-				fullBody = append(fullBody, buf[:n]...)
-			}
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				break
+	}
+}
+
+// echoMessage is the request and reply of the demo gRPC service.
+type echoMessage struct {
+	Text  string `json:"text"`
+	Count int    `json:"count,omitempty"`
+}
+
+// newGRPCServer registers a demo echo service, reachable with the JSON
+// codec (application/grpc+json).
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	grpc.Unary(s, "/tcptohttp.Echo/Say", func(ctx context.Context, req *echoMessage) (*echoMessage, error) {
+		return &echoMessage{Text: req.Text}, nil
+	})
+	grpc.ServerStreaming(s, "/tcptohttp.Echo/Repeat", func(req *echoMessage, stream *grpc.ServerStream[echoMessage]) error {
+		for i := range min(req.Count, 100) {
+			if err := stream.Send(&echoMessage{Text: req.Text, Count: i + 1}); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+	return s
+}
 
-		w.WriteChunkedBodyDone()
+func myHandler(w *response.Writer, req *request.Request) {
+	if forwardHandler != nil && proxy.IsProxyRequest(req) {
+		forwardHandler(w, req)
+		return
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video/") {
+		videoHandler(w, req)
+		return
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
+		httpbinHandler(w, req)
+		return
+	}
 
-		hash := sha256.Sum256(fullBody)
-		trailers := headers.NewHeaders()
-		trailers["X-Content-SHA256"] = fmt.Sprintf("%x", hash)
-		trailers["X-Content-Length"] = fmt.Sprintf("%d", len(fullBody))
-		w.WriteTrailers(trailers)
+	if req.RequestLine.RequestTarget == "/ws/echo" {
+		echoHandler(w, req)
+		return
+	}
+	if req.RequestLine.RequestTarget == "/events/clock" {
+		clockHandler(w, req)
 		return
 	}
 
@@ -110,14 +138,45 @@ func myHandler(w *response.Writer, req *request.Request) {
 		html = `<html><body><h1>Success!</h1></body></html>`
 	}
 
-	headers := response.GetDefaultHeaders(len(html))
-	headers.Set("Content-Type", "text/html")
 	w.WriteStatusLine(status)
-	w.WriteHeaders(headers)
-	w.WriteBody([]byte(html))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteString(html)
 }
 func main() {
-	srv, err := server.Serve(port, myHandler)
+	httpbin, err := proxy.New(httpbinUpstream, proxy.WithIntegrityTrailers())
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	httpbinHandler = server.StripPrefix("/httpbin", httpbin.Handle)
+
+	// PROXY_ALLOWED_HOSTS is a comma-separated allow list ("*" for any
+	// host); PROXY_AUTH is an optional "user:password".
+	if allowed := os.Getenv("PROXY_ALLOWED_HOSTS"); allowed != "" {
+		var opts []proxy.ForwardOption
+		if allowed != "*" {
+			opts = append(opts, proxy.WithAllowedHosts(strings.Split(allowed, ",")...))
+		}
+		if user, password, ok := strings.Cut(os.Getenv("PROXY_AUTH"), ":"); ok {
+			opts = append(opts, proxy.WithBasicAuth(user, password))
+		}
+		forwardHandler = proxy.NewForward(opts...).Handle
+	}
+
+	serverOpts := []server.Option{server.WithServerHeader("tcptohttp")}
+	// TLS_CERT_FILE and TLS_KEY_FILE switch the server to TLS, where
+	// clients pick h2 or http/1.1 through ALPN; otherwise HTTP/2 is offered
+	// in cleartext.
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		serverOpts = append(serverOpts, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	} else {
+		serverOpts = append(serverOpts, server.WithH2C())
+	}
+
+	srv, err := server.Serve(port, newGRPCServer().Handler(compress.Handler(myHandler)), serverOpts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
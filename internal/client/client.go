@@ -0,0 +1,372 @@
+// Package client is an HTTP/1.1 client with keep-alive connection pooling.
+// Responses are parsed with the same header parser the server uses.
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+const (
+	// DefaultTimeout bounds dialing, the TLS handshake and waiting for
+	// response headers. Reading the body is not limited.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxIdlePerHost is how many idle connections are kept per host.
+	DefaultMaxIdlePerHost = 4
+	// DefaultIdleTimeout is how long an idle connection is kept.
+	DefaultIdleTimeout = 90 * time.Second
+)
+
+// Request is a request to send. Target is the absolute URL; Host, if set,
+// replaces the Host header derived from it.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Host    string
+	Headers headers.Headers
+	Body    []byte
+}
+
+// NewRequest returns a request for method and the absolute URL rawURL.
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("unsupported URL %q", rawURL)
+	}
+	return &Request{Method: method, URL: u, Headers: headers.NewHeaders(), Body: body}, nil
+}
+
+// Client sends requests, reusing connections when the server allows it.
+// It is safe for concurrent use.
+type Client struct {
+	timeout        time.Duration
+	maxIdlePerHost int
+	idleTimeout    time.Duration
+	tlsConfig      *tls.Config
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout replaces DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithMaxIdlePerHost replaces DefaultMaxIdlePerHost. Zero disables
+// keep-alive.
+func WithMaxIdlePerHost(n int) Option {
+	return func(c *Client) {
+		c.maxIdlePerHost = n
+	}
+}
+
+// WithIdleTimeout replaces DefaultIdleTimeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = d
+	}
+}
+
+// WithTLSConfig sets the configuration for https connections. ServerName
+// is filled in from the URL when empty.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// New returns a client.
+func New(opts ...Option) *Client {
+	c := &Client{
+		timeout:        DefaultTimeout,
+		maxIdlePerHost: DefaultMaxIdlePerHost,
+		idleTimeout:    DefaultIdleTimeout,
+		idle:           map[string][]*conn{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// conn is a connection to one host.
+type conn struct {
+	net.Conn
+	br     *bufio.Reader
+	key    string
+	reused bool
+	idleAt time.Time
+}
+
+// Get sends a GET request for rawURL.
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and reads the response headers. The caller must close the
+// response body; reading it to the end lets the connection be reused.
+//
+// A pooled connection the server has already closed is only noticed once
+// the request is sent on it, so idempotent requests that fail that way are
+// sent again on a new connection.
+func (c *Client) Do(req *Request) (*Response, error) {
+	for {
+		cn, err := c.getConn(req.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.roundTrip(cn, req)
+		if err == nil {
+			return resp, nil
+		}
+		cn.Close()
+
+		var netErr net.Error
+		stale := cn.reused && !(errors.As(err, &netErr) && netErr.Timeout())
+		if !stale || !Idempotent(req.Method) {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) roundTrip(cn *conn, req *Request) (*Response, error) {
+	cn.SetDeadline(time.Now().Add(c.timeout))
+	if err := writeRequest(cn, req); err != nil {
+		return nil, err
+	}
+	resp, framing, err := readResponse(cn.br, req.Method)
+	if err != nil {
+		return nil, err
+	}
+	cn.SetDeadline(time.Time{})
+
+	keepAlive := c.maxIdlePerHost > 0 &&
+		!headers.HasToken(req.Headers.Get("connection"), "close") &&
+		!headers.HasToken(resp.Headers.Get("connection"), "close") &&
+		resp.Proto == "HTTP/1.1" &&
+		framing != response.FramingClose
+
+	b := &body{c: c, cn: cn, keepAlive: keepAlive}
+	switch framing {
+	case response.FramingNone:
+		b.r = eofReader{}
+	case response.FramingLength:
+		b.r = io.LimitReader(cn.br, resp.ContentLength)
+		b.want = resp.ContentLength
+	case response.FramingChunked:
+		b.r = response.NewChunkedReader(cn.br, resp.Trailers)
+	case response.FramingClose:
+		b.r = cn.br
+	}
+	resp.Body = b
+	if framing == response.FramingNone {
+		// Nothing to read: hand the connection back right away.
+		b.finish()
+	}
+	return resp, nil
+}
+
+// writeRequest sends req on w in origin form.
+func writeRequest(w io.Writer, req *Request) error {
+	target := req.URL.RequestURI()
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	var b strings.Builder
+	b.WriteString(req.Method + " " + target + " HTTP/1.1\r\n")
+	b.WriteString("host: " + host + "\r\n")
+	for key, value := range req.Headers {
+		switch key {
+		case "host", "content-length", "transfer-encoding":
+			continue
+		}
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	if len(req.Body) > 0 || req.Method == "POST" || req.Method == "PUT" {
+		b.WriteString("content-length: " + strconv.Itoa(len(req.Body)) + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(b.String())
+	bw.Write(req.Body)
+	return bw.Flush()
+}
+
+func (c *Client) getConn(u *url.URL) (*conn, error) {
+	key := u.Scheme + "://" + HostPort(u)
+
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		list := c.idle[key]
+		cn := list[len(list)-1]
+		c.idle[key] = list[:len(list)-1]
+		if time.Since(cn.idleAt) < c.idleTimeout {
+			c.mu.Unlock()
+			cn.reused = true
+			return cn, nil
+		}
+		cn.Close()
+	}
+	c.mu.Unlock()
+
+	return c.dial(u, key)
+}
+
+func (c *Client) dial(u *url.URL, key string) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	nc, err := dialer.Dial("tcp", HostPort(u))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if c.tlsConfig != nil {
+			cfg = c.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(nc, cfg)
+		tc.SetDeadline(time.Now().Add(c.timeout))
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	return &conn{Conn: nc, br: bufio.NewReader(nc), key: key}, nil
+}
+
+// putIdle returns cn to the pool, or closes it if the pool is full.
+func (c *Client) putIdle(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[cn.key]) >= c.maxIdlePerHost {
+		cn.Close()
+		return
+	}
+	cn.idleAt = time.Now()
+	c.idle[cn.key] = append(c.idle[cn.key], cn)
+}
+
+// CloseIdleConnections closes the pooled connections.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, list := range c.idle {
+		for _, cn := range list {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// HostPort returns the host:port to dial for u, filling in the default
+// port of its scheme.
+func HostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// body is a response body. Once it is read to the end the connection goes
+// back to the pool; closing it early closes the connection.
+type body struct {
+	c         *Client
+	cn        *conn
+	r         io.Reader
+	want      int64 // Content-Length, to detect truncated bodies
+	got       int64
+	keepAlive bool
+	done      bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p)
+	b.got += int64(n)
+	if err == io.EOF {
+		if _, ok := b.r.(*io.LimitedReader); ok && b.got < b.want {
+			err = io.ErrUnexpectedEOF
+			b.keepAlive = false
+		}
+		b.finish()
+	} else if err != nil {
+		b.keepAlive = false
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if !b.done {
+		b.keepAlive = false
+		b.finish()
+	}
+	return nil
+}
+
+func (b *body) finish() {
+	if b.done {
+		return
+	}
+	b.done = true
+	if b.keepAlive {
+		b.c.putIdle(b.cn)
+	} else {
+		b.cn.Close()
+	}
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Idempotent reports whether repeating a request with this method has the
+// same effect as sending it once (RFC 9110 section 9.2.2).
+func Idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scripted starts a local server that runs handle for every accepted
+// connection and returns its base URL and a count of connections.
+func scripted(t *testing.T, handle func(c net.Conn, br *bufio.Reader)) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	var conns atomic.Int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer c.Close()
+				handle(c, bufio.NewReader(c))
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), &conns
+}
+
+// eachRequest reads requests off the connection and writes reply for each.
+func eachRequest(reply func(req *request.Request) string) func(net.Conn, *bufio.Reader) {
+	return func(c net.Conn, br *bufio.Reader) {
+		for {
+			req, err := request.RequestFromReader(br)
+			if err != nil {
+				return
+			}
+			if _, err := io.WriteString(c, reply(req)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readAll(t *testing.T, resp *Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(b)
+}
+
+func TestContentLengthKeepAlive(t *testing.T) {
+	base, conns := scripted(t, eachRequest(func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	}))
+	c := New()
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		assert.Equal(t, 200, int(resp.StatusCode))
+		assert.Equal(t, "OK", resp.Status)
+		assert.Equal(t, int64(5), resp.ContentLength)
+		assert.Equal(t, "hello", readAll(t, resp))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestWritesRequest(t *testing.T) {
+	got := make(chan *request.Request, 1)
+	base, _ := scripted(t, eachRequest(func(req *request.Request) string {
+		got <- req
+		return "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"
+	}))
+
+	req, err := NewRequest("POST", base+"/items?x=1", []byte(`{"a":1}`))
+	require.NoError(t, err)
+	req.Headers.Set("Content-Type", "application/json")
+	req.Host = "api.example"
+	resp, err := New().Do(req)
+	require.NoError(t, err)
+	readAll(t, resp)
+
+	r := <-got
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "/items?x=1", r.RequestLine.RequestTarget)
+	assert.Equal(t, "api.example", r.Headers.Get("host"))
+	assert.Equal(t, "application/json", r.Headers.Get("content-type"))
+	assert.Equal(t, `{"a":1}`, string(r.Body))
+	assert.Equal(t, 201, int(resp.StatusCode))
+}
+
+func TestChunkedWithTrailers(t *testing.T) {
+	base, conns := scripted(t, eachRequest(func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n"
+	}))
+	c := New()
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), resp.ContentLength)
+		assert.Equal(t, "hello, world", readAll(t, resp))
+		assert.Equal(t, "abc", resp.Trailers.Get("x-sum"))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestCloseDelimited(t *testing.T) {
+	base, conns := scripted(t, func(c net.Conn, br *bufio.Reader) {
+		request.RequestFromReader(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end")
+	})
+	c := New()
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "until the end", readAll(t, resp))
+	}
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestBodylessResponses(t *testing.T) {
+	base, conns := scripted(t, eachRequest(func(req *request.Request) string {
+		switch req.RequestLine.RequestTarget {
+		case "/continue":
+			return "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+		case "/empty":
+			return "HTTP/1.1 204 No Content\r\n\r\n"
+		case "/cached":
+			return "HTTP/1.1 304 Not Modified\r\nContent-Length: 100\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"
+	}))
+	c := New()
+
+	resp, err := c.Get(base + "/continue")
+	require.NoError(t, err)
+	assert.Equal(t, 200, int(resp.StatusCode))
+	assert.Equal(t, "ok", readAll(t, resp))
+
+	req, _ := NewRequest("HEAD", base+"/", nil)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), resp.ContentLength)
+	assert.Equal(t, "", readAll(t, resp))
+
+	resp, err = c.Get(base + "/empty")
+	require.NoError(t, err)
+	assert.Equal(t, 204, int(resp.StatusCode))
+	assert.Equal(t, "", readAll(t, resp))
+
+	resp, err = c.Get(base + "/cached")
+	require.NoError(t, err)
+	assert.Equal(t, 304, int(resp.StatusCode))
+	assert.Equal(t, "", readAll(t, resp))
+
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestRetriesStaleConnection(t *testing.T) {
+	// The server answers one request per connection without saying so.
+	base, conns := scripted(t, func(c net.Conn, br *bufio.Reader) {
+		request.RequestFromReader(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+	c := New()
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(base + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", readAll(t, resp))
+		// Give the server time to close its end.
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(3), conns.Load())
+}
+
+func TestTruncatedBody(t *testing.T) {
+	base, _ := scripted(t, func(c net.Conn, br *bufio.Reader) {
+		request.RequestFromReader(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+	})
+
+	resp, err := New().Get(base + "/")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	base, _ := scripted(t, func(c net.Conn, br *bufio.Reader) {
+		request.RequestFromReader(br)
+		time.Sleep(time.Second)
+	})
+
+	_, err := New(WithTimeout(50 * time.Millisecond)).Get(base + "/")
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
+
+func TestMalformedResponses(t *testing.T) {
+	for _, raw := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab",
+	} {
+		base, _ := scripted(t, func(c net.Conn, br *bufio.Reader) {
+			request.RequestFromReader(br)
+			io.WriteString(c, raw)
+		})
+		_, err := New().Get(base + "/")
+		assert.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// Response is a response read from a server. Body streams the payload and
+// must be closed; Trailers is filled in once Body has returned io.EOF.
+type Response struct {
+	StatusCode response.StatusCode
+	Status     string // reason phrase
+	Proto      string // e.g. "HTTP/1.1"
+	Headers    headers.Headers
+	// SetCookies holds one value per Set-Cookie field, which Headers
+	// cannot combine.
+	SetCookies    []string
+	Trailers      headers.Headers
+	ContentLength int64 // -1 if unknown
+	Body          io.ReadCloser
+}
+
+// readResponse reads the response to a request with the given method,
+// skipping interim 1xx responses.
+func readResponse(br *bufio.Reader, method string) (*Response, response.BodyFraming, error) {
+	for {
+		statusLine, err := response.ReadStatusLine(br)
+		if err != nil {
+			return nil, response.FramingNone, err
+		}
+		resp := &Response{
+			StatusCode: statusLine.StatusCode,
+			Status:     statusLine.ReasonPhrase,
+			Proto:      "HTTP/" + statusLine.HttpVersion,
+			Headers:    headers.NewHeaders(),
+			Trailers:   headers.NewHeaders(),
+		}
+		if resp.SetCookies, err = response.ReadHeaderBlock(br, resp.Headers); err != nil {
+			return nil, response.FramingNone, err
+		}
+
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != response.StatusSwitchingProtocols {
+			continue
+		}
+
+		framing, length, err := response.Framing(method, resp.StatusCode, resp.Headers)
+		if err != nil {
+			return nil, response.FramingNone, err
+		}
+		resp.ContentLength = length
+		return resp, framing, nil
+	}
+}
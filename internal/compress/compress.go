@@ -0,0 +1,151 @@
+// Package compress compresses responses and decodes compressed request
+// bodies for server handlers.
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/compress/zstd"
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/negotiate"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultMinSize is the smallest body worth compressing by default.
+const DefaultMinSize = 1024
+
+// DefaultContentTypes lists the media types compressed by default. A
+// trailing "/*" matches a whole top-level type. text/event-stream is never
+// compressed.
+var DefaultContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// encoders maps content codings to their stream compressors.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	// HTTP's "deflate" is the zlib format (RFC 9110 section 8.4.1.2).
+	"deflate": func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	},
+	"zstd": func(w io.Writer) io.WriteCloser {
+		return zstd.NewWriter(w)
+	},
+}
+
+type config struct {
+	minSize      int
+	contentTypes []string
+	preference   []string
+}
+
+// Option configures the compression handler.
+type Option func(*config)
+
+// WithMinSize sets the smallest body that gets compressed. Bodies whose
+// size is not known up front are always compressed.
+func WithMinSize(n int) Option {
+	return func(c *config) {
+		c.minSize = n
+	}
+}
+
+// WithContentTypes replaces the list of compressible media types.
+func WithContentTypes(types ...string) Option {
+	return func(c *config) {
+		c.contentTypes = types
+	}
+}
+
+// WithPreference sets which codings the server offers and, for codings the
+// client rates equally, which one wins. The default is gzip, zstd, deflate.
+func WithPreference(codings ...string) Option {
+	return func(c *config) {
+		c.preference = codings
+	}
+}
+
+// Handler wraps next so its response bodies are compressed with the best
+// coding the client accepts.
+func Handler(next server.Handler, opts ...Option) server.Handler {
+	c := &config{
+		minSize:      DefaultMinSize,
+		contentTypes: DefaultContentTypes,
+		preference:   []string{"gzip", "zstd", "deflate"},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		coding := c.negotiate(req.Headers.Get("accept-encoding"))
+		w.SetContentEncoder(func(status response.StatusCode, h headers.Headers, size int) func(io.Writer) io.WriteCloser {
+			if status == response.StatusPartialContent || h.Get("content-range") != "" || h.Get("content-encoding") != "" {
+				return nil
+			}
+			if !c.compressible(h.Get("content-type")) {
+				return nil
+			}
+
+			// Whether or not this response ends up compressed, the choice
+			// depended on Accept-Encoding.
+			w.AddVary("Accept-Encoding")
+			if coding == "" || (size >= 0 && size < c.minSize) {
+				return nil
+			}
+
+			h.Set("Content-Encoding", coding)
+			if etag := h.Get("etag"); strings.HasPrefix(etag, `"`) {
+				// The compressed bytes differ from what a strong tag promised.
+				h.Set("ETag", "W/"+etag)
+			}
+			return encoders[coding]
+		})
+		next(w, req)
+	}
+}
+
+func (c *config) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" || mediaType == "text/event-stream" {
+		// Events must reach the client as they are written, which a
+		// compressor holding back output would prevent.
+		return false
+	}
+	for _, t := range c.contentTypes {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the coding with the highest q-value in the client's
+// Accept-Encoding, or "" for identity.
+func (c *config) negotiate(acceptEncoding string) string {
+	var offers []string
+	for _, coding := range c.preference {
+		if _, ok := encoders[coding]; ok {
+			offers = append(offers, coding)
+		}
+	}
+	return negotiate.Encoding(acceptEncoding, offers...)
+}
@@ -0,0 +1,186 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// page is compressible and larger than DefaultMinSize but fits the
+// writer's buffer, so it is sent with a Content-Length.
+var page = strings.Repeat("<p>compress me please</p>\n", 100)
+
+// run serves one GET request through h and splits the raw output into the
+// header block and the (de-chunked) body.
+func run(t *testing.T, h server.Handler, acceptEncoding string) (string, []byte) {
+	t.Helper()
+	return runMethod(t, h, "GET", acceptEncoding)
+}
+
+func runMethod(t *testing.T, h server.Handler, method, acceptEncoding string) (string, []byte) {
+	t.Helper()
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	if method == "HEAD" {
+		w.DiscardBody()
+	}
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	if acceptEncoding != "" {
+		req.Headers.Set("Accept-Encoding", acceptEncoding)
+	}
+	h(w, req)
+	require.NoError(t, w.Finish())
+
+	head, body, ok := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, ok)
+	head += "\r\n"
+	if !strings.Contains(head, "transfer-encoding: chunked") {
+		return head, []byte(body)
+	}
+
+	var decoded []byte
+	r := bufio.NewReader(strings.NewReader(body))
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			return head, decoded
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		decoded = append(decoded, chunk[:size]...)
+	}
+}
+
+func htmlHandler(body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteString(body)
+	}
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestCompressesBufferedBody(t *testing.T) {
+	head, body := run(t, Handler(htmlHandler(page)), "gzip, deflate")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+	assert.Equal(t, page, gunzip(t, body))
+}
+
+func TestCompressesStreamedBody(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for i := 0; i < 3; i++ {
+			w.WriteString(page)
+			w.Flush()
+		}
+	}
+	head, body := run(t, Handler(h), "gzip")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+	assert.Equal(t, strings.Repeat(page, 3), gunzip(t, body))
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	getHead, _ := run(t, Handler(htmlHandler(page)), "gzip")
+	headHead, body := runMethod(t, Handler(htmlHandler(page)), "HEAD", "gzip")
+	assert.ElementsMatch(t, strings.Split(getHead, "\r\n"), strings.Split(headHead, "\r\n"))
+	assert.Empty(t, body)
+
+	// Too long to buffer: GET streams it, so neither knows the length.
+	long := strings.Repeat(page, 3)
+	getHead, _ = run(t, Handler(htmlHandler(long)), "gzip")
+	headHead, _ = runMethod(t, Handler(htmlHandler(long)), "HEAD", "gzip")
+	for _, head := range []string{getHead, headHead} {
+		assert.Contains(t, head, "content-encoding: gzip\r\n")
+		assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+		assert.NotContains(t, head, "content-length")
+	}
+}
+
+func TestSkipsEventStreams(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteString("data: " + page + "\n\n")
+		w.Flush()
+	}
+	head, body := run(t, Handler(h), "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Equal(t, "data: "+page+"\n\n", string(body))
+}
+
+func TestDeflateIsZlib(t *testing.T) {
+	head, body := run(t, Handler(htmlHandler(page)), "deflate")
+	assert.Contains(t, head, "content-encoding: deflate\r\n")
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(out))
+}
+
+func TestSkipsSmallAndIncompressible(t *testing.T) {
+	head, body := run(t, Handler(htmlHandler("tiny")), "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Equal(t, "tiny", string(body))
+
+	video := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.WriteString(page)
+	}
+	head, _ = run(t, Handler(video), "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+
+	head, _ = run(t, Handler(htmlHandler(page)), "")
+	assert.NotContains(t, head, "content-encoding")
+}
+
+func TestWeakensStrongETag(t *testing.T) {
+	h := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteString(page)
+	}
+	head, _ := run(t, Handler(h), "gzip")
+	assert.Contains(t, head, "etag: W/\"v1\"\r\n")
+}
+
+func TestNegotiate(t *testing.T) {
+	c := &config{preference: []string{"gzip", "zstd", "deflate"}}
+	assert.Equal(t, "gzip", c.negotiate("gzip, deflate, br, zstd"))
+	assert.Equal(t, "zstd", c.negotiate("gzip;q=0.5, zstd"))
+	assert.Equal(t, "deflate", c.negotiate("deflate"))
+	assert.Equal(t, "gzip", c.negotiate("*"))
+	assert.Equal(t, "zstd", c.negotiate("*;q=0.1, gzip;q=0"))
+	assert.Equal(t, "", c.negotiate("identity, gzip;q=0"))
+	assert.Equal(t, "", c.negotiate("br"))
+}
@@ -0,0 +1,127 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultMaxDecompressedSize caps a decoded request body when
+// DecompressRequests is given no limit.
+const DefaultMaxDecompressedSize = 10 << 20
+
+// errTooLarge means a decoded body grew past the configured limit.
+var errTooLarge = errors.New("decompressed request body too large")
+
+// decoders maps the request content codings we accept to their readers.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"x-gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": newDeflateReader,
+}
+
+// newDeflateReader reads HTTP "deflate", which is the zlib format. Some
+// clients send a bare deflate stream instead, so that is accepted too.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		return zr, nil
+	}
+	return flate.NewReader(bytes.NewReader(data)), nil
+}
+
+// DecompressRequests wraps next so request bodies sent with a gzip or
+// deflate Content-Encoding reach it decoded, with Content-Encoding removed
+// and Content-Length updated. Decoding stops once the body would exceed
+// maxSize bytes (DefaultMaxDecompressedSize if maxSize <= 0) and the
+// request is answered with 413; unsupported codings get 415 and corrupt
+// data 400.
+func DecompressRequests(next server.Handler, maxSize int) server.Handler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		codings := parseCodings(req.Headers.Get("content-encoding"))
+		if len(codings) == 0 {
+			next(w, req)
+			return
+		}
+
+		for _, coding := range codings {
+			if _, ok := decoders[coding]; !ok {
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				server.NegotiatedErrors(w, req, response.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding %q", coding))
+				return
+			}
+		}
+
+		body, err := decodeBody(req.Body, codings, maxSize)
+		switch {
+		case errors.Is(err, errTooLarge):
+			server.NegotiatedErrors(w, req, response.StatusRequestEntityTooLarge, err)
+			return
+		case err != nil:
+			server.NegotiatedErrors(w, req, response.StatusBadRequest, fmt.Errorf("invalid compressed body: %v", err))
+			return
+		}
+
+		req.Body = body
+		req.Headers.Delete("Content-Encoding")
+		req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+		next(w, req)
+	}
+}
+
+// parseCodings splits a Content-Encoding value into lowercase codings,
+// dropping identity.
+func parseCodings(value string) []string {
+	var codings []string
+	for _, coding := range headers.Tokens(value) {
+		coding = strings.ToLower(coding)
+		if coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// decodeBody undoes codings, which are listed in the order they were
+// applied, never producing more than maxSize bytes.
+func decodeBody(body []byte, codings []string, maxSize int) ([]byte, error) {
+	for i := len(codings) - 1; i >= 0; i-- {
+		r, err := decoders[codings[i]](bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		// Read one byte past the limit to tell "exactly maxSize" apart
+		// from "more than maxSize".
+		decoded, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxSize {
+			return nil, errTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
@@ -0,0 +1,115 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// upload sends body with the given Content-Encoding through
+// DecompressRequests and returns the response and what the handler saw.
+func upload(t *testing.T, encoding string, body []byte, maxSize int) (string, *request.Request) {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        body,
+	}
+	req.Headers.Set("Content-Encoding", encoding)
+	req.Headers.Set("Accept", "text/plain")
+
+	var seen *request.Request
+	h := DecompressRequests(func(w *response.Writer, req *request.Request) {
+		seen = req
+		w.WriteString("ok")
+	}, maxSize)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return out.String(), seen
+}
+
+func TestDecompressGzipBody(t *testing.T) {
+	resp, seen := upload(t, "gzip", gzipped(t, `{"hello":"world"}`), 0)
+	require.NotNil(t, seen)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, `{"hello":"world"}`, string(seen.Body))
+	assert.Equal(t, "", seen.Headers.Get("content-encoding"))
+	assert.Equal(t, "17", seen.Headers.Get("content-length"))
+}
+
+func TestDecompressDeflateBody(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte("zlib data"))
+	zw.Close()
+	_, seen := upload(t, "deflate", zbuf.Bytes(), 0)
+	require.NotNil(t, seen)
+	assert.Equal(t, "zlib data", string(seen.Body))
+
+	// Raw deflate without the zlib wrapper is accepted as well.
+	var fbuf bytes.Buffer
+	fw, _ := flate.NewWriter(&fbuf, flate.DefaultCompression)
+	fw.Write([]byte("raw data"))
+	fw.Close()
+	_, seen = upload(t, "deflate", fbuf.Bytes(), 0)
+	require.NotNil(t, seen)
+	assert.Equal(t, "raw data", string(seen.Body))
+}
+
+func TestDecompressStackedCodings(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(gzipped(t, "twice"))
+	zw.Close()
+
+	_, seen := upload(t, "gzip, deflate", zbuf.Bytes(), 0)
+	require.NotNil(t, seen)
+	assert.Equal(t, "twice", string(seen.Body))
+}
+
+func TestDecompressLimit(t *testing.T) {
+	bomb := gzipped(t, strings.Repeat("0", 1<<20))
+	resp, seen := upload(t, "gzip", bomb, 1024)
+	assert.Nil(t, seen)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 "))
+
+	_, seen = upload(t, "gzip", gzipped(t, strings.Repeat("0", 1024)), 1024)
+	assert.NotNil(t, seen)
+}
+
+func TestDecompressRejects(t *testing.T) {
+	resp, seen := upload(t, "br", []byte("whatever"), 0)
+	assert.Nil(t, seen)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 415 "))
+	assert.Contains(t, resp, "accept-encoding: gzip, deflate\r\n")
+
+	resp, seen = upload(t, "gzip", []byte("not gzip"), 0)
+	assert.Nil(t, seen)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "))
+
+	_, seen = upload(t, "identity", []byte("plain"), 0)
+	require.NotNil(t, seen)
+	assert.Equal(t, "plain", string(seen.Body))
+}
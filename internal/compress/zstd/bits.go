@@ -0,0 +1,45 @@
+package zstd
+
+// bitWriter builds the backward bitstreams used by Huffman-coded literals
+// and FSE-coded sequences: bits are packed least significant first and
+// the decoder reads them back starting from the final byte.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (b *bitWriter) addBits(value uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	b.bits |= (value & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+// close appends the end-of-stream marker bit and pads to a whole byte.
+func (b *bitWriter) close() []byte {
+	b.addBits(1, 1)
+	if b.nbits > 0 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits = 0
+		b.nbits = 0
+	}
+	return b.out
+}
+
+// highBit returns the position of the highest set bit of v, which must be
+// non-zero.
+func highBit(v uint32) uint {
+	n := uint(0)
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return n
+}
@@ -0,0 +1,131 @@
+package zstd
+
+// Predefined distributions from RFC 8878 section 3.1.1.3.2.2. Sequences
+// are always encoded in Predefined_Mode, so no tables are transmitted.
+var (
+	literalLengthDistribution = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchLengthDistribution = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetDistribution = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+var (
+	literalLengthTable = buildFSETable(literalLengthDistribution, 6)
+	matchLengthTable   = buildFSETable(matchLengthDistribution, 6)
+	offsetTable        = buildFSETable(offsetDistribution, 5)
+)
+
+// fseTransform tells the encoder how to move to the next state after
+// emitting a symbol.
+type fseTransform struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+// fseTable is an FSE encoding table built from a normalized distribution.
+type fseTable struct {
+	tableLog   uint
+	stateTable []uint16
+	transforms []fseTransform
+}
+
+func buildFSETable(norm []int16, tableLog uint) *fseTable {
+	tableSize := 1 << tableLog
+	tableMask := tableSize - 1
+	step := (tableSize >> 1) + (tableSize >> 3) + 3
+	highThreshold := tableSize - 1
+
+	// Symbols with "less than 1" probability take the last cells.
+	symbols := make([]int, tableSize)
+	cumul := make([]int, len(norm)+1)
+	for s, count := range norm {
+		if count == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbols[highThreshold] = s
+			highThreshold--
+		} else {
+			cumul[s+1] = cumul[s] + int(count)
+		}
+	}
+
+	// Spread the remaining symbols the same way the decoder does.
+	position := 0
+	for s, count := range norm {
+		for i := 0; i < int(count); i++ {
+			symbols[position] = s
+			position = (position + step) & tableMask
+			for position > highThreshold {
+				position = (position + step) & tableMask
+			}
+		}
+	}
+
+	t := &fseTable{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, tableSize),
+		transforms: make([]fseTransform, len(norm)),
+	}
+	for u := 0; u < tableSize; u++ {
+		s := symbols[u]
+		t.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, count := range norm {
+		switch count {
+		case 0:
+			t.transforms[s].deltaNbBits = uint32((tableLog+1)<<16) - uint32(tableSize)
+		case -1, 1:
+			t.transforms[s].deltaNbBits = uint32(tableLog<<16) - uint32(tableSize)
+			t.transforms[s].deltaFindState = total - 1
+			total++
+		default:
+			maxBitsOut := tableLog - highBit(uint32(count-1))
+			minStatePlus := uint32(count) << maxBitsOut
+			t.transforms[s].deltaNbBits = uint32(maxBitsOut<<16) - minStatePlus
+			t.transforms[s].deltaFindState = total - int32(count)
+			total += int32(count)
+		}
+	}
+	return t
+}
+
+// fseState is the running state of one FSE encoder.
+type fseState struct {
+	table *fseTable
+	value uint32
+}
+
+// init starts the encoder on the last symbol of the stream without
+// emitting any bits.
+func (st *fseState) init(table *fseTable, symbol uint8) {
+	st.table = table
+	tt := table.transforms[symbol]
+	nbBitsOut := (tt.deltaNbBits + (1 << 15)) >> 16
+	value := (nbBitsOut << 16) - tt.deltaNbBits
+	st.value = uint32(table.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (st *fseState) encode(b *bitWriter, symbol uint8) {
+	tt := st.table.transforms[symbol]
+	nbBitsOut := (st.value + tt.deltaNbBits) >> 16
+	b.addBits(uint64(st.value), uint(nbBitsOut))
+	st.value = uint32(st.table.stateTable[int32(st.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush writes the final state so the decoder can start from it.
+func (st *fseState) flush(b *bitWriter) {
+	b.addBits(uint64(st.value), st.table.tableLog)
+}
@@ -0,0 +1,166 @@
+package zstd
+
+import "container/heap"
+
+// maxHuffmanBits is the longest code zstd allows for literals.
+const maxHuffmanBits = 11
+
+// maxDirectWeights is how many weights the direct (4 bits per weight) tree
+// description can carry. Literals above this symbol value are sent raw.
+const maxDirectWeights = 128
+
+// huffmanTable holds canonical Huffman codes for literal bytes.
+type huffmanTable struct {
+	maxBits    uint
+	lastSymbol int
+	weights    [256]uint8
+	codes      [256]uint16
+	lengths    [256]uint8
+}
+
+// buildHuffmanTable returns codes for the literals, or nil if they cannot
+// be Huffman coded (a single distinct byte, or bytes the direct tree
+// description cannot represent).
+func buildHuffmanTable(literals []byte) *huffmanTable {
+	var counts [256]int
+	for _, b := range literals {
+		counts[b]++
+	}
+
+	lastSymbol, distinct := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			lastSymbol = s
+			distinct++
+		}
+	}
+	if distinct < 2 || lastSymbol > maxDirectWeights {
+		return nil
+	}
+
+	lengths := huffmanLengths(counts[:lastSymbol+1])
+	for maxLength(lengths) > maxHuffmanBits {
+		// Flatten the distribution until the tree is shallow enough.
+		for s := range counts[:lastSymbol+1] {
+			if counts[s] > 0 {
+				counts[s] = (counts[s] + 1) / 2
+			}
+		}
+		lengths = huffmanLengths(counts[:lastSymbol+1])
+	}
+
+	t := &huffmanTable{maxBits: uint(maxLength(lengths)), lastSymbol: lastSymbol}
+	for s, l := range lengths {
+		if l > 0 {
+			t.lengths[s] = uint8(l)
+			t.weights[s] = uint8(int(t.maxBits) + 1 - l)
+		}
+	}
+
+	// Canonical codes: lowest weight (longest code) first, then by symbol,
+	// matching the order the decoder fills its table in.
+	position := 0
+	for w := 1; w <= int(t.maxBits); w++ {
+		for s := 0; s <= lastSymbol; s++ {
+			if int(t.weights[s]) == w {
+				t.codes[s] = uint16(position >> (w - 1))
+				position += 1 << (w - 1)
+			}
+		}
+	}
+	return t
+}
+
+func maxLength(lengths []int) int {
+	m := 0
+	for _, l := range lengths {
+		if l > m {
+			m = l
+		}
+	}
+	return m
+}
+
+type huffmanNode struct {
+	count       int
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns the code length of every symbol with a non-zero
+// count. At least two symbols must be present.
+func huffmanLengths(counts []int) []int {
+	h := &huffmanHeap{}
+	for s, c := range counts {
+		if c > 0 {
+			*h = append(*h, &huffmanNode{count: c, symbol: s})
+		}
+	}
+	heap.Init(h)
+
+	next := len(counts)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*huffmanNode)
+		b := heap.Pop(h).(*huffmanNode)
+		heap.Push(h, &huffmanNode{count: a.count + b.count, symbol: next, left: a, right: b})
+		next++
+	}
+
+	lengths := make([]int, len(counts))
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(h).(*huffmanNode), 0)
+	return lengths
+}
+
+// description returns the tree description using direct weights: a
+// header byte, then 4 bits per weight for every symbol before the last.
+func (t *huffmanTable) description() []byte {
+	n := t.lastSymbol
+	out := make([]byte, 1, 1+(n+1)/2)
+	out[0] = byte(127 + n)
+	for i := 0; i < n; i += 2 {
+		b := t.weights[i] << 4
+		if i+1 < n {
+			b |= t.weights[i+1]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// encodeStream Huffman-codes src as one backward bitstream. Symbols are
+// written last to first so the decoder produces them in order.
+func (t *huffmanTable) encodeStream(src []byte) []byte {
+	var b bitWriter
+	for i := len(src) - 1; i >= 0; i-- {
+		s := src[i]
+		b.addBits(uint64(t.codes[s]), uint(t.lengths[s]))
+	}
+	return b.close()
+}
@@ -0,0 +1,411 @@
+// Package zstd implements a streaming Zstandard (RFC 8878) compressor.
+//
+// It trades ratio for simplicity: matches are found with a single hash
+// table inside each block, literals are Huffman coded when the bytes allow
+// a direct tree description, and sequences always use the predefined FSE
+// distributions. The output is a standard frame any decoder can read.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	magicNumber = 0xFD2FB528
+
+	// windowLog sets the window (and so the block size) to 128 KiB.
+	windowLog = 17
+	blockSize = 1 << windowLog
+
+	minMatch = 4
+	hashLog  = 15
+
+	blockTypeRaw        = 0
+	blockTypeCompressed = 2
+
+	literalsRaw        = 0
+	literalsRLE        = 1
+	literalsCompressed = 2
+)
+
+var errClosed = errors.New("zstd: writer is closed")
+
+// Writer compresses everything written to it into a single zstd frame.
+// Close must be called to end the frame.
+type Writer struct {
+	w           io.Writer
+	buf         []byte
+	wroteHeader bool
+	closed      bool
+	err         error
+	table       [1 << hashLog]int32
+}
+
+// NewWriter returns a Writer compressing into w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		take := blockSize - len(z.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		z.buf = append(z.buf, p[:take]...)
+		p = p[take:]
+		if len(z.buf) == blockSize {
+			if err := z.writeBlock(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes buffered data as a complete block so a reader can decode
+// everything written so far.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return errClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) == 0 && z.wroteHeader {
+		return nil
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the last block and ends the frame. It does not close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if z.err == nil {
+		z.err = z.writeBlock(true)
+	}
+	z.closed = true
+	return z.err
+}
+
+func (z *Writer) writeBlock(last bool) error {
+	var out []byte
+	if !z.wroteHeader {
+		out = binary.LittleEndian.AppendUint32(out, magicNumber)
+		// Frame header descriptor: no content size, not single segment,
+		// no checksum, no dictionary. Then the window descriptor.
+		out = append(out, 0, (windowLog-10)<<3)
+		z.wroteHeader = true
+	}
+
+	src := z.buf
+	compressed := z.compressBlock(src)
+	blockType := blockTypeCompressed
+	content := compressed
+	if compressed == nil || len(compressed) >= len(src) {
+		blockType = blockTypeRaw
+		content = src
+	}
+
+	header := uint32(len(content))<<3 | uint32(blockType)<<1
+	if last {
+		header |= 1
+	}
+	out = append(out, byte(header), byte(header>>8), byte(header>>16))
+	out = append(out, content...)
+
+	z.buf = z.buf[:0]
+	if _, err := z.w.Write(out); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// sequence is one LZ77 step: copy litLen literals, then matchLen bytes
+// from offset bytes back.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32
+}
+
+func hash4(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 2654435761) >> (32 - hashLog)
+}
+
+// compressBlock returns the content of a compressed block for src, or nil
+// if src is too small to be worth it.
+func (z *Writer) compressBlock(src []byte) []byte {
+	if len(src) < minMatch*2 {
+		return nil
+	}
+	for i := range z.table {
+		z.table[i] = 0
+	}
+
+	var literals []byte
+	var seqs []sequence
+	litStart := 0
+	i := 0
+	for i+minMatch <= len(src) {
+		h := hash4(src[i:])
+		candidate := int(z.table[h]) - 1
+		z.table[h] = int32(i + 1)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		start, from := i, candidate
+		for start > litStart && from > 0 && src[start-1] == src[from-1] {
+			start--
+			from--
+		}
+		end := i + minMatch
+		for end < len(src) && src[end] == src[from+end-start] {
+			end++
+		}
+
+		literals = append(literals, src[litStart:start]...)
+		seqs = append(seqs, sequence{
+			litLen:   uint32(start - litStart),
+			matchLen: uint32(end - start),
+			offset:   uint32(start - from),
+		})
+
+		// Index a couple of positions inside the match to help later ones.
+		for _, p := range []int{end - 2, end - 1} {
+			if p > i && p+minMatch <= len(src) {
+				z.table[hash4(src[p:])] = int32(p + 1)
+			}
+		}
+		i = end
+		litStart = end
+	}
+	literals = append(literals, src[litStart:]...)
+
+	out := encodeLiterals(nil, literals)
+	return encodeSequences(out, seqs)
+}
+
+// appendLiteralsHeader writes a Raw or RLE literals section header.
+func appendLiteralsHeader(dst []byte, blockType byte, size int) []byte {
+	switch {
+	case size < 32:
+		return append(dst, blockType|byte(size)<<3)
+	case size < 4096:
+		return append(dst, blockType|1<<2|byte(size&0xf)<<4, byte(size>>4))
+	default:
+		return append(dst, blockType|3<<2|byte(size&0xf)<<4, byte(size>>4), byte(size>>12))
+	}
+}
+
+func encodeLiterals(dst []byte, literals []byte) []byte {
+	if len(literals) > 1 {
+		same := true
+		for _, b := range literals[1:] {
+			if b != literals[0] {
+				same = false
+				break
+			}
+		}
+		if same {
+			dst = appendLiteralsHeader(dst, literalsRLE, len(literals))
+			return append(dst, literals[0])
+		}
+	}
+
+	if len(literals) >= 64 {
+		if huff := encodeHuffmanLiterals(dst, literals); huff != nil {
+			return huff
+		}
+	}
+
+	dst = appendLiteralsHeader(dst, literalsRaw, len(literals))
+	return append(dst, literals...)
+}
+
+// encodeHuffmanLiterals appends a Huffman-compressed literals section, or
+// returns nil if that would not beat sending the literals raw.
+func encodeHuffmanLiterals(dst []byte, literals []byte) []byte {
+	table := buildHuffmanTable(literals)
+	if table == nil {
+		return nil
+	}
+
+	payload := table.description()
+	regenerated := len(literals)
+	singleStream := regenerated <= 1023
+	if singleStream {
+		payload = append(payload, table.encodeStream(literals)...)
+	} else {
+		// Four streams, each a quarter of the literals, behind a jump
+		// table giving the sizes of the first three.
+		segment := (regenerated + 3) / 4
+		var streams [4][]byte
+		for k := 0; k < 4; k++ {
+			lo := k * segment
+			hi := lo + segment
+			if k == 3 {
+				hi = regenerated
+			}
+			streams[k] = table.encodeStream(literals[lo:hi])
+		}
+		for k := 0; k < 3; k++ {
+			if len(streams[k]) > 0xffff {
+				return nil
+			}
+			payload = binary.LittleEndian.AppendUint16(payload, uint16(len(streams[k])))
+		}
+		for k := 0; k < 4; k++ {
+			payload = append(payload, streams[k]...)
+		}
+	}
+
+	compressed := len(payload)
+	var header []byte
+	switch {
+	case singleStream && compressed <= 1023:
+		v := uint32(literalsCompressed) | uint32(regenerated)<<4 | uint32(compressed)<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case singleStream:
+		return nil
+	case regenerated <= 1023 && compressed <= 1023:
+		v := uint32(literalsCompressed) | 1<<2 | uint32(regenerated)<<4 | uint32(compressed)<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regenerated <= 16383 && compressed <= 16383:
+		v := uint32(literalsCompressed) | 2<<2 | uint32(regenerated)<<4 | uint32(compressed)<<18
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	default:
+		v := uint64(literalsCompressed) | 3<<2 | uint64(regenerated)<<4 | uint64(compressed)<<22
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32)}
+	}
+
+	if len(header)+compressed >= regenerated+3 {
+		return nil
+	}
+	dst = append(dst, header...)
+	return append(dst, payload...)
+}
+
+var (
+	literalLengthBase = []uint32{
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024,
+		2048, 4096, 8192, 16384, 32768, 65536,
+	}
+	literalLengthBits = []uint{
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10,
+		11, 12, 13, 14, 15, 16,
+	}
+	matchLengthBase = []uint32{
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515,
+		1027, 2051, 4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = []uint{
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9,
+		10, 11, 12, 13, 14, 15, 16,
+	}
+)
+
+// literalLengthCode returns the code, extra bit count and extra bit value
+// for a literal length.
+func literalLengthCode(ll uint32) (uint8, uint, uint32) {
+	if ll < 16 {
+		return uint8(ll), 0, 0
+	}
+	i := len(literalLengthBase) - 1
+	for literalLengthBase[i] > ll {
+		i--
+	}
+	return uint8(16 + i), literalLengthBits[i], ll - literalLengthBase[i]
+}
+
+func matchLengthCode(ml uint32) (uint8, uint, uint32) {
+	if ml < 35 {
+		return uint8(ml - 3), 0, 0
+	}
+	i := len(matchLengthBase) - 1
+	for matchLengthBase[i] > ml {
+		i--
+	}
+	return uint8(32 + i), matchLengthBits[i], ml - matchLengthBase[i]
+}
+
+// offsetCode never uses the repeat-offset codes: every offset is sent as
+// Offset_Value = offset + 3.
+func offsetCode(offset uint32) (uint8, uint, uint32) {
+	value := offset + 3
+	code := highBit(value)
+	return uint8(code), code, value - 1<<code
+}
+
+func encodeSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	// Predefined_Mode for literal lengths, offsets and match lengths.
+	dst = append(dst, 0)
+
+	type coded struct {
+		code  uint8
+		nbits uint
+		extra uint32
+	}
+	ll := make([]coded, n)
+	ml := make([]coded, n)
+	of := make([]coded, n)
+	for i, s := range seqs {
+		ll[i].code, ll[i].nbits, ll[i].extra = literalLengthCode(s.litLen)
+		ml[i].code, ml[i].nbits, ml[i].extra = matchLengthCode(s.matchLen)
+		of[i].code, of[i].nbits, of[i].extra = offsetCode(s.offset)
+	}
+
+	// The bitstream is written from the last sequence to the first.
+	var b bitWriter
+	var llState, mlState, ofState fseState
+	last := n - 1
+	mlState.init(matchLengthTable, ml[last].code)
+	ofState.init(offsetTable, of[last].code)
+	llState.init(literalLengthTable, ll[last].code)
+	b.addBits(uint64(ll[last].extra), ll[last].nbits)
+	b.addBits(uint64(ml[last].extra), ml[last].nbits)
+	b.addBits(uint64(of[last].extra), of[last].nbits)
+
+	for i := n - 2; i >= 0; i-- {
+		ofState.encode(&b, of[i].code)
+		mlState.encode(&b, ml[i].code)
+		llState.encode(&b, ll[i].code)
+		b.addBits(uint64(ll[i].extra), ll[i].nbits)
+		b.addBits(uint64(ml[i].extra), ml[i].nbits)
+		b.addBits(uint64(of[i].extra), of[i].nbits)
+	}
+
+	mlState.flush(&b)
+	ofState.flush(&b)
+	llState.flush(&b)
+	return append(dst, b.close()...)
+}
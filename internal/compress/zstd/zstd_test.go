@@ -0,0 +1,103 @@
+package zstd
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	z := NewWriter(&out)
+	for _, c := range chunks {
+		_, err := z.Write(c)
+		require.NoError(t, err)
+		require.NoError(t, z.Flush())
+	}
+	require.NoError(t, z.Close())
+	return out.Bytes()
+}
+
+// decompress runs the reference zstd tool, skipping the test without it.
+func decompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd binary not available")
+	}
+	cmd := exec.Command(path, "-d", "-c", "-q")
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.NoError(t, err, stderr.String())
+	return out
+}
+
+func testInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300000)
+	rng.Read(random)
+
+	words := []string{"alpha", "beta", "gamma", "delta", "<div>", "</div>", "\n", "{\"id\":", "}", " "}
+	var text strings.Builder
+	for text.Len() < 400000 {
+		text.WriteString(words[rng.Intn(len(words))])
+	}
+
+	binaryish := make([]byte, 200000)
+	for i := range binaryish {
+		binaryish[i] = byte(rng.Intn(8) * 37)
+	}
+
+	letters := make([]byte, 150000)
+	for i := range letters {
+		letters[i] = byte('a' + rng.Intn(26))
+	}
+
+	return map[string][]byte{
+		"letters":   letters,
+		"letters1k": letters[:900],
+		"empty":     {},
+		"tiny":      []byte("hi"),
+		"repeat":    bytes.Repeat([]byte("a"), 100000),
+		"text":      []byte(text.String()),
+		"random":    random,
+		"binaryish": binaryish,
+		"html":      bytes.Repeat([]byte("<html><body><h1>Success!</h1></body></html>\n"), 500),
+	}
+}
+
+func TestRoundTripWithReferenceDecoder(t *testing.T) {
+	for name, input := range testInputs() {
+		t.Run(name, func(t *testing.T) {
+			out := decompress(t, compress(t, input))
+			assert.True(t, bytes.Equal(input, out))
+		})
+	}
+}
+
+func TestFlushedChunksRoundTrip(t *testing.T) {
+	inputs := testInputs()
+	chunks := [][]byte{inputs["tiny"], inputs["html"], inputs["empty"], inputs["text"][:5000]}
+	out := decompress(t, compress(t, chunks...))
+	assert.Equal(t, bytes.Join(chunks, nil), out)
+}
+
+func TestCompressesRepetitiveInput(t *testing.T) {
+	input := testInputs()["html"]
+	assert.Less(t, len(compress(t, input)), len(input)/10)
+}
+
+func TestHuffmanCodesLiterals(t *testing.T) {
+	// Random letters have no matches, so only literal coding can shrink them.
+	letters := testInputs()["letters"]
+	assert.Less(t, len(compress(t, letters)), len(letters)*3/4)
+	assert.Less(t, len(compress(t, letters[:900])), 900*3/4)
+}
@@ -0,0 +1,180 @@
+// Package cookie reads the Cookie request header and builds Set-Cookie
+// response headers, following RFC 6265bis.
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+)
+
+const (
+	// maxNameValueSize is the most user agents store for a cookie's name
+	// and value together.
+	maxNameValueSize = 4096
+	// maxAttributeSize bounds Domain and Path.
+	maxAttributeSize = 1024
+
+	// timeFormat is the IMF-fixdate layout used for Expires.
+	timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out; browsers treat that as Lax.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone requires Secure.
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie to set in a response.
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves the attribute out; a negative
+	// value sends Max-Age=0, which deletes the cookie.
+	MaxAge int
+
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Valid reports whether c can be sent as it is: the name must be a token,
+// the value only cookie-octets, and the attributes must agree with each
+// other and with any __Secure- or __Host- name prefix.
+func (c *Cookie) Valid() error {
+	if c.Name == "" || !headers.IsKeyCharValid(c.Name) {
+		return fmt.Errorf("cookie: invalid name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("cookie %s: invalid value", c.Name)
+	}
+	if len(c.Name)+len(c.Value) > maxNameValueSize {
+		return fmt.Errorf("cookie %s: name and value exceed %d bytes", c.Name, maxNameValueSize)
+	}
+	if !validDomain(c.Domain) {
+		return fmt.Errorf("cookie %s: invalid domain %q", c.Name, c.Domain)
+	}
+	if !validPath(c.Path) {
+		return fmt.Errorf("cookie %s: invalid path %q", c.Name, c.Path)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %s: SameSite=None requires Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %s: Partitioned requires Secure", c.Name)
+	}
+
+	// Name prefixes are matched case-insensitively (section 4.1.3).
+	name := strings.ToLower(c.Name)
+	if strings.HasPrefix(name, "__secure-") && !c.Secure {
+		return fmt.Errorf("cookie %s: the __Secure- prefix requires Secure", c.Name)
+	}
+	if strings.HasPrefix(name, "__host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("cookie %s: the __Host- prefix requires Secure, Path=/ and no Domain", c.Name)
+	}
+	return nil
+}
+
+// String returns the Set-Cookie value for c. It does not check c; use
+// Valid for that.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// A leading dot is ignored by user agents, so it is not sent.
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(timeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// validValue reports whether v is made of cookie-octets, optionally in
+// double quotes.
+func validValue(v string) bool {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validDomain accepts host names: letters, digits, '-' and '.'.
+func validDomain(d string) bool {
+	if len(d) > maxAttributeSize {
+		return false
+	}
+	for _, c := range d {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// validPath rejects control characters and ';', which would end the
+// attribute.
+func validPath(p string) bool {
+	if len(p) > maxAttributeSize {
+		return false
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] < 0x20 || p[i] == 0x7f || p[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package cookie
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieString(t *testing.T) {
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		MaxAge:      3600,
+		Domain:      ".example.com",
+		Path:        "/app",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", c.String())
+
+	assert.Equal(t, "theme=dark", (&Cookie{Name: "theme", Value: "dark"}).String())
+	assert.Equal(t, "theme=; Max-Age=0", (&Cookie{Name: "theme", MaxAge: -1}).String())
+	assert.Equal(t, "a=b; SameSite=Strict", (&Cookie{Name: "a", Value: "b", SameSite: SameSiteStrict}).String())
+}
+
+func TestCookieValid(t *testing.T) {
+	valid := []*Cookie{
+		{Name: "a", Value: ""},
+		{Name: "a", Value: `"quoted"`},
+		{Name: "__Secure-id", Value: "1", Secure: true},
+		{Name: "__Host-id", Value: "1", Secure: true, Path: "/"},
+		{Name: "a", Value: "1", SameSite: SameSiteLax},
+	}
+	for _, c := range valid {
+		assert.NoError(t, c.Valid(), c.String())
+	}
+
+	invalid := map[string]*Cookie{
+		"empty name":              {Value: "1"},
+		"name with space":         {Name: "a b", Value: "1"},
+		"value with space":        {Name: "a", Value: "x y"},
+		"value with semicolon":    {Name: "a", Value: "x;y"},
+		"value with comma":        {Name: "a", Value: "x,y"},
+		"value with non-ascii":    {Name: "a", Value: "café"},
+		"too long":                {Name: "a", Value: strings.Repeat("x", 4096)},
+		"bad domain":              {Name: "a", Domain: "exa mple.com"},
+		"bad path":                {Name: "a", Path: "/a;b"},
+		"none without secure":     {Name: "a", SameSite: SameSiteNone},
+		"partitioned no secure":   {Name: "a", Partitioned: true},
+		"secure prefix":           {Name: "__Secure-id"},
+		"host prefix with domain": {Name: "__Host-id", Secure: true, Path: "/", Domain: "example.com"},
+		"host prefix path":        {Name: "__HOST-id", Secure: true, Path: "/app"},
+	}
+	for name, c := range invalid {
+		assert.Error(t, c.Valid(), name)
+	}
+}
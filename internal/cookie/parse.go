@@ -0,0 +1,52 @@
+package cookie
+
+import "strings"
+
+// Cookies are the cookies a request carries, by name. A name can appear
+// more than once when cookies with different paths or domains match; the
+// values keep the order the client sent them in, most specific path first.
+type Cookies map[string][]string
+
+// Parse reads a Cookie header value: name=value pairs separated by ";".
+// Following the lenient server-side rules of RFC 6265bis, pairs without a
+// name are skipped and values are kept as sent, minus surrounding quotes.
+func Parse(header string) Cookies {
+	cookies := Cookies{}
+	for pair := range strings.SplitSeq(header, ";") {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.Trim(name, " \t")
+		if !ok || name == "" {
+			continue
+		}
+		value = strings.Trim(value, " \t")
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies[name] = append(cookies[name], value)
+	}
+	return cookies
+}
+
+// Get returns the first value of the named cookie, or "" if there is none.
+// Names are case-sensitive.
+func (c Cookies) Get(name string) string {
+	if values := c[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Lookup is like Get but also reports whether the cookie was sent, to tell
+// a missing cookie from an empty one.
+func (c Cookies) Lookup(name string) (string, bool) {
+	values, ok := c[name]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Values returns every value sent for the named cookie.
+func (c Cookies) Values(name string) []string {
+	return c[name]
+}
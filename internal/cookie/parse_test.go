@@ -0,0 +1,31 @@
+package cookie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc; theme="dark";  empty=; id=1;id=2; noequals; =nameless; spaced = value `)
+	assert.Equal(t, Cookies{
+		"session": {"abc"},
+		"theme":   {"dark"},
+		"empty":   {""},
+		"id":      {"1", "2"},
+		"spaced":  {"value"},
+	}, cookies)
+
+	assert.Equal(t, "abc", cookies.Get("session"))
+	assert.Equal(t, "1", cookies.Get("id"))
+	assert.Equal(t, []string{"1", "2"}, cookies.Values("id"))
+	assert.Equal(t, "", cookies.Get("Session"))
+
+	value, ok := cookies.Lookup("empty")
+	assert.True(t, ok)
+	assert.Empty(t, value)
+	_, ok = cookies.Lookup("missing")
+	assert.False(t, ok)
+
+	assert.Empty(t, Parse(""))
+}
@@ -0,0 +1,298 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+const indexFile = "index.html"
+
+// FileServer serves the files of an fs.FS. Use New or NewDir to build one
+// and pass its Handle method to server.Serve.
+type FileServer struct {
+	root     fs.FS
+	listDirs bool
+}
+
+// Option configures a FileServer.
+type Option func(*FileServer)
+
+// WithDirectoryListing makes directories without an index.html render a
+// listing of their entries instead of a 404.
+func WithDirectoryListing() Option {
+	return func(fsrv *FileServer) {
+		fsrv.listDirs = true
+	}
+}
+
+// New returns a file server rooted at root.
+func New(root fs.FS, opts ...Option) *FileServer {
+	fsrv := &FileServer{root: root}
+	for _, opt := range opts {
+		opt(fsrv)
+	}
+	return fsrv
+}
+
+// NewDir returns a file server rooted at the directory dir.
+func NewDir(dir string, opts ...Option) *FileServer {
+	return New(os.DirFS(dir), opts...)
+}
+
+// Handle serves the file named by the request target.
+func (fsrv *FileServer) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		server.NegotiatedErrors(w, req, response.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.RequestLine.Method))
+		return
+	}
+
+	name, err := cleanPath(req.RequestLine.RequestTarget)
+	if err != nil {
+		server.NegotiatedErrors(w, req, response.StatusBadRequest, err)
+		return
+	}
+
+	f, err := fsrv.root.Open(name)
+	if err != nil {
+		fsrv.openError(w, req, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fsrv.openError(w, req, err)
+		return
+	}
+
+	if info.IsDir() {
+		fsrv.serveDir(w, req, name)
+		return
+	}
+	serveFile(w, req, name, f, info)
+}
+
+func (fsrv *FileServer) openError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		server.NegotiatedErrors(w, req, response.StatusNotFound, errors.New("file not found"))
+	case errors.Is(err, fs.ErrPermission):
+		server.NegotiatedErrors(w, req, response.StatusForbidden, errors.New("access denied"))
+	default:
+		server.NegotiatedErrors(w, req, response.StatusInternalError, errors.New("could not open file"))
+	}
+}
+
+// cleanPath turns a request target into a name for fs.FS.Open. Targets that
+// try to climb out of the root with ".." segments are rejected rather than
+// silently cleaned.
+func cleanPath(target string) (string, error) {
+	rawPath, _, _ := strings.Cut(target, "?")
+	p, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid path escape: %v", err)
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("path must be absolute: %s", p)
+	}
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", fmt.Errorf("invalid character in path")
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path escapes the root: %s", p)
+		}
+	}
+
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid path: %s", p)
+	}
+	return name, nil
+}
+
+func (fsrv *FileServer) serveDir(w *response.Writer, req *request.Request, name string) {
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if !strings.HasSuffix(target, "/") {
+		// Relative links inside the directory only resolve with the slash.
+		w.Header().Set("Location", url.PathEscape(path.Base(target))+"/")
+		w.WriteStatusLine(response.StatusMovedPermanently)
+		return
+	}
+
+	index := path.Join(name, indexFile)
+	if f, err := fsrv.root.Open(index); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			serveFile(w, req, index, f, info)
+			return
+		}
+	}
+
+	if !fsrv.listDirs {
+		server.NegotiatedErrors(w, req, response.StatusNotFound, errors.New("file not found"))
+		return
+	}
+
+	entries, err := fs.ReadDir(fsrv.root, name)
+	if err != nil {
+		fsrv.openError(w, req, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(target)
+	b.WriteString("<html>\n  <head><title>Index of " + title + "</title></head>\n  <body>\n")
+	b.WriteString("    <h1>Index of " + title + "</h1>\n    <ul>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: entryName}).EscapedPath()
+		b.WriteString(`      <li><a href="` + html.EscapeString(href) + `">` + html.EscapeString(entryName) + "</a></li>\n")
+	}
+	b.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteString(b.String())
+}
+
+// serveFile serves f, honoring Range requests when the file can be read at
+// arbitrary offsets.
+func serveFile(w *response.Writer, req *request.Request, name string, f fs.File, info fs.FileInfo) {
+	var content io.ReaderAt
+	switch v := f.(type) {
+	case io.ReaderAt:
+		content = v
+	case io.ReadSeeker:
+		content = &seekReaderAt{rs: v}
+	default:
+		serveStream(w, name, f, info)
+		return
+	}
+	size := info.Size()
+
+	contentType := typeByExtension(path.Ext(name))
+	if contentType == "" {
+		head := make([]byte, sniffLen)
+		n, err := content.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			w.WriteStatusLine(response.StatusInternalError)
+			return
+		}
+		contentType = DetectContentType(head[:n])
+	}
+
+	validator := response.Validator{
+		ETag:         response.FileETag(info.ModTime(), size),
+		LastModified: info.ModTime(),
+	}
+	if response.CheckPreconditions(w, req.RequestLine.Method, req.Headers, validator) {
+		return
+	}
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+
+	if rangeHeader := req.Headers.Get("range"); rangeHeader != "" && response.IfRangeMatches(req.Headers.Get("if-range"), validator) {
+		ranges, err := parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errUnsatisfiableRange):
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteStatusLine(response.StatusRequestedRangeNotSatisfiable)
+			return
+		case err == nil && len(ranges) == 1:
+			h.Set("Content-Type", contentType)
+			h.Set("Content-Range", ranges[0].contentRange(size))
+			h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+			w.WriteStatusLine(response.StatusPartialContent)
+			io.Copy(w, io.NewSectionReader(content, ranges[0].start, ranges[0].length))
+			return
+		case err == nil:
+			serveMultiRange(w, content, contentType, size, ranges)
+			return
+		}
+	}
+
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, io.NewSectionReader(content, 0, size))
+}
+
+// serveMultiRange writes a multipart/byteranges body with one part per
+// range. The parts are laid out up front so Content-Length is exact.
+func serveMultiRange(w *response.Writer, content io.ReaderAt, contentType string, size int64, ranges []byteRange) {
+	var boundaryBytes [16]byte
+	rand.Read(boundaryBytes[:])
+	boundary := hex.EncodeToString(boundaryBytes[:])
+
+	partHeaders := make([]string, len(ranges))
+	total := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = "\r\n--" + boundary + "\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			"Content-Range: " + r.contentRange(size) + "\r\n\r\n"
+		total += int64(len(partHeaders[i])) + r.length
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	total += int64(len(closing))
+
+	h := w.Header()
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(total, 10))
+	w.WriteStatusLine(response.StatusPartialContent)
+
+	for i, r := range ranges {
+		if _, err := w.WriteString(partHeaders[i]); err != nil {
+			return
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(content, r.start, r.length)); err != nil {
+			return
+		}
+	}
+	w.WriteString(closing)
+}
+
+// serveStream streams a file that cannot be read at offsets, so Range
+// requests are not supported for it.
+func serveStream(w *response.Writer, name string, f fs.File, info fs.FileInfo) {
+	var content io.Reader = f
+	contentType := typeByExtension(path.Ext(name))
+	if contentType == "" {
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			w.WriteStatusLine(response.StatusInternalError)
+			return
+		}
+		head = head[:n]
+		contentType = DetectContentType(head)
+		content = io.MultiReader(bytes.NewReader(head), f)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	io.Copy(w, content)
+}
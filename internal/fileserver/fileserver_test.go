@@ -0,0 +1,184 @@
+package fileserver
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFS = fstest.MapFS{
+	"hello.txt":          {Data: []byte("hello world"), ModTime: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"video.mp4":          {Data: []byte("\x00\x00\x00\x18ftypmp42")},
+	"noext":              {Data: []byte("\x89PNG\r\n\x1a\nrest")},
+	"site/index.html":    {Data: []byte("<h1>home</h1>")},
+	"docs/a b.txt":       {Data: []byte("a")},
+	"docs/sub/inner.txt": {Data: []byte("b")},
+}
+
+func serve(t *testing.T, fsrv *FileServer, method, target string) string {
+	t.Helper()
+	return serveWithHeaders(t, fsrv, method, target, headers.NewHeaders())
+}
+
+func serveWithHeaders(t *testing.T, fsrv *FileServer, method, target string, h headers.Headers) string {
+	t.Helper()
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+	}
+	fsrv.Handle(w, req)
+	require.NoError(t, w.Finish())
+	return out.String()
+}
+
+func TestServesFileWithExtensionType(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/hello.txt?x=1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	out = serve(t, New(testFS), "GET", "/video.mp4")
+	assert.Contains(t, out, "content-type: video/mp4\r\n")
+}
+
+func TestSniffsContentWithoutExtension(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/noext")
+	assert.Contains(t, out, "content-type: image/png\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n\x89PNG\r\n\x1a\nrest"))
+}
+
+func TestRejectsTraversal(t *testing.T) {
+	for _, target := range []string{"/../secret", "/docs/../../etc/passwd", "/%2e%2e/secret", "/docs/..%2f..%2fetc"} {
+		out := serve(t, New(testFS), "GET", target)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), target)
+	}
+}
+
+func TestMissingFileIs404(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/nope.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestServesIndexAndRedirectsDirectories(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/site/")
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "<h1>home</h1>"))
+
+	out = serve(t, New(testFS), "GET", "/site")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: site/\r\n")
+}
+
+func TestDirectoryListing(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/docs/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	out = serve(t, New(testFS, WithDirectoryListing()), "GET", "/docs/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, `<a href="a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, out, `<a href="sub/">sub/</a>`)
+}
+
+func TestRejectsOtherMethods(t *testing.T) {
+	out := serve(t, New(testFS), "POST", "/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD\r\n")
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "text/html; charset=utf-8", DetectContentType([]byte("  <!DOCTYPE html><html>")))
+	assert.Equal(t, "application/pdf", DetectContentType([]byte("%PDF-1.7")))
+	assert.Equal(t, "image/webp", DetectContentType([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, "text/plain; charset=utf-8", DetectContentType([]byte("just text")))
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte{0x01, 0x02}))
+}
+
+func rangeHeaders(pairs ...string) headers.Headers {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestSingleRange(t *testing.T) {
+	out := serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("Range", "bytes=0-4"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 0-4/11\r\n")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	out = serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("Range", "bytes=-5"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nworld"))
+}
+
+func TestMultipartRanges(t *testing.T) {
+	out := serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("Range", "bytes=0-1, 6-"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-type: multipart/byteranges; boundary=")
+	assert.Contains(t, out, "Content-Range: bytes 0-1/11\r\n\r\nhe\r\n--")
+	assert.Contains(t, out, "Content-Range: bytes 6-10/11\r\n\r\nworld\r\n--")
+
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	assert.Contains(t, out, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+}
+
+func TestUnsatisfiableRange(t *testing.T) {
+	out := serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("Range", "bytes=50-"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */11\r\n")
+}
+
+func TestIfRange(t *testing.T) {
+	out := serveWithHeaders(t, New(testFS), "GET", "/hello.txt",
+		rangeHeaders("Range", "bytes=0-4", "If-Range", "Thu, 02 Jan 2025 03:04:05 GMT"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+
+	out = serveWithHeaders(t, New(testFS), "GET", "/hello.txt",
+		rangeHeaders("Range", "bytes=0-4", "If-Range", "Fri, 03 Jan 2025 03:04:05 GMT"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "hello world"))
+}
+
+func TestConditionalGet(t *testing.T) {
+	out := serve(t, New(testFS), "GET", "/hello.txt")
+	assert.Contains(t, out, "last-modified: Thu, 02 Jan 2025 03:04:05 GMT\r\n")
+	etag := etagOf(out)
+	assert.True(t, strings.HasPrefix(etag, `"`), "file ETags must be strong")
+
+	out = serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("If-None-Match", etag))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	out = serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("If-Modified-Since", "Thu, 02 Jan 2025 03:04:05 GMT"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+}
+
+func etagOf(out string) string {
+	etag := out[strings.Index(out, "etag: ")+len("etag: "):]
+	return etag[:strings.Index(etag, "\r\n")]
+}
+
+func TestETagResumesDownload(t *testing.T) {
+	etag := etagOf(serve(t, New(testFS), "GET", "/hello.txt"))
+
+	out := serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("Range", "bytes=6-", "If-Range", etag))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nworld"))
+
+	out = serveWithHeaders(t, New(testFS), "GET", "/hello.txt", rangeHeaders("If-Match", etag))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "hello world"))
+}
@@ -0,0 +1,135 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// sniffLen is how many leading bytes DetectContentType looks at.
+const sniffLen = 512
+
+// extensionTypes covers common static and media files that the platform's
+// MIME table does not always know about.
+var extensionTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/vnd.microsoft.icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".m4a":   "audio/mp4",
+	".md":    "text/markdown; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".mov":   "video/quicktime",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".ogg":   "audio/ogg",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".wav":   "audio/wav",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+	".zip":   "application/zip",
+}
+
+// typeByExtension returns the MIME type for a file extension, or "" if it
+// is unknown.
+func typeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// signature matches content by a fixed prefix, optionally at an offset.
+type signature struct {
+	offset      int
+	prefix      []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("BM"), "image/bmp"},
+	{0, []byte("\x00\x00\x01\x00"), "image/x-icon"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{0, []byte("OggS\x00"), "application/ogg"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x1f\x8b\x08"), "application/x-gzip"},
+	{0, []byte("wOFF"), "font/woff"},
+	{0, []byte("wOF2"), "font/woff2"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{4, []byte("ftyp"), "video/mp4"},
+}
+
+// htmlPrefixes are the tags that mark a document as HTML when they open it.
+var htmlPrefixes = []string{
+	"<!doctype html", "<html", "<head", "<script", "<iframe", "<h1", "<div",
+	"<font", "<table", "<a", "<style", "<title", "<b", "<body", "<br", "<p",
+	"<!--",
+}
+
+// DetectContentType guesses the MIME type of data from its first bytes,
+// following the spirit of the WHATWG MIME sniffing rules. It always returns
+// a valid type, falling back to application/octet-stream.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.prefix) && bytes.Equal(data[sig.offset:sig.offset+len(sig.prefix)], sig.prefix) {
+			return sig.contentType
+		}
+	}
+	if len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) {
+		switch string(data[8:12]) {
+		case "WEBP":
+			return "image/webp"
+		case "WAVE":
+			return "audio/wav"
+		case "AVI ":
+			return "video/avi"
+		}
+	}
+
+	text := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := strings.ToLower(string(text))
+	for _, prefix := range htmlPrefixes {
+		if strings.HasPrefix(lower, prefix) && len(lower) > len(prefix) {
+			if next := lower[len(prefix)]; next == ' ' || next == '>' {
+				return "text/html; charset=utf-8"
+			}
+		}
+	}
+	if strings.HasPrefix(lower, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		return "text/plain; charset=utf-8"
+	}
+	for _, b := range data {
+		if b <= 0x08 || b == 0x0b || (b >= 0x0e && b <= 0x1a) || (b >= 0x1c && b <= 0x1f) {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,46 @@
+package fileserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	ranges, err := parseRange("bytes=0-499", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 500}}, ranges)
+
+	ranges, err = parseRange("bytes=500-, -100", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{500, 500}, {900, 100}}, ranges)
+
+	// End past the content is clamped.
+	ranges, err = parseRange("bytes=990-2000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{990, 10}}, ranges)
+
+	// A suffix longer than the content covers all of it.
+	ranges, err = parseRange("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 1000}}, ranges)
+
+	// Ranges past the end are dropped when others remain.
+	ranges, err = parseRange("bytes=2000-3000, 0-0", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 1}}, ranges)
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	_, err := parseRange("bytes=1000-", 1000)
+	assert.ErrorIs(t, err, errUnsatisfiableRange)
+
+	_, err = parseRange("bytes=-0", 1000)
+	assert.ErrorIs(t, err, errUnsatisfiableRange)
+
+	for _, header := range []string{"items=0-1", "bytes=5-1", "bytes=abc", "bytes=1-x"} {
+		_, err = parseRange(header, 1000)
+		assert.ErrorIs(t, err, errInvalidRange, header)
+	}
+}
@@ -0,0 +1,22 @@
+package grpc
+
+import "encoding/json"
+
+// Codec marshals messages for one content-subtype: a request with
+// Content-Type application/grpc+json uses the codec named "json". Plain
+// application/grpc means "proto", which callers provide with WithCodec
+// since this package has no protobuf runtime.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes messages as JSON. Servers have it unless replaced.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
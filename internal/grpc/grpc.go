@@ -0,0 +1,339 @@
+// Package grpc serves gRPC unary and server-streaming calls on top of the
+// server's HTTP/2 support. It implements the wire protocol only: messages
+// are length-prefixed, the outcome travels in grpc-status and grpc-message
+// trailers, and marshalling is left to a Codec.
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultMaxMessageSize bounds each message received or sent, before
+// compression.
+const DefaultMaxMessageSize = 4 << 20
+
+// messageHeaderLen is the compressed flag plus the 4-byte length that
+// precede every message.
+const messageHeaderLen = 5
+
+// Server dispatches gRPC calls to the methods registered on it.
+type Server struct {
+	codecs         map[string]Codec
+	methods        map[string]methodHandler
+	maxMessageSize int
+}
+
+// methodHandler runs a call given its encoded request message.
+type methodHandler func(c *call, payload []byte) error
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithCodec adds c, or replaces the codec with the same name.
+func WithCodec(c Codec) Option {
+	return func(s *Server) {
+		s.codecs[c.Name()] = c
+	}
+}
+
+// WithMaxMessageSize replaces DefaultMaxMessageSize.
+func WithMaxMessageSize(n int) Option {
+	return func(s *Server) {
+		s.maxMessageSize = n
+	}
+}
+
+// NewServer returns a server with no methods and the JSON codec.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		codecs:         map[string]Codec{"json": JSONCodec{}},
+		methods:        map[string]methodHandler{},
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Unary registers fn for fullMethod, e.g. "/helloworld.Greeter/SayHello".
+func Unary[Req, Resp any](s *Server, fullMethod string, fn func(ctx context.Context, req *Req) (*Resp, error)) {
+	s.methods[fullMethod] = func(c *call, payload []byte) error {
+		req := new(Req)
+		if err := c.codec.Unmarshal(payload, req); err != nil {
+			return Errorf(Internal, "decoding request: %v", err)
+		}
+		resp, err := fn(c.ctx, req)
+		if err != nil {
+			return err
+		}
+		return c.send(resp)
+	}
+}
+
+// ServerStreaming registers fn for fullMethod. fn sends any number of
+// responses on stream before returning.
+func ServerStreaming[Req, Resp any](s *Server, fullMethod string, fn func(req *Req, stream *ServerStream[Resp]) error) {
+	s.methods[fullMethod] = func(c *call, payload []byte) error {
+		req := new(Req)
+		if err := c.codec.Unmarshal(payload, req); err != nil {
+			return Errorf(Internal, "decoding request: %v", err)
+		}
+		return fn(req, &ServerStream[Resp]{c: c})
+	}
+}
+
+// ServerStream sends the responses of a server-streaming call.
+type ServerStream[T any] struct {
+	c *call
+}
+
+// Context is cancelled when the deadline passes or the client goes away.
+func (s *ServerStream[T]) Context() context.Context {
+	return s.c.ctx
+}
+
+// Send sends one response message and flushes it to the client.
+func (s *ServerStream[T]) Send(msg *T) error {
+	return s.c.send(msg)
+}
+
+type requestKey struct{}
+
+// RequestFromContext returns the HTTP request behind a call, for handlers
+// that need its metadata.
+func RequestFromContext(ctx context.Context) *request.Request {
+	req, _ := ctx.Value(requestKey{}).(*request.Request)
+	return req
+}
+
+// Handler wraps next so requests with a gRPC Content-Type are served by s
+// and everything else still goes to next.
+func (s *Server) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if _, ok := contentSubtype(req.Headers.Get("content-type")); ok {
+			s.Serve(w, req)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// Serve answers one gRPC call. Requests that are not gRPC at all get
+// plain HTTP errors; everything else gets a grpc-status.
+func (s *Server) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		server.NegotiatedErrors(w, req, response.StatusMethodNotAllowed, fmt.Errorf("gRPC requires POST, not %s", req.RequestLine.Method))
+		return
+	}
+	subtype, ok := contentSubtype(req.Headers.Get("content-type"))
+	codec := s.codecs[subtype]
+	if !ok || codec == nil {
+		server.NegotiatedErrors(w, req, response.StatusUnsupportedMediaType, fmt.Errorf("no codec for %q", req.Headers.Get("content-type")))
+		return
+	}
+
+	c := &call{w: w, codec: codec, maxSize: s.maxMessageSize, contentType: "application/grpc"}
+	if subtype != "proto" {
+		c.contentType += "+" + subtype
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestKey{}, req))
+	defer cancel()
+	c.ctx = ctx
+	if value := req.Headers.Get("grpc-timeout"); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			c.finish(Errorf(Internal, "%v", err))
+			return
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if notify := w.CloseNotify(); notify != nil {
+		go func() {
+			select {
+			case <-notify:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	c.ctx = ctx
+
+	c.finish(s.dispatch(c, req))
+}
+
+func (s *Server) dispatch(c *call, req *request.Request) error {
+	handle, ok := s.methods[req.RequestLine.RequestTarget]
+	if !ok {
+		return Errorf(Unimplemented, "unknown method %s", req.RequestLine.RequestTarget)
+	}
+	payload, err := s.readMessage(req)
+	if err != nil {
+		return err
+	}
+	return handle(c, payload)
+}
+
+// readMessage returns the single request message in req's body.
+func (s *Server) readMessage(req *request.Request) ([]byte, error) {
+	body := req.Body
+	if len(body) < messageHeaderLen {
+		return nil, Errorf(Internal, "expected one request message, got %d bytes", len(body))
+	}
+	compressed := body[0] == 1
+	length := binary.BigEndian.Uint32(body[1:])
+	if int64(length) > int64(s.maxMessageSize) {
+		return nil, Errorf(ResourceExhausted, "request message of %d bytes exceeds the limit of %d", length, s.maxMessageSize)
+	}
+	if rest := body[messageHeaderLen:]; uint32(len(rest)) != length {
+		return nil, Errorf(Internal, "expected one request message of %d bytes, got %d", length, len(rest))
+	}
+	payload := body[messageHeaderLen:]
+	if !compressed {
+		return payload, nil
+	}
+
+	switch encoding := req.Headers.Get("grpc-encoding"); encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, Errorf(Internal, "decompressing request: %v", err)
+		}
+		payload, err = io.ReadAll(io.LimitReader(zr, int64(s.maxMessageSize)+1))
+		if err != nil {
+			return nil, Errorf(Internal, "decompressing request: %v", err)
+		}
+		if len(payload) > s.maxMessageSize {
+			return nil, Errorf(ResourceExhausted, "request message exceeds the limit of %d bytes", s.maxMessageSize)
+		}
+		return payload, nil
+	case "", "identity":
+		return nil, Errorf(Internal, "compressed message without grpc-encoding")
+	default:
+		return nil, Errorf(Unimplemented, "unsupported grpc-encoding %q", encoding)
+	}
+}
+
+// call is the response side of one gRPC call.
+type call struct {
+	w           *response.Writer
+	codec       Codec
+	ctx         context.Context
+	maxSize     int
+	contentType string
+	started     bool
+}
+
+// start sends the response headers, which are the same for every call.
+func (c *call) start() {
+	h := c.w.Header()
+	h.Set("Content-Type", c.contentType)
+	h.Set("Grpc-Accept-Encoding", "gzip")
+	h.Set("Trailer", "Grpc-Status, Grpc-Message")
+	c.started = true
+}
+
+func (c *call) send(msg any) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	data, err := c.codec.Marshal(msg)
+	if err != nil {
+		return Errorf(Internal, "encoding response: %v", err)
+	}
+	if len(data) > c.maxSize {
+		return Errorf(ResourceExhausted, "response message of %d bytes exceeds the limit of %d", len(data), c.maxSize)
+	}
+	if !c.started {
+		c.start()
+	}
+
+	frame := make([]byte, messageHeaderLen, messageHeaderLen+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	if _, err := c.w.WriteChunkedBody(append(frame, data...)); err != nil {
+		return Errorf(Unavailable, "%v", err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return Errorf(Unavailable, "%v", err)
+	}
+	return nil
+}
+
+// finish ends the response with the status for err. Calls that fail
+// before sending anything still get headers followed by trailers, so
+// clients always find the status in the trailers.
+func (c *call) finish(err error) {
+	if !c.started {
+		c.start()
+	}
+	status := statusOf(err)
+	if _, err := c.w.WriteChunkedBodyDone(); err != nil {
+		return
+	}
+	trailers := headers.NewHeaders()
+	trailers.Set("Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		trailers.Set("Grpc-Message", encodeMessage(status.Message))
+	}
+	c.w.WriteTrailers(trailers)
+}
+
+// contentSubtype returns the codec name selected by a gRPC Content-Type,
+// and false if contentType is not gRPC.
+func contentSubtype(contentType string) (string, bool) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	rest, ok := strings.CutPrefix(mediaType, "application/grpc")
+	switch {
+	case !ok:
+		return "", false
+	case rest == "":
+		return "proto", true
+	case strings.HasPrefix(rest, "+"):
+		return rest[1:], true
+	}
+	// e.g. application/grpc-web
+	return "", false
+}
+
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeout parses a grpc-timeout value: up to 8 digits and a unit.
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("malformed grpc-timeout %q", value)
+	}
+	unit, ok := timeoutUnits[value[len(value)-1]]
+	n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("malformed grpc-timeout %q", value)
+	}
+	// Very long timeouts saturate instead of overflowing.
+	if time.Duration(n) > time.Duration(1<<63-1)/unit {
+		return time.Duration(1<<63 - 1), nil
+	}
+	return time.Duration(n) * unit, nil
+}
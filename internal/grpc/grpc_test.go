@@ -0,0 +1,275 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type greetRequest struct {
+	Name  string `json:"name"`
+	Count int    `json:"count,omitempty"`
+}
+
+type greetReply struct {
+	Message string `json:"message"`
+}
+
+func newTestServer(opts ...Option) *Server {
+	s := NewServer(opts...)
+	Unary(s, "/test.Greeter/Greet", func(ctx context.Context, req *greetRequest) (*greetReply, error) {
+		if req.Name == "" {
+			return nil, Errorf(InvalidArgument, "name is required: 100%% sure")
+		}
+		return &greetReply{Message: "hello " + req.Name}, nil
+	})
+	ServerStreaming(s, "/test.Greeter/GreetMany", func(req *greetRequest, stream *ServerStream[greetReply]) error {
+		for i := range req.Count {
+			if err := stream.Send(&greetReply{Message: fmt.Sprintf("hello %s #%d", req.Name, i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	Unary(s, "/test.Greeter/Wait", func(ctx context.Context, req *greetRequest) (*greetReply, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	return s
+}
+
+// serve runs s behind an h2c server and returns its base URL and a client
+// that speaks HTTP/2 to it.
+func serve(t *testing.T, s *Server) (string, *http.Client) {
+	t.Helper()
+	srv, err := server.Serve(0, s.Handler(func(w *response.Writer, req *request.Request) {
+		w.WriteString("not grpc")
+	}), server.WithH2C())
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+	return "http://127.0.0.1:" + port, &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func frame(compressed bool, msg []byte) []byte {
+	out := make([]byte, messageHeaderLen, messageHeaderLen+len(msg))
+	if compressed {
+		out[0] = 1
+	}
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+// result is a finished call as the client saw it.
+type result struct {
+	resp     *http.Response
+	messages []string
+	status   string
+	message  string
+}
+
+func invoke(t *testing.T, client *http.Client, url string, body []byte, header map[string]string) result {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc+json")
+	req.Header.Set("TE", "trailers")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	r := result{resp: resp, status: resp.Trailer.Get("Grpc-Status"), message: resp.Trailer.Get("Grpc-Message")}
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), messageHeaderLen)
+		n := binary.BigEndian.Uint32(data[1:])
+		r.messages = append(r.messages, string(data[messageHeaderLen:messageHeaderLen+n]))
+		data = data[messageHeaderLen+n:]
+	}
+	return r
+}
+
+func TestUnary(t *testing.T) {
+	base, client := serve(t, newTestServer())
+	r := invoke(t, client, base+"/test.Greeter/Greet", frame(false, []byte(`{"name":"ada"}`)), nil)
+
+	assert.Equal(t, 2, r.resp.ProtoMajor)
+	assert.Equal(t, 200, r.resp.StatusCode)
+	assert.Equal(t, "application/grpc+json", r.resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{`{"message":"hello ada"}`}, r.messages)
+	assert.Equal(t, "0", r.status)
+	assert.Empty(t, r.message)
+}
+
+func TestUnaryError(t *testing.T) {
+	base, client := serve(t, newTestServer())
+	r := invoke(t, client, base+"/test.Greeter/Greet", frame(false, []byte(`{}`)), nil)
+	assert.Empty(t, r.messages)
+	assert.Equal(t, "3", r.status)
+	assert.Equal(t, "name is required: 100%25 sure", r.message)
+}
+
+func TestServerStreaming(t *testing.T) {
+	base, client := serve(t, newTestServer())
+	r := invoke(t, client, base+"/test.Greeter/GreetMany", frame(false, []byte(`{"name":"bob","count":3}`)), nil)
+	assert.Equal(t, []string{
+		`{"message":"hello bob #0"}`,
+		`{"message":"hello bob #1"}`,
+		`{"message":"hello bob #2"}`,
+	}, r.messages)
+	assert.Equal(t, "0", r.status)
+}
+
+func TestDeadline(t *testing.T) {
+	base, client := serve(t, newTestServer())
+	start := time.Now()
+	r := invoke(t, client, base+"/test.Greeter/Wait", frame(false, []byte(`{}`)), map[string]string{"Grpc-Timeout": "50m"})
+	assert.Equal(t, "4", r.status)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	r = invoke(t, client, base+"/test.Greeter/Wait", frame(false, []byte(`{}`)), map[string]string{"Grpc-Timeout": "soon"})
+	assert.Equal(t, "13", r.status)
+}
+
+func TestCompressedRequest(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"name":"zip"}`))
+	zw.Close()
+
+	base, client := serve(t, newTestServer())
+	r := invoke(t, client, base+"/test.Greeter/Greet", frame(true, buf.Bytes()), map[string]string{"Grpc-Encoding": "gzip"})
+	assert.Equal(t, []string{`{"message":"hello zip"}`}, r.messages)
+
+	r = invoke(t, client, base+"/test.Greeter/Greet", frame(true, buf.Bytes()), map[string]string{"Grpc-Encoding": "snappy"})
+	assert.Equal(t, "12", r.status)
+}
+
+func TestCallErrors(t *testing.T) {
+	base, client := serve(t, newTestServer(WithMaxMessageSize(64)))
+	tests := map[string]struct {
+		path string
+		body []byte
+		want Code
+	}{
+		"unknown method":    {"/test.Greeter/Nope", frame(false, []byte(`{}`)), Unimplemented},
+		"no message":        {"/test.Greeter/Greet", nil, Internal},
+		"two messages":      {"/test.Greeter/Greet", append(frame(false, []byte(`{}`)), frame(false, []byte(`{}`))...), Internal},
+		"message too large": {"/test.Greeter/Greet", frame(false, bytes.Repeat([]byte(" "), 100)), ResourceExhausted},
+		"bad message":       {"/test.Greeter/Greet", frame(false, []byte(`{`)), Internal},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := invoke(t, client, base+tt.path, tt.body, nil)
+			assert.Equal(t, 200, r.resp.StatusCode)
+			assert.Equal(t, fmt.Sprint(int(tt.want)), r.status)
+		})
+	}
+}
+
+func TestNotGRPC(t *testing.T) {
+	base, client := serve(t, newTestServer())
+
+	resp, err := client.Get(base + "/test.Greeter/Greet")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "not grpc", string(body))
+
+	// Plain application/grpc means protobuf, which needs a codec.
+	resp, err = client.Post(base+"/test.Greeter/Greet", "application/grpc", bytes.NewReader(frame(false, nil)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 415, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", base+"/test.Greeter/Greet", nil)
+	req.Header.Set("Content-Type", "application/grpc+json")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "POST", resp.Header.Get("Allow"))
+}
+
+// upperCodec stands in for a protobuf codec: it claims "proto" and
+// upper-cases JSON on the way out.
+type upperCodec struct{ JSONCodec }
+
+func (upperCodec) Name() string { return "proto" }
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return bytes.ToUpper(data), err
+}
+
+func TestCustomCodec(t *testing.T) {
+	base, client := serve(t, newTestServer(WithCodec(upperCodec{})))
+	r := invoke(t, client, base+"/test.Greeter/Greet", frame(false, []byte(`{"name":"ada"}`)), map[string]string{"Content-Type": "application/grpc"})
+	assert.Equal(t, "application/grpc", r.resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{`{"MESSAGE":"HELLO ADA"}`}, r.messages)
+}
+
+func TestContentSubtype(t *testing.T) {
+	tests := map[string]string{
+		"application/grpc":                "proto",
+		"application/grpc+json":           "json",
+		"Application/GRPC+Proto; x=y":     "proto",
+		"application/grpc; charset=utf-8": "proto",
+	}
+	for contentType, want := range tests {
+		got, ok := contentSubtype(contentType)
+		assert.True(t, ok, contentType)
+		assert.Equal(t, want, got, contentType)
+	}
+	for _, contentType := range []string{"", "application/json", "application/grpc-web", "application/grpcx"} {
+		_, ok := contentSubtype(contentType)
+		assert.False(t, ok, contentType)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"1H":        time.Hour,
+		"30S":       30 * time.Second,
+		"250m":      250 * time.Millisecond,
+		"7u":        7 * time.Microsecond,
+		"99999999n": 99999999 * time.Nanosecond,
+	}
+	for value, want := range tests {
+		got, err := parseTimeout(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, got, value)
+	}
+	for _, value := range []string{"", "S", "10", "10s", "123456789S", "-1S"} {
+		_, err := parseTimeout(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	assert.Equal(t, "plain text", encodeMessage("plain text"))
+	assert.Equal(t, "50%25 caf%C3%A9%0A", encodeMessage("50% café\n"))
+}
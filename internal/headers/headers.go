@@ -80,12 +80,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 			return 0, false, fmt.Errorf("key contains invalid character in header key")
 		}
 
-		key := strings.ToLower(name)
-		if oldValue, exists := h[key]; exists {
-			h[key] = oldValue + "," + value
-		} else {
-			h[key] = value
-		}
+		h.Add(name, value)
 
 	}
 	return read, done, nil
@@ -94,3 +89,47 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 func (h Headers) Set(key, value string) {
 	h[strings.ToLower(key)] = value
 }
+
+func (h Headers) Delete(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// Add adds value to key. Repeated fields are combined into one value, which
+// is comma-separated for most fields. Cookie crumbs are joined with "; ",
+// as RFC 9113 section 8.2.3 does. Set-Cookie values cannot be combined at
+// all (RFC 6265 section 3); responses keep them outside Headers.
+func (h Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+	oldValue, exists := h[key]
+	switch {
+	case !exists:
+		h[key] = value
+	case key == "cookie":
+		h[key] = oldValue + "; " + value
+	default:
+		h[key] = oldValue + "," + value
+	}
+}
+
+// Tokens splits a comma-separated field value such as Connection or Vary
+// into its trimmed, non-empty elements.
+func Tokens(value string) []string {
+	var tokens []string
+	for token := range strings.SplitSeq(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// HasToken reports whether the comma-separated value lists token,
+// ignoring case.
+func HasToken(value, token string) bool {
+	for _, t := range Tokens(value) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -55,3 +55,22 @@ func TestInvalidCharacterInHeaderKey(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersRepeatedCookie(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Cookie: x=1\r\nCookie: y=2\r\nAccept: a\r\nAccept: b\r\n\r\n")
+	_, done, err := h.Parse(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "x=1; y=2", h.Get("cookie"))
+	assert.Equal(t, "a,b", h.Get("accept"))
+}
+
+func TestTokens(t *testing.T) {
+	assert.Equal(t, []string{"keep-alive", "Upgrade", "x"}, Tokens(" keep-alive ,, Upgrade,x,"))
+	assert.Empty(t, Tokens(""))
+
+	assert.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	assert.False(t, HasToken("keep-alive, upgrade-insecure", "upgrade"))
+	assert.False(t, HasToken("", "close"))
+}
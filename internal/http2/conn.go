@@ -0,0 +1,686 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/RayanMalki/tcptohttp/internal/http2/hpack"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+)
+
+var errConnClosed = errors.New("http2: connection closed")
+
+// serverConn is one HTTP/2 connection. The read loop in serve owns the
+// decoder and the stream bookkeeping that only it changes; handlers write
+// their frames through writeMu.
+type serverConn struct {
+	srv        *Server
+	br         *bufio.Reader
+	remoteAddr string
+	handlers   sync.WaitGroup
+
+	// Read loop only.
+	dec         *hpack.Decoder
+	maxStreamID uint32 // highest stream the client opened
+	recvWindow  int64  // connection-level window for incoming DATA, given back as it arrives
+	goingAway   bool   // the client sent GOAWAY
+
+	// A header block still waiting for CONTINUATION frames.
+	headerStream    uint32
+	headerBlock     []byte
+	headerEndStream bool
+
+	writeMu  sync.Mutex
+	bw       *bufio.Writer
+	enc      *hpack.Encoder
+	writeErr error
+
+	// mu guards the fields below; cond is signalled whenever a send
+	// window grows or a stream or the connection ends.
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+}
+
+func newServerConn(s *Server, rw io.ReadWriter, remoteAddr string) *serverConn {
+	sc := &serverConn{
+		srv:               s,
+		br:                bufio.NewReader(rw),
+		remoteAddr:        remoteAddr,
+		dec:               hpack.NewDecoder(hpack.DefaultTableSize),
+		recvWindow:        defaultWindowSize,
+		bw:                bufio.NewWriter(rw),
+		enc:               hpack.NewEncoder(),
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// writeSettings sends the server's connection preface.
+func (sc *serverConn) writeSettings() error {
+	payload := appendSettings(nil,
+		setting{settingMaxConcurrentStreams, sc.srv.maxConcurrentStreams},
+		setting{settingInitialWindowSize, sc.srv.initialWindowSize},
+		setting{settingEnablePush, 0},
+	)
+	if err := sc.writeFrame(frameSettings, 0, 0, payload); err != nil {
+		return err
+	}
+	return sc.flush()
+}
+
+// serve reads the client preface and then frames until the connection
+// ends.
+func (sc *serverConn) serve() error {
+	err := sc.readPreface()
+	for err == nil {
+		var h frameHeader
+		var payload []byte
+		if h, payload, err = readFrame(sc.br, defaultMaxFrameSize); err == nil {
+			err = sc.processFrame(h, payload)
+		}
+
+		var se streamError
+		if errors.As(err, &se) {
+			err = sc.resetStream(se.streamID, se.code)
+		}
+	}
+
+	var ce connError
+	if errors.As(err, &ce) {
+		sc.writeGoAway(ce.code)
+	}
+	sc.shutdown()
+	sc.handlers.Wait()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// readPreface reads the client preface, which must be followed by a
+// SETTINGS frame.
+func (sc *serverConn) readPreface() error {
+	buf := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.br, buf); err != nil {
+		return err
+	}
+	if string(buf) != ClientPreface {
+		return connError{ErrCodeProtocol, errBadPreface.Error()}
+	}
+	h, payload, err := readFrame(sc.br, defaultMaxFrameSize)
+	if err != nil {
+		return err
+	}
+	if h.typ != frameSettings || h.has(flagAck) {
+		return connError{ErrCodeProtocol, "the client preface must end with SETTINGS"}
+	}
+	return sc.processFrame(h, payload)
+}
+
+func (sc *serverConn) processFrame(h frameHeader, payload []byte) error {
+	if sc.headerStream != 0 && (h.typ != frameContinuation || h.streamID != sc.headerStream) {
+		return connError{ErrCodeProtocol, "expected CONTINUATION"}
+	}
+
+	switch h.typ {
+	case frameData:
+		return sc.processData(h, payload)
+	case frameHeaders:
+		return sc.processHeaders(h, payload)
+	case framePriority:
+		return processPriority(h, payload)
+	case frameRSTStream:
+		return sc.processRSTStream(h, payload)
+	case frameSettings:
+		return sc.processSettings(h, payload)
+	case framePushPromise:
+		return connError{ErrCodeProtocol, "clients cannot push"}
+	case framePing:
+		return sc.processPing(h, payload)
+	case frameGoAway:
+		if h.streamID != 0 {
+			return connError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		if len(payload) < 8 {
+			return connError{ErrCodeFrameSize, "short GOAWAY"}
+		}
+		sc.goingAway = true
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(h, payload)
+	case frameContinuation:
+		return sc.processContinuation(h, payload)
+	}
+	// Unknown frame types are ignored.
+	return nil
+}
+
+// idle reports whether the client has not opened stream id yet.
+func (sc *serverConn) idle(id uint32) bool {
+	return id > sc.maxStreamID
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+func (sc *serverConn) processData(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	if sc.idle(h.streamID) {
+		return connError{ErrCodeProtocol, "DATA on an idle stream"}
+	}
+
+	// Padding counts against flow control too.
+	n := int64(h.length)
+	if n > sc.recvWindow {
+		return connError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	if err := sc.replenish(0, n); err != nil {
+		return err
+	}
+
+	st := sc.stream(h.streamID)
+	if st == nil || st.state != stateOpen {
+		return streamError{h.streamID, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	}
+	if n > st.recvWindow {
+		return streamError{h.streamID, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	data, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if int64(len(st.req.Body)+len(data)) > sc.srv.maxRequestBodySize {
+		return sc.rejectBody(st)
+	}
+	st.req.Body = append(st.req.Body, data...)
+
+	if h.has(flagEndStream) {
+		return sc.endRequest(st)
+	}
+	// Give back only as much window as the body may still grow by, so a
+	// client that keeps sending stalls at the limit.
+	st.recvWindow -= n
+	credit := min(n, sc.srv.maxRequestBodySize-int64(len(st.req.Body))-st.recvWindow)
+	if credit > 0 {
+		if err := sc.replenish(st.id, credit); err != nil {
+			return err
+		}
+		st.recvWindow += credit
+	}
+	return nil
+}
+
+// rejectBody answers a stream whose body went over the size limit with
+// 413 instead of calling the handler. The body read so far is dropped.
+func (sc *serverConn) rejectBody(st *stream) error {
+	st.req.Body = nil
+	st.tooLarge = true
+	st.state = stateHalfClosedRemote
+	sc.handlers.Add(1)
+	go st.run()
+	return nil
+}
+
+// replenish sends a WINDOW_UPDATE giving n bytes back to the client.
+func (sc *serverConn) replenish(streamID uint32, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if err := sc.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(n))); err != nil {
+		return err
+	}
+	return sc.flush()
+}
+
+func (sc *serverConn) processHeaders(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	block, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if h.has(flagPriority) {
+		if len(block) < 5 {
+			return connError{ErrCodeFrameSize, "HEADERS priority fields truncated"}
+		}
+		if binary.BigEndian.Uint32(block)&(1<<31-1) == h.streamID {
+			// Only a stream error, but resetting the stream would leave
+			// this block undecoded and the HPACK tables out of step.
+			return connError{ErrCodeProtocol, "stream depends on itself"}
+		}
+		block = block[5:]
+	}
+
+	if !h.has(flagEndHeaders) {
+		sc.headerStream = h.streamID
+		sc.headerBlock = append([]byte(nil), block...)
+		sc.headerEndStream = h.has(flagEndStream)
+		return nil
+	}
+	return sc.processHeaderBlock(h.streamID, block, h.has(flagEndStream))
+}
+
+func (sc *serverConn) processContinuation(h frameHeader, payload []byte) error {
+	if sc.headerStream == 0 {
+		return connError{ErrCodeProtocol, "CONTINUATION without HEADERS"}
+	}
+	sc.headerBlock = append(sc.headerBlock, payload...)
+	if len(sc.headerBlock) > maxHeaderBlockSize {
+		return connError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if !h.has(flagEndHeaders) {
+		return nil
+	}
+	id, block := sc.headerStream, sc.headerBlock
+	sc.headerStream, sc.headerBlock = 0, nil
+	return sc.processHeaderBlock(id, block, sc.headerEndStream)
+}
+
+// processHeaderBlock handles a complete header block: a new request, or
+// the trailers of one whose body is still arriving.
+func (sc *serverConn) processHeaderBlock(id uint32, block []byte, endStream bool) error {
+	// The block must be decoded even if the stream is refused, to keep
+	// the dynamic table in step with the client's.
+	fields, err := sc.dec.Decode(block)
+	if err != nil {
+		return connError{ErrCodeCompression, err.Error()}
+	}
+
+	if !sc.idle(id) {
+		st := sc.stream(id)
+		if st == nil {
+			return connError{ErrCodeStreamClosed, "HEADERS on a closed stream"}
+		}
+		if st.state != stateOpen {
+			return streamError{id, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+		}
+		if !endStream {
+			return streamError{id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		if err := checkTrailers(fields); err != nil {
+			return streamError{id, ErrCodeProtocol, err.Error()}
+		}
+		return sc.endRequest(st)
+	}
+
+	if id%2 == 0 {
+		return connError{ErrCodeProtocol, "client opened an even stream"}
+	}
+	sc.maxStreamID = id
+	if sc.goingAway {
+		return streamError{id, ErrCodeRefusedStream, "connection is going away"}
+	}
+
+	req, err := newRequest(fields, sc.remoteAddr)
+	if err != nil {
+		return streamError{id, ErrCodeProtocol, err.Error()}
+	}
+
+	sc.mu.Lock()
+	if uint32(len(sc.streams)) >= sc.srv.maxConcurrentStreams {
+		sc.mu.Unlock()
+		return streamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	st := newStream(sc, id, req)
+	sc.streams[id] = st
+	sc.mu.Unlock()
+
+	if endStream {
+		return sc.endRequest(st)
+	}
+	return nil
+}
+
+// endRequest runs the handler for a stream whose request is complete.
+func (sc *serverConn) endRequest(st *stream) error {
+	if cl := st.req.Headers.Get("content-length"); cl != "" && cl != strconv.Itoa(len(st.req.Body)) {
+		return streamError{st.id, ErrCodeProtocol, "body does not match Content-Length"}
+	}
+	st.state = stateHalfClosedRemote
+	sc.handlers.Add(1)
+	go st.run()
+	return nil
+}
+
+// startUpgradeStream serves the request that carried an h2c upgrade as
+// stream 1, which is half closed from the start.
+func (sc *serverConn) startUpgradeStream(req *request.Request) {
+	sc.maxStreamID = 1
+	sc.mu.Lock()
+	st := newStream(sc, 1, req)
+	sc.streams[1] = st
+	sc.mu.Unlock()
+	st.state = stateHalfClosedRemote
+	sc.handlers.Add(1)
+	go st.run()
+}
+
+func processPriority(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connError{ErrCodeProtocol, "PRIORITY on stream 0"}
+	}
+	if len(payload) != 5 {
+		return streamError{h.streamID, ErrCodeFrameSize, "PRIORITY must be 5 bytes"}
+	}
+	if binary.BigEndian.Uint32(payload)&(1<<31-1) == h.streamID {
+		return streamError{h.streamID, ErrCodeProtocol, "stream depends on itself"}
+	}
+	// Priorities are advisory; streams are served in no particular order.
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(payload) != 4 {
+		return connError{ErrCodeFrameSize, "RST_STREAM must be 4 bytes"}
+	}
+	if sc.idle(h.streamID) {
+		return connError{ErrCodeProtocol, "RST_STREAM on an idle stream"}
+	}
+	if st := sc.stream(h.streamID); st != nil {
+		sc.closeStream(st, errStreamReset)
+	}
+	return nil
+}
+
+func (sc *serverConn) processSettings(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if h.has(flagAck) {
+		if len(payload) != 0 {
+			return connError{ErrCodeFrameSize, "SETTINGS ack with a payload"}
+		}
+		return nil
+	}
+	if len(payload)%6 != 0 {
+		return connError{ErrCodeFrameSize, "SETTINGS length is not a multiple of 6"}
+	}
+	if err := sc.applySettings(parseSettings(payload)); err != nil {
+		return err
+	}
+	if err := sc.writeFrame(frameSettings, flagAck, 0, nil); err != nil {
+		return err
+	}
+	return sc.flush()
+}
+
+// applySettings applies the client's settings in order.
+func (sc *serverConn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.enc.SetMaxTableSize(s.value)
+			sc.writeMu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError{ErrCodeProtocol, "ENABLE_PUSH must be 0 or 1"}
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindowSize {
+				return connError{ErrCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+			if err := sc.setInitialWindow(int64(s.value)); err != nil {
+				return err
+			}
+		case settingMaxFrameSize:
+			if s.value < defaultMaxFrameSize || s.value > maxFrameSizeLimit {
+				return connError{ErrCodeProtocol, "MAX_FRAME_SIZE out of range"}
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = s.value
+			sc.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// setInitialWindow moves every stream's send window by the change in the
+// initial window size.
+func (sc *serverConn) setInitialWindow(size int64) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delta := size - sc.peerInitialWindow
+	sc.peerInitialWindow = size
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindowSize {
+			return connError{ErrCodeFlowControl, "stream window too large"}
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processPing(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connError{ErrCodeProtocol, "PING on a stream"}
+	}
+	if len(payload) != 8 {
+		return connError{ErrCodeFrameSize, "PING must be 8 bytes"}
+	}
+	if h.has(flagAck) {
+		return nil
+	}
+	if err := sc.writeFrame(framePing, flagAck, 0, payload); err != nil {
+		return err
+	}
+	return sc.flush()
+}
+
+func (sc *serverConn) processWindowUpdate(h frameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return connError{ErrCodeFrameSize, "WINDOW_UPDATE must be 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+	if h.streamID == 0 {
+		if increment == 0 {
+			return connError{ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+		}
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError{ErrCodeFlowControl, "connection window too large"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if sc.idle(h.streamID) {
+		return connError{ErrCodeProtocol, "WINDOW_UPDATE on an idle stream"}
+	}
+	if increment == 0 {
+		return streamError{h.streamID, ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := sc.streams[h.streamID]
+	if st == nil {
+		// The stream already ended; the update is harmless.
+		return nil
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{h.streamID, ErrCodeFlowControl, "stream window too large"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// resetStream sends RST_STREAM and forgets the stream.
+func (sc *serverConn) resetStream(id uint32, code ErrCode) error {
+	if st := sc.stream(id); st != nil {
+		sc.closeStream(st, errStreamReset)
+	}
+	if err := sc.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code))); err != nil {
+		return err
+	}
+	return sc.flush()
+}
+
+// closeStream forgets st and wakes its handler if it is waiting. err is
+// what further writes on the stream fail with.
+func (sc *serverConn) closeStream(st *stream, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.streams[st.id] == st {
+		delete(sc.streams, st.id)
+	}
+	if st.err == nil {
+		st.err = err
+		close(st.done)
+	}
+	sc.cond.Broadcast()
+}
+
+func (sc *serverConn) writeGoAway(code ErrCode) {
+	payload := binary.BigEndian.AppendUint32(nil, sc.maxStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	if sc.writeFrame(frameGoAway, 0, 0, payload) == nil {
+		sc.flush()
+	}
+}
+
+// shutdown ends every stream once the read loop is done; handlers still
+// running see their writes fail.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	streams := make([]*stream, 0, len(sc.streams))
+	for _, st := range sc.streams {
+		streams = append(streams, st)
+	}
+	sc.mu.Unlock()
+	for _, st := range streams {
+		sc.closeStream(st, errConnClosed)
+	}
+
+	sc.writeMu.Lock()
+	if sc.writeErr == nil {
+		sc.writeErr = errConnClosed
+	}
+	sc.writeMu.Unlock()
+}
+
+// writeFrame queues a frame; flush sends it.
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return sc.writeFrameLocked(typ, flags, streamID, payload)
+}
+
+func (sc *serverConn) writeFrameLocked(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	if sc.writeErr != nil {
+		return sc.writeErr
+	}
+	buf := appendFrame(make([]byte, 0, frameHeaderLen+len(payload)), typ, flags, streamID, payload)
+	if _, err := sc.bw.Write(buf); err != nil {
+		sc.writeErr = err
+		return err
+	}
+	return nil
+}
+
+func (sc *serverConn) flush() error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	if sc.writeErr != nil {
+		return sc.writeErr
+	}
+	if err := sc.bw.Flush(); err != nil {
+		sc.writeErr = err
+		return err
+	}
+	return nil
+}
+
+// writeHeaderBlock encodes fields and sends them as HEADERS followed by as
+// many CONTINUATION frames as the peer's frame size requires. The writer
+// lock is held throughout, so the encoder's table matches the order in
+// which blocks go out and no other frame splits the block.
+func (sc *serverConn) writeHeaderBlock(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxSize := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	block := sc.enc.Encode(nil, fields)
+
+	typ := frameHeaders
+	var flags uint8
+	if endStream {
+		flags = flagEndStream
+	}
+	for {
+		chunk := block[:min(len(block), maxSize)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := sc.writeFrameLocked(typ, flags, streamID, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ, flags = frameContinuation, 0
+	}
+}
+
+// reserve waits until st may send some DATA and returns how many of want
+// bytes it may send now, taking them out of both send windows.
+func (sc *serverConn) reserve(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	flushed := false
+	for {
+		if st.err != nil {
+			return 0, st.err
+		}
+		n := int64(min(want, int(sc.peerMaxFrameSize)))
+		n = min(n, st.sendWindow, sc.sendWindow)
+		if n > 0 {
+			st.sendWindow -= n
+			sc.sendWindow -= n
+			return int(n), nil
+		}
+		if !flushed {
+			// The client may be waiting for what is buffered before it
+			// grants more window.
+			sc.mu.Unlock()
+			err := sc.flush()
+			sc.mu.Lock()
+			if err != nil {
+				return 0, err
+			}
+			flushed = true
+			continue
+		}
+		sc.cond.Wait()
+		flushed = false
+	}
+}
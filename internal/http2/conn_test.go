@@ -0,0 +1,370 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/http2/hpack"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient speaks raw frames to a server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	enc  *hpack.Encoder
+	dec  *hpack.Decoder
+}
+
+// dialRaw starts serve on the server side of a fresh TCP connection.
+func dialRaw(t *testing.T, serve func(conn net.Conn)) *testClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, br: bufio.NewReader(conn), enc: hpack.NewEncoder(), dec: hpack.NewDecoder(hpack.DefaultTableSize)}
+}
+
+func dialH2(t *testing.T, s *Server) *testClient {
+	c := dialRaw(t, func(conn net.Conn) {
+		s.ServeConn(conn, conn.RemoteAddr().String())
+	})
+	c.write(ClientPreface)
+	c.writeFrame(frameSettings, 0, 0, nil)
+	c.expectSettings()
+	return c
+}
+
+func (c *testClient) write(s string) {
+	_, err := io.WriteString(c.conn, s)
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) {
+	_, err := c.conn.Write(appendFrame(nil, typ, flags, streamID, payload))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) writeHeaders(streamID uint32, endStream bool, pairs ...string) {
+	var fields []hpack.HeaderField
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, hpack.HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	flags := uint8(flagEndHeaders)
+	if endStream {
+		flags |= flagEndStream
+	}
+	c.writeFrame(frameHeaders, flags, streamID, c.enc.Encode(nil, fields))
+}
+
+// next reads the next frame that is not a SETTINGS ack or WINDOW_UPDATE.
+func (c *testClient) next() (frameHeader, []byte) {
+	c.t.Helper()
+	for {
+		h, payload, err := readFrame(c.br, maxFrameSizeLimit)
+		require.NoError(c.t, err)
+		if h.typ == frameWindowUpdate || (h.typ == frameSettings && h.has(flagAck)) {
+			continue
+		}
+		return h, payload
+	}
+}
+
+// expectSettings reads the server's SETTINGS and acknowledges them.
+func (c *testClient) expectSettings() []setting {
+	c.t.Helper()
+	h, payload := c.next()
+	require.Equal(c.t, frameSettings, h.typ)
+	c.writeFrame(frameSettings, flagAck, 0, nil)
+	return parseSettings(payload)
+}
+
+func (c *testClient) expectGoAway(code ErrCode) {
+	c.t.Helper()
+	h, payload := c.next()
+	require.Equal(c.t, frameGoAway, h.typ)
+	assert.Equal(c.t, code, ErrCode(binary.BigEndian.Uint32(payload[4:])))
+}
+
+func (c *testClient) expectReset(streamID uint32, code ErrCode) {
+	c.t.Helper()
+	h, payload := c.next()
+	require.Equal(c.t, frameRSTStream, h.typ)
+	assert.Equal(c.t, streamID, h.streamID)
+	assert.Equal(c.t, code, ErrCode(binary.BigEndian.Uint32(payload)))
+}
+
+// readResponse collects the response on streamID up to END_STREAM.
+func (c *testClient) readResponse(streamID uint32) (map[string]string, string) {
+	c.t.Helper()
+	fields := map[string]string{}
+	var body strings.Builder
+	for {
+		h, payload := c.next()
+		require.Equal(c.t, streamID, h.streamID, "unexpected %v frame", h.typ)
+		switch h.typ {
+		case frameHeaders:
+			require.True(c.t, h.has(flagEndHeaders))
+			decoded, err := c.dec.Decode(payload)
+			require.NoError(c.t, err)
+			for _, f := range decoded {
+				fields[f.Name] = f.Value
+			}
+		case frameData:
+			body.Write(payload)
+		default:
+			c.t.Fatalf("unexpected %v frame", h.typ)
+		}
+		if h.has(flagEndStream) {
+			return fields, body.String()
+		}
+	}
+}
+
+func helloHandler(w *response.Writer, req *request.Request) {
+	w.Write([]byte("hello " + req.RequestLine.RequestTarget))
+}
+
+func TestServerSettings(t *testing.T) {
+	c := dialRaw(t, func(conn net.Conn) {
+		NewServer(helloHandler, WithMaxConcurrentStreams(5), WithInitialWindowSize(1<<20)).ServeConn(conn, "")
+	})
+	c.write(ClientPreface)
+	c.writeFrame(frameSettings, 0, 0, nil)
+	settings := c.expectSettings()
+	assert.Contains(t, settings, setting{settingMaxConcurrentStreams, 5})
+	assert.Contains(t, settings, setting{settingInitialWindowSize, 1 << 20})
+}
+
+func TestUpgrade(t *testing.T) {
+	c := dialRaw(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := request.RequestFromReader(br)
+		if !assert.NoError(t, err) || !assert.True(t, UpgradeRequested(req)) {
+			return
+		}
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{br, conn}
+		NewServer(helloHandler).ServeUpgrade(rw, req, "")
+	})
+
+	// HTTP2-Settings carries MAX_FRAME_SIZE = 16384 and ENABLE_PUSH = 0.
+	c.write("GET /upgraded HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: AAUAAEAAAAIAAAAA\r\n\r\n")
+	resp, err := c.br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", resp)
+	for line := ""; line != "\r\n"; {
+		line, err = c.br.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	c.write(ClientPreface)
+	c.writeFrame(frameSettings, 0, 0, nil)
+	c.expectSettings()
+	fields, body := c.readResponse(1)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "hello /upgraded", body)
+
+	// The connection carries further streams.
+	c.writeHeaders(3, true, ":method", "GET", ":scheme", "http", ":path", "/next", ":authority", "x")
+	_, body = c.readResponse(3)
+	assert.Equal(t, "hello /next", body)
+}
+
+func TestUpgradeRequested(t *testing.T) {
+	parse := func(raw string) *request.Request {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return req
+	}
+	assert.True(t, UpgradeRequested(parse("GET / HTTP/1.1\r\nConnection: upgrade, http2-settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")))
+	assert.False(t, UpgradeRequested(parse("GET / HTTP/1.1\r\nConnection: upgrade\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")))
+	assert.False(t, UpgradeRequested(parse("GET / HTTP/1.1\r\nConnection: upgrade, http2-settings\r\nUpgrade: websocket\r\nHTTP2-Settings: \r\n\r\n")))
+	assert.False(t, UpgradeRequested(parse("GET / HTTP/1.1\r\nConnection: upgrade, http2-settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAU\r\n\r\n")))
+}
+
+func TestPing(t *testing.T) {
+	c := dialH2(t, NewServer(helloHandler))
+	c.writeFrame(framePing, 0, 0, []byte("12345678"))
+	h, payload := c.next()
+	assert.Equal(t, framePing, h.typ)
+	assert.True(t, h.has(flagAck))
+	assert.Equal(t, "12345678", string(payload))
+}
+
+func TestContinuation(t *testing.T) {
+	c := dialH2(t, NewServer(func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.Headers.Get("x-long")))
+	}))
+	long := strings.Repeat("v", 100)
+	block := c.enc.Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"}, {Name: "x-long", Value: long},
+	})
+	c.writeFrame(frameHeaders, flagEndStream, 1, block[:10])
+	c.writeFrame(frameContinuation, 0, 1, block[10:20])
+	c.writeFrame(frameContinuation, flagEndHeaders, 1, block[20:])
+	_, body := c.readResponse(1)
+	assert.Equal(t, long, body)
+}
+
+func TestRefusedStream(t *testing.T) {
+	release := make(chan struct{})
+	c := dialH2(t, NewServer(func(w *response.Writer, req *request.Request) {
+		<-release
+	}, WithMaxConcurrentStreams(1)))
+
+	c.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	c.writeHeaders(3, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	c.expectReset(3, ErrCodeRefusedStream)
+	close(release)
+	fields, _ := c.readResponse(1)
+	assert.Equal(t, "200", fields[":status"])
+}
+
+func TestClientReset(t *testing.T) {
+	notified := make(chan struct{})
+	c := dialH2(t, NewServer(func(w *response.Writer, req *request.Request) {
+		<-w.CloseNotify()
+		close(notified)
+	}))
+	c.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	c.writeFrame(frameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not told about the reset")
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	called := false
+	c := dialH2(t, NewServer(func(w *response.Writer, req *request.Request) {
+		called = true
+	}, WithMaxRequestBodySize(8)))
+
+	c.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/")
+	c.writeFrame(frameData, 0, 1, []byte("12345"))
+	c.writeFrame(frameData, 0, 1, []byte("67890"))
+	fields, body := c.readResponse(1)
+	assert.Equal(t, "413", fields[":status"])
+	assert.Equal(t, "413 Content Too Large\n", body)
+	c.expectReset(1, ErrCodeNo)
+	assert.False(t, called)
+}
+
+func TestRequestBodyLimitWindow(t *testing.T) {
+	c := dialH2(t, NewServer(helloHandler, WithMaxRequestBodySize(1<<20)))
+	c.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/")
+	c.writeFrame(frameData, 0, 1, make([]byte, 1000))
+
+	// Under the limit the stream window is given back in full.
+	h, payload, err := readFrame(c.br, maxFrameSizeLimit)
+	require.NoError(t, err)
+	for h.typ != frameWindowUpdate || h.streamID != 1 {
+		h, payload, err = readFrame(c.br, maxFrameSizeLimit)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint32(1000), binary.BigEndian.Uint32(payload))
+}
+
+func TestStreamErrors(t *testing.T) {
+	tests := map[string][]string{
+		"missing path":         {":method", "GET", ":scheme", "http"},
+		"uppercase field name": {":method", "GET", ":scheme", "http", ":path", "/", "X-Bad", "1"},
+		"connection header":    {":method", "GET", ":scheme", "http", ":path", "/", "connection", "close"},
+		"pseudo after regular": {":method", "GET", ":scheme", "http", "accept", "*/*", ":path", "/"},
+		"unknown pseudo":       {":method", "GET", ":scheme", "http", ":path", "/", ":protocol", "x"},
+	}
+	for name, pairs := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := dialH2(t, NewServer(helloHandler))
+			c.writeHeaders(1, true, pairs...)
+			c.expectReset(1, ErrCodeProtocol)
+		})
+	}
+
+	t.Run("content-length mismatch", func(t *testing.T) {
+		c := dialH2(t, NewServer(helloHandler))
+		c.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/", "content-length", "10")
+		c.writeFrame(frameData, flagEndStream, 1, []byte("short"))
+		c.expectReset(1, ErrCodeProtocol)
+	})
+}
+
+func TestConnectionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *testClient)
+		code ErrCode
+	}{
+		{"DATA on stream 0", func(c *testClient) { c.writeFrame(frameData, 0, 0, []byte("x")) }, ErrCodeProtocol},
+		{"DATA on idle stream", func(c *testClient) { c.writeFrame(frameData, 0, 5, []byte("x")) }, ErrCodeProtocol},
+		{"even stream", func(c *testClient) {
+			c.writeHeaders(2, true, ":method", "GET", ":scheme", "http", ":path", "/")
+		}, ErrCodeProtocol},
+		{"SETTINGS on a stream", func(c *testClient) { c.writeFrame(frameSettings, 0, 1, nil) }, ErrCodeProtocol},
+		{"bad SETTINGS length", func(c *testClient) { c.writeFrame(frameSettings, 0, 0, []byte{0, 1, 0}) }, ErrCodeFrameSize},
+		{"window too large", func(c *testClient) {
+			c.writeFrame(frameSettings, 0, 0, appendSettings(nil, setting{settingInitialWindowSize, 1 << 31}))
+		}, ErrCodeFlowControl},
+		{"window overflow", func(c *testClient) {
+			c.writeFrame(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 1<<31-1))
+		}, ErrCodeFlowControl},
+		{"short PING", func(c *testClient) { c.writeFrame(framePing, 0, 0, []byte("1234")) }, ErrCodeFrameSize},
+		{"push from client", func(c *testClient) { c.writeFrame(framePushPromise, flagEndHeaders, 1, make([]byte, 4)) }, ErrCodeProtocol},
+		{"interrupted header block", func(c *testClient) {
+			c.writeFrame(frameHeaders, 0, 1, c.enc.Encode(nil, []hpack.HeaderField{{Name: ":method", Value: "GET"}}))
+			c.writeFrame(framePing, 0, 0, []byte("12345678"))
+		}, ErrCodeProtocol},
+		{"bad header block", func(c *testClient) { c.writeFrame(frameHeaders, flagEndHeaders, 1, []byte{0x80}) }, ErrCodeCompression},
+		{"frame too large", func(c *testClient) { c.writeFrame(frameData, 0, 1, make([]byte, defaultMaxFrameSize+1)) }, ErrCodeFrameSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialH2(t, NewServer(helloHandler))
+			tt.send(c)
+			c.expectGoAway(tt.code)
+		})
+	}
+}
+
+func TestBadPreface(t *testing.T) {
+	c := dialRaw(t, func(conn net.Conn) {
+		NewServer(helloHandler).ServeConn(conn, "")
+	})
+	c.write("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	c.expectSettings()
+	c.expectGoAway(ErrCodeProtocol)
+}
@@ -0,0 +1,227 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// frameType identifies a frame (RFC 9113 section 6).
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+var frameNames = map[frameType]string{
+	frameData:         "DATA",
+	frameHeaders:      "HEADERS",
+	framePriority:     "PRIORITY",
+	frameRSTStream:    "RST_STREAM",
+	frameSettings:     "SETTINGS",
+	framePushPromise:  "PUSH_PROMISE",
+	framePing:         "PING",
+	frameGoAway:       "GOAWAY",
+	frameWindowUpdate: "WINDOW_UPDATE",
+	frameContinuation: "CONTINUATION",
+}
+
+func (t frameType) String() string {
+	if name, ok := frameNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+// Frame flags. Their meaning depends on the frame type.
+const (
+	flagEndStream  = 0x1 // DATA, HEADERS
+	flagAck        = 0x1 // SETTINGS, PING
+	flagEndHeaders = 0x4 // HEADERS, CONTINUATION
+	flagPadded     = 0x8 // DATA, HEADERS
+	flagPriority   = 0x20
+)
+
+const (
+	frameHeaderLen = 9
+
+	// defaultMaxFrameSize is the initial SETTINGS_MAX_FRAME_SIZE and the
+	// smallest value it may take.
+	defaultMaxFrameSize = 1 << 14
+	maxFrameSizeLimit   = 1<<24 - 1
+
+	defaultWindowSize = 65535
+	maxWindowSize     = 1<<31 - 1
+)
+
+// ErrCode is an error code carried by RST_STREAM and GOAWAY frames.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// connError is a connection error: the connection is closed with a GOAWAY
+// carrying code.
+type connError struct {
+	code   ErrCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2: connection error %v: %s", e.code, e.reason)
+}
+
+// streamError only ends one stream, with an RST_STREAM.
+type streamError struct {
+	streamID uint32
+	code     ErrCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %v: %s", e.streamID, e.code, e.reason)
+}
+
+// SETTINGS parameters (RFC 9113 section 6.5.2).
+type settingID uint16
+
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+type setting struct {
+	id    settingID
+	value uint32
+}
+
+type frameHeader struct {
+	length   uint32
+	typ      frameType
+	flags    uint8
+	streamID uint32
+}
+
+func (h frameHeader) has(flag uint8) bool {
+	return h.flags&flag != 0
+}
+
+// readFrame reads one frame. Frames longer than maxSize are a connection
+// error; the payload is not read.
+func readFrame(r io.Reader, maxSize uint32) (frameHeader, []byte, error) {
+	var buf [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return frameHeader{}, nil, err
+	}
+	h := frameHeader{
+		length:   uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2]),
+		typ:      frameType(buf[3]),
+		flags:    buf[4],
+		streamID: binary.BigEndian.Uint32(buf[5:]) & (1<<31 - 1),
+	}
+	if h.length > maxSize {
+		return h, nil, connError{ErrCodeFrameSize, fmt.Sprintf("%v frame of %d bytes exceeds the maximum frame size", h.typ, h.length)}
+	}
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, nil, err
+	}
+	return h, payload, nil
+}
+
+// appendFrame appends a frame with the given payload to dst.
+func appendFrame(dst []byte, typ frameType, flags uint8, streamID uint32, payload []byte) []byte {
+	n := len(payload)
+	dst = append(dst, byte(n>>16), byte(n>>8), byte(n), byte(typ), flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID)
+	return append(dst, payload...)
+}
+
+// stripPadding removes the padding of a DATA or HEADERS frame.
+func stripPadding(h frameHeader, payload []byte) ([]byte, error) {
+	if !h.has(flagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, connError{ErrCodeFrameSize, "padded frame without a pad length"}
+	}
+	pad := int(payload[0])
+	payload = payload[1:]
+	if pad > len(payload) {
+		return nil, connError{ErrCodeProtocol, "padding exceeds the frame payload"}
+	}
+	return payload[:len(payload)-pad], nil
+}
+
+func parseSettings(payload []byte) []setting {
+	settings := make([]setting, 0, len(payload)/6)
+	for i := 0; i+6 <= len(payload); i += 6 {
+		settings = append(settings, setting{
+			id:    settingID(binary.BigEndian.Uint16(payload[i:])),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.id))
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+	return dst
+}
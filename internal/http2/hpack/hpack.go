@@ -0,0 +1,409 @@
+// Package hpack implements HPACK header compression for HTTP/2 (RFC 7541).
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTableSize is the dynamic table size both sides start with.
+const DefaultTableSize = 4096
+
+// entryOverhead is added to the length of name and value to get the size
+// of a table entry (RFC 7541 section 4.1).
+const entryOverhead = 32
+
+// HeaderField is a name-value pair. Sensitive fields are never added to a
+// dynamic table, by this encoder or by intermediaries.
+type HeaderField struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+func (f HeaderField) size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + entryOverhead)
+}
+
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// table is the static table followed by a dynamic table. Index 1 is the
+// first static entry; the newest dynamic entry comes right after the
+// static ones.
+type table struct {
+	dynamic []HeaderField // oldest first
+	size    uint32
+	maxSize uint32
+}
+
+func (t *table) len() int {
+	return len(staticTable) + len(t.dynamic)
+}
+
+func (t *table) get(index uint64) (HeaderField, bool) {
+	switch {
+	case index == 0:
+		return HeaderField{}, false
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], true
+	case index <= uint64(t.len()):
+		return t.dynamic[len(t.dynamic)-int(index-uint64(len(staticTable)))], true
+	}
+	return HeaderField{}, false
+}
+
+// add inserts f, evicting old entries to make room. An entry larger than
+// the whole table empties it.
+func (t *table) add(f HeaderField) {
+	t.evict(t.maxSize - min(t.maxSize, f.size()))
+	if f.size() <= t.maxSize {
+		t.dynamic = append(t.dynamic, f)
+		t.size += f.size()
+	}
+}
+
+func (t *table) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict(n)
+}
+
+// evict drops the oldest entries until the table holds at most limit.
+func (t *table) evict(limit uint32) {
+	n := 0
+	for t.size > limit && n < len(t.dynamic) {
+		t.size -= t.dynamic[n].size()
+		n++
+	}
+	if n > 0 {
+		t.dynamic = append(t.dynamic[:0:0], t.dynamic[n:]...)
+	}
+}
+
+// search returns the index of an entry matching f exactly, or failing
+// that of one with the same name.
+func (t *table) search(f HeaderField) (index int, exact bool) {
+	for i, e := range staticTable {
+		if e.Name != f.Name {
+			continue
+		}
+		if e.Value == f.Value {
+			return i + 1, true
+		}
+		if index == 0 {
+			index = i + 1
+		}
+	}
+	for i := len(t.dynamic) - 1; i >= 0; i-- {
+		e := t.dynamic[i]
+		if e.Name != f.Name {
+			continue
+		}
+		pos := len(staticTable) + len(t.dynamic) - i
+		if e.Value == f.Value {
+			return pos, true
+		}
+		if index == 0 {
+			index = pos
+		}
+	}
+	return index, false
+}
+
+// Decoder decodes header blocks. Its dynamic table lives as long as the
+// connection.
+type Decoder struct {
+	table table
+	// maxSize is the largest table size the encoder may ask for: the
+	// value this side advertised in SETTINGS_HEADER_TABLE_SIZE.
+	maxSize uint32
+}
+
+// NewDecoder returns a decoder whose peer may use up to maxTableSize bytes
+// of dynamic table.
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:   table{maxSize: maxTableSize},
+		maxSize: maxTableSize,
+	}
+}
+
+// Decode decodes a complete header block. Any error is a connection error
+// of type COMPRESSION_ERROR, since the dynamic table is now out of sync.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	first := true
+	for len(block) > 0 {
+		b := block[0]
+		var err error
+		switch {
+		case b&0x80 != 0: // indexed field
+			var index uint64
+			if index, block, err = readInt(block, 7); err != nil {
+				return nil, err
+			}
+			f, ok := d.table.get(index)
+			if !ok {
+				return nil, fmt.Errorf("hpack: invalid index %d", index)
+			}
+			fields = append(fields, HeaderField{Name: f.Name, Value: f.Value})
+
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			var f HeaderField
+			if f, block, err = d.readLiteral(block, 6); err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			fields = append(fields, f)
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			if !first {
+				return nil, errors.New("hpack: table size update after the first field")
+			}
+			var size uint64
+			if size, block, err = readInt(block, 5); err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxSize) {
+				return nil, fmt.Errorf("hpack: table size %d exceeds the limit %d", size, d.maxSize)
+			}
+			d.table.setMaxSize(uint32(size))
+			continue
+
+		default: // literal without indexing (0000) or never indexed (0001)
+			var f HeaderField
+			if f, block, err = d.readLiteral(block, 4); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+			fields = append(fields, f)
+		}
+		first = false
+	}
+	return fields, nil
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *Decoder) readLiteral(block []byte, n uint) (HeaderField, []byte, error) {
+	var f HeaderField
+	index, block, err := readInt(block, n)
+	if err != nil {
+		return f, nil, err
+	}
+	if index > 0 {
+		named, ok := d.table.get(index)
+		if !ok {
+			return f, nil, fmt.Errorf("hpack: invalid index %d", index)
+		}
+		f.Name = named.Name
+	} else if f.Name, block, err = readString(block); err != nil {
+		return f, nil, err
+	}
+	if f.Value, block, err = readString(block); err != nil {
+		return f, nil, err
+	}
+	return f, block, nil
+}
+
+var errTruncated = errors.New("hpack: truncated header block")
+
+// readInt decodes an integer with an n-bit prefix (RFC 7541 section 5.1).
+func readInt(buf []byte, n uint) (uint64, []byte, error) {
+	if len(buf) == 0 {
+		return 0, nil, errTruncated
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(buf[0]) & mask
+	buf = buf[1:]
+	if v < mask {
+		return v, buf, nil
+	}
+
+	var shift uint
+	for {
+		if len(buf) == 0 {
+			return 0, nil, errTruncated
+		}
+		b := buf[0]
+		buf = buf[1:]
+		if shift > 28 {
+			return 0, nil, errors.New("hpack: integer overflow")
+		}
+		v += uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return v, buf, nil
+		}
+	}
+}
+
+// readString decodes a string literal (RFC 7541 section 5.2).
+func readString(buf []byte) (string, []byte, error) {
+	if len(buf) == 0 {
+		return "", nil, errTruncated
+	}
+	huffman := buf[0]&0x80 != 0
+	length, buf, err := readInt(buf, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(buf)) < length {
+		return "", nil, errTruncated
+	}
+	raw := buf[:length]
+	buf = buf[length:]
+	if !huffman {
+		return string(raw), buf, nil
+	}
+	s, err := huffmanDecode(raw)
+	return s, buf, err
+}
+
+// Encoder encodes header blocks. Its dynamic table lives as long as the
+// connection.
+type Encoder struct {
+	table table
+	// sizeUpdate is set when the next block must start with a dynamic
+	// table size update.
+	sizeUpdate bool
+}
+
+// NewEncoder returns an encoder using a DefaultTableSize dynamic table.
+func NewEncoder() *Encoder {
+	return &Encoder{table: table{maxSize: DefaultTableSize}}
+}
+
+// SetMaxTableSize applies the peer's SETTINGS_HEADER_TABLE_SIZE. The
+// encoder never uses more than DefaultTableSize.
+func (e *Encoder) SetMaxTableSize(n uint32) {
+	n = min(n, DefaultTableSize)
+	if n != e.table.maxSize {
+		e.table.setMaxSize(n)
+		e.sizeUpdate = true
+	}
+}
+
+// Encode appends the header block for fields to dst.
+func (e *Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	if e.sizeUpdate {
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.sizeUpdate = false
+	}
+
+	for _, f := range fields {
+		index, exact := e.table.search(f)
+		switch {
+		case exact && !f.Sensitive:
+			dst = appendInt(dst, 0x80, 7, uint64(index))
+		case f.Sensitive:
+			dst = appendInt(dst, 0x10, 4, uint64(index))
+			dst = e.appendLiteral(dst, index, f)
+		case f.size() > e.table.maxSize/2:
+			// Would push most of the table out for one field.
+			dst = appendInt(dst, 0x00, 4, uint64(index))
+			dst = e.appendLiteral(dst, index, f)
+		default:
+			dst = appendInt(dst, 0x40, 6, uint64(index))
+			dst = e.appendLiteral(dst, index, f)
+			e.table.add(f)
+		}
+	}
+	return dst
+}
+
+// appendLiteral appends the name, unless it is indexed, and the value of
+// a literal field whose prefix was already written.
+func (e *Encoder) appendLiteral(dst []byte, index int, f HeaderField) []byte {
+	if index == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
+
+// appendInt encodes v with an n-bit prefix, ORing flags into the first
+// byte.
+func appendInt(dst []byte, flags byte, n uint, v uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if v < mask {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(mask))
+	v -= mask
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// appendString appends a string literal, Huffman coded when that is
+// shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return appendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
@@ -0,0 +1,160 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fields(pairs ...string) []HeaderField {
+	var out []HeaderField
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return out
+}
+
+// decodeSequence decodes the header blocks of one connection in order.
+func decodeSequence(t *testing.T, d *Decoder, blocks []string, want [][]HeaderField) {
+	t.Helper()
+	for i, block := range blocks {
+		raw, err := hex.DecodeString(block)
+		require.NoError(t, err)
+		got, err := d.Decode(raw)
+		require.NoError(t, err, "block %d", i)
+		assert.Equal(t, want[i], got, "block %d", i)
+	}
+}
+
+var exampleRequests = [][]HeaderField{
+	fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+	fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com", "cache-control", "no-cache"),
+	fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com", "custom-key", "custom-value"),
+}
+
+func TestDecodeRequestsWithoutHuffman(t *testing.T) {
+	// RFC 7541 Appendix C.3.
+	decodeSequence(t, NewDecoder(DefaultTableSize), []string{
+		"828684410f7777772e6578616d706c652e636f6d",
+		"828684be58086e6f2d6361636865",
+		"828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+	}, exampleRequests)
+}
+
+func TestDecodeRequestsWithHuffman(t *testing.T) {
+	// RFC 7541 Appendix C.4.
+	d := NewDecoder(DefaultTableSize)
+	decodeSequence(t, d, []string{
+		"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+		"828684be5886a8eb10649cbf",
+		"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+	}, exampleRequests)
+
+	// The table now holds, newest first, custom-key, cache-control and
+	// :authority: 54 + 53 + 57 bytes.
+	assert.Equal(t, uint32(164), d.table.size)
+	assert.Equal(t, "custom-key", d.table.dynamic[2].Name)
+}
+
+func TestDecodeResponsesWithEviction(t *testing.T) {
+	// RFC 7541 Appendix C.6, with a 256-byte table.
+	d := NewDecoder(256)
+	decodeSequence(t, d, []string{
+		"488264025885aec3771a4b6196d07abe941054d444a8200595040b8166e082a62d1bff6e919d29ad171863c78f0b97c8e9ae82ae43d3",
+		"4883640effc1c0bf",
+		"88c16196d07abe941054d444a8200595040b8166e084a62d1bffc05a839bd9ab77ad94e7821dd7f2e6c7b335dfdfcd5b3960d5af27087f3672c1ab270fb5291f9587316065c003ed4ee5b1063d5007",
+	}, [][]HeaderField{
+		fields(":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+		fields(":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+		fields(":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT", "location", "https://www.example.com",
+			"content-encoding", "gzip", "set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"),
+	})
+	assert.Equal(t, uint32(215), d.table.size)
+	assert.Len(t, d.table.dynamic, 3)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string]string{
+		"index zero":               "80",
+		"index past the table":     "be",
+		"truncated integer":        "ff",
+		"truncated string":         "400a6375",
+		"size update above limit":  "3fe21f", // 4097
+		"size update after fields": "823f01",
+		"huffman EOS":              "0082fffffffc",
+		"huffman bad padding":      "0081fe",
+		"integer overflow":         "ffffffffffffffff7f",
+	}
+	for name, block := range tests {
+		raw, err := hex.DecodeString(block)
+		require.NoError(t, err, name)
+		_, err = NewDecoder(DefaultTableSize).Decode(raw)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "www.example.com", "no-cache", "\x00\xff binary \x7f", strings.Repeat("a", 1000)} {
+		encoded := appendHuffman(nil, s)
+		assert.Len(t, encoded, huffmanEncodedLen(s))
+		decoded, err := huffmanDecode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, decoded)
+	}
+
+	// The RFC's example: "www.example.com".
+	assert.Equal(t, "f1e3c2e5f23a6ba0ab90f4ff", hex.EncodeToString(appendHuffman(nil, "www.example.com")))
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	e := NewEncoder()
+	d := NewDecoder(DefaultTableSize)
+
+	blocks := [][]HeaderField{
+		fields(":status", "200", "content-type", "text/html", "server", "tcptohttp"),
+		fields(":status", "200", "content-type", "text/html", "server", "tcptohttp"),
+		fields(":status", "404", "content-type", "application/problem+json", "x-long", strings.Repeat("v", 3000)),
+		{{Name: "authorization", Value: "Bearer secret", Sensitive: true}},
+	}
+	var sizes []int
+	for _, block := range blocks {
+		encoded := e.Encode(nil, block)
+		sizes = append(sizes, len(encoded))
+		got, err := d.Decode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, block, got)
+	}
+
+	// The repeated block is all table references.
+	assert.Equal(t, 3, sizes[1])
+	// Neither the oversized nor the sensitive field went into the table.
+	assert.Equal(t, e.table.dynamic, d.table.dynamic)
+	for _, f := range e.table.dynamic {
+		assert.NotEqual(t, "x-long", f.Name)
+		assert.NotEqual(t, "authorization", f.Name)
+	}
+}
+
+func TestEncoderTableSizeUpdate(t *testing.T) {
+	e := NewEncoder()
+	d := NewDecoder(DefaultTableSize)
+	block := fields("x-request-id", "abc123")
+
+	_, err := d.Decode(e.Encode(nil, block))
+	require.NoError(t, err)
+	require.Len(t, d.table.dynamic, 1)
+
+	// The peer shrinks the table to zero: the next block starts with an
+	// update that empties both tables.
+	e.SetMaxTableSize(0)
+	encoded := e.Encode(nil, block)
+	assert.Equal(t, byte(0x20), encoded[0])
+	got, err := d.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, block, got)
+	assert.Empty(t, d.table.dynamic)
+	assert.Empty(t, e.table.dynamic)
+}
@@ -0,0 +1,97 @@
+package hpack
+
+import "errors"
+
+var (
+	errHuffmanEOS     = errors.New("hpack: Huffman string contains EOS")
+	errHuffmanPadding = errors.New("hpack: invalid Huffman padding")
+)
+
+// huffmanNode is a node of the decoding tree. Leaves have sym set, inner
+// nodes have both children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      int // -1 for inner nodes; 256 is EOS
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{sym: -1}
+	add := func(sym int, code uint32, length uint8) {
+		n := root
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := (code >> i) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{sym: -1}
+			}
+			n = n.children[bit]
+		}
+		n.sym = sym
+	}
+	for sym := range huffmanCodes {
+		add(sym, huffmanCodes[sym], huffmanCodeLen[sym])
+	}
+	add(256, 0x3fffffff, 30)
+	return root
+}
+
+// huffmanDecode decodes a Huffman-coded string literal.
+func huffmanDecode(src []byte) (string, error) {
+	out := make([]byte, 0, len(src)*8/5)
+	n := huffmanRoot
+	// Bits read since the last symbol, and whether all of them were ones;
+	// a code left unfinished at the end must be short all-ones padding.
+	pending, ones := 0, true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+			n = n.children[bit]
+			if n == nil {
+				return "", errHuffmanPadding
+			}
+			pending++
+			ones = ones && bit == 1
+			if n.sym < 0 {
+				continue
+			}
+			if n.sym == 256 {
+				return "", errHuffmanEOS
+			}
+			out = append(out, byte(n.sym))
+			n = huffmanRoot
+			pending, ones = 0, true
+		}
+	}
+	if pending > 7 || !ones {
+		return "", errHuffmanPadding
+	}
+	return string(out), nil
+}
+
+// huffmanEncodedLen returns the length of s once Huffman coded.
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// appendHuffman appends the Huffman coding of s to dst, padded with ones.
+func appendHuffman(dst []byte, s string) []byte {
+	var acc uint64 // bits not yet written, right-aligned
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += int(huffmanCodeLen[s[i]])
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+	if bits > 0 {
+		dst = append(dst, byte(acc<<(8-bits))|byte(0xff>>bits))
+	}
+	return dst
+}
@@ -0,0 +1,158 @@
+// Package http2 serves HTTP/2 (RFC 9113) on connections the caller has
+// already accepted, either with prior knowledge or after an h2c upgrade.
+// Each stream becomes one call of an ordinary handler, with the response
+// written through a response.Writer.
+package http2
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// ClientPreface is what every HTTP/2 client sends first. It starts like an
+// HTTP/1.1 request line, so a server can tell the two apart.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	// DefaultMaxConcurrentStreams is advertised in SETTINGS unless
+	// WithMaxConcurrentStreams says otherwise.
+	DefaultMaxConcurrentStreams = 100
+
+	// DefaultMaxRequestBodySize bounds the request body buffered for one
+	// stream unless WithMaxRequestBodySize says otherwise.
+	DefaultMaxRequestBodySize = 10 << 20
+
+	// maxHeaderBlockSize bounds a request's header block, including
+	// CONTINUATION frames.
+	maxHeaderBlockSize = 1 << 20
+)
+
+var errBadPreface = errors.New("http2: invalid connection preface")
+
+// Handler serves one stream. It has the same shape as server.Handler.
+type Handler func(w *response.Writer, req *request.Request)
+
+// Server holds the settings shared by the connections it serves.
+type Server struct {
+	handler              Handler
+	maxConcurrentStreams uint32
+	initialWindowSize    uint32
+	maxRequestBodySize   int64
+}
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithMaxConcurrentStreams limits how many streams a client may have open
+// at once. Further streams are refused.
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(s *Server) {
+		s.maxConcurrentStreams = n
+	}
+}
+
+// WithInitialWindowSize sets how many request body bytes a client may send
+// on a stream before waiting for a WINDOW_UPDATE.
+func WithInitialWindowSize(n uint32) Option {
+	return func(s *Server) {
+		s.initialWindowSize = min(n, maxWindowSize)
+	}
+}
+
+// WithMaxRequestBodySize replaces DefaultMaxRequestBodySize. A stream
+// whose body grows past it is answered with 413 and reset.
+func WithMaxRequestBodySize(n int64) Option {
+	return func(s *Server) {
+		s.maxRequestBodySize = n
+	}
+}
+
+// NewServer returns a server that calls handler for every stream.
+func NewServer(handler Handler, opts ...Option) *Server {
+	s := &Server{
+		handler:              handler,
+		maxConcurrentStreams: DefaultMaxConcurrentStreams,
+		initialWindowSize:    defaultWindowSize,
+		maxRequestBodySize:   DefaultMaxRequestBodySize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ServeConn serves a connection whose client starts with ClientPreface. It
+// returns once the connection fails or the client goes away, after every
+// handler has returned. Closing rw is up to the caller.
+func (s *Server) ServeConn(rw io.ReadWriter, remoteAddr string) error {
+	sc := newServerConn(s, rw, remoteAddr)
+	if err := sc.writeSettings(); err != nil {
+		return err
+	}
+	return sc.serve()
+}
+
+// UpgradeRequested reports whether req asks to switch to h2c with a usable
+// HTTP2-Settings header (RFC 7540 section 3.2).
+func UpgradeRequested(req *request.Request) bool {
+	if !headers.HasToken(req.Headers.Get("upgrade"), "h2c") {
+		return false
+	}
+	connection := req.Headers.Get("connection")
+	if !headers.HasToken(connection, "upgrade") || !headers.HasToken(connection, "http2-settings") {
+		return false
+	}
+	_, err := decodeSettingsHeader(req.Headers.Get("http2-settings"))
+	return err == nil
+}
+
+// ServeUpgrade answers an h2c upgrade request with 101 Switching Protocols
+// and serves the rest of the connection as HTTP/2. req itself becomes
+// stream 1. The caller must have checked UpgradeRequested.
+func (s *Server) ServeUpgrade(rw io.ReadWriter, req *request.Request, remoteAddr string) error {
+	settings, err := decodeSettingsHeader(req.Headers.Get("http2-settings"))
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"upgrade", "connection", "http2-settings"} {
+		req.Headers.Delete(name)
+	}
+	req.RequestLine.HttpVersion = "2.0"
+	req.RemoteAddr = remoteAddr
+
+	if _, err := io.WriteString(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		return err
+	}
+
+	sc := newServerConn(s, rw, remoteAddr)
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	if err := sc.writeSettings(); err != nil {
+		return err
+	}
+	sc.startUpgradeStream(req)
+	return sc.serve()
+}
+
+// decodeSettingsHeader decodes the base64url SETTINGS payload carried by
+// an upgrade request.
+func decodeSettingsHeader(value string) ([]setting, error) {
+	if strings.Contains(value, ",") {
+		return nil, errors.New("http2: more than one HTTP2-Settings header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload)%6 != 0 {
+		return nil, errors.New("http2: malformed HTTP2-Settings header")
+	}
+	return parseSettings(payload), nil
+}
@@ -0,0 +1,166 @@
+package http2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen serves every accepted connection with s, as prior-knowledge
+// HTTP/2, and returns the base URL.
+func listen(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s.ServeConn(conn, conn.RemoteAddr().String())
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+// h2Client talks HTTP/2 without TLS, with prior knowledge.
+func h2Client(t *testing.T) *http.Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func TestServeConn(t *testing.T) {
+	base := listen(t, NewServer(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Connection", "close")
+		w.AddSetCookie("a=1")
+		w.AddSetCookie("b=2")
+		fmt.Fprintf(w, "%s %s %s host=%s cookie=%s body=%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion,
+			req.Headers.Get("host"), req.Headers.Get("cookie"), req.Body)
+	}))
+	client := h2Client(t)
+
+	req, err := http.NewRequest("POST", base+"/echo?x=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+	host := strings.TrimPrefix(base, "http://")
+	assert.Equal(t, "POST /echo?x=1 2.0 host="+host+" cookie=a=1; b=2 body=hello", string(body))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+}
+
+func TestServeConnHead(t *testing.T) {
+	base := listen(t, NewServer(func(w *response.Writer, req *request.Request) {
+		assert.Equal(t, "HEAD", req.RequestLine.Method)
+		w.Write([]byte("twelve bytes"))
+	}))
+
+	resp, err := h2Client(t).Head(base + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.Equal(t, int64(12), resp.ContentLength)
+}
+
+func TestServeConnFlowControl(t *testing.T) {
+	// Larger than both initial windows, so the server has to wait for
+	// WINDOW_UPDATEs in each direction.
+	payload := make([]byte, 300_000)
+	rand.Read(payload)
+
+	base := listen(t, NewServer(func(w *response.Writer, req *request.Request) {
+		w.Write(req.Body)
+	}))
+	resp, err := h2Client(t).Post(base+"/", "application/octet-stream", bytes.NewReader(payload))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(payload, body))
+}
+
+func TestServeConnMultiplexes(t *testing.T) {
+	// Every handler waits for all of them to start, which only works if
+	// the streams of one connection are served concurrently.
+	const n = 10
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	base := listen(t, NewServer(func(w *response.Writer, req *request.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	}))
+	client := h2Client(t)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("%s/%d", base, i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, fmt.Sprintf("/%d", i), string(body))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeConnTrailers(t *testing.T) {
+	base := listen(t, NewServer(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteChunkedBody([]byte("part one, "))
+		w.WriteChunkedBody([]byte("part two"))
+		w.WriteChunkedBodyDone()
+		h := headers.NewHeaders()
+		h.Set("X-Checksum", "abc")
+		w.WriteTrailers(h)
+	}))
+
+	resp, err := h2Client(t).Get(base + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part one, part two", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+	assert.Empty(t, resp.TransferEncoding)
+}
@@ -0,0 +1,269 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/http2/hpack"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+var (
+	errStreamReset  = errors.New("http2: stream reset")
+	errStreamClosed = errors.New("http2: stream closed")
+)
+
+// Stream states that matter to the server (RFC 9113 section 5.1). Streams
+// only enter the connection's map once open, and leave it when closed.
+const (
+	stateOpen             = iota
+	stateHalfClosedRemote // the request is complete; the handler runs
+)
+
+// connectionHeaders are HTTP/1.1 connection-specific fields, which HTTP/2
+// messages must not carry.
+var connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+// stream is one request and its response. It implements response.Framer
+// for the handler's writer.
+type stream struct {
+	sc  *serverConn
+	id  uint32
+	req *request.Request
+
+	// Read loop only.
+	state      int
+	recvWindow int64
+	// tooLarge is set when the request body went over the size limit.
+	tooLarge bool
+
+	// Guarded by sc.mu. err is set, and done closed, once the stream is
+	// over for good.
+	sendWindow int64
+	err        error
+	done       chan struct{}
+
+	// Handler goroutine only.
+	ended bool
+}
+
+// newStream is called with sc.mu held.
+func newStream(sc *serverConn, id uint32, req *request.Request) *stream {
+	return &stream{
+		sc:         sc,
+		id:         id,
+		req:        req,
+		state:      stateOpen,
+		recvWindow: int64(sc.srv.initialWindowSize),
+		sendWindow: sc.peerInitialWindow,
+		done:       make(chan struct{}),
+	}
+}
+
+// run calls the handler and finishes its response. A response that could
+// not be completed is reset so the client does not wait for it.
+func (st *stream) run() {
+	defer st.sc.handlers.Done()
+
+	w := response.NewFramedWriter(st)
+	if st.req.RequestLine.Method == "HEAD" {
+		// Handlers answer HEAD as they would GET; the writer drops the body.
+		w.DiscardBody()
+	}
+	if st.tooLarge {
+		w.WriteStatusLine(response.StatusRequestEntityTooLarge)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteString("413 Content Too Large\n")
+	} else {
+		st.sc.srv.handler(w, st.req)
+	}
+	w.Finish()
+
+	switch {
+	case !st.ended && st.error() == nil:
+		st.sc.resetStream(st.id, ErrCodeInternal)
+	case st.tooLarge:
+		// The client may still be sending the body; tell it to stop.
+		st.sc.resetStream(st.id, ErrCodeNo)
+	default:
+		st.sc.closeStream(st, errStreamClosed)
+	}
+}
+
+func (st *stream) error() error {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.err
+}
+
+// WriteHeaders implements response.Framer.
+func (st *stream) WriteHeaders(status response.StatusCode, h headers.Headers, setCookies []string) error {
+	if err := st.error(); err != nil {
+		return err
+	}
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(status))}}
+	fields = appendFields(fields, h)
+	for _, value := range setCookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: value})
+	}
+	return st.sc.writeHeaderBlock(st.id, fields, false)
+}
+
+// WriteData implements response.Framer. It blocks while the client's flow
+// control windows are used up.
+func (st *stream) WriteData(p []byte) error {
+	for len(p) > 0 {
+		n, err := st.sc.reserve(st, len(p))
+		if err != nil {
+			return err
+		}
+		if err := st.sc.writeFrame(frameData, 0, st.id, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// WriteTrailers implements response.Framer.
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if err := st.error(); err != nil {
+		return err
+	}
+	if err := st.sc.writeHeaderBlock(st.id, appendFields(nil, h), true); err != nil {
+		return err
+	}
+	st.ended = true
+	return st.sc.flush()
+}
+
+// EndStream implements response.Framer with an empty DATA frame.
+func (st *stream) EndStream() error {
+	if st.ended {
+		return nil
+	}
+	if err := st.error(); err != nil {
+		return err
+	}
+	if err := st.sc.writeFrame(frameData, flagEndStream, st.id, nil); err != nil {
+		return err
+	}
+	st.ended = true
+	return st.sc.flush()
+}
+
+// Flush implements response.Framer.
+func (st *stream) Flush() error {
+	return st.sc.flush()
+}
+
+// CloseNotify implements response.CloseNotifier: the channel is closed
+// when the client resets the stream or the connection ends.
+func (st *stream) CloseNotify() <-chan struct{} {
+	return st.done
+}
+
+// appendFields appends h as header fields, leaving out the ones HTTP/2
+// forbids.
+func appendFields(fields []hpack.HeaderField, h headers.Headers) []hpack.HeaderField {
+	for key, value := range h {
+		key = strings.ToLower(key)
+		if isConnectionHeader(key) {
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{Name: key, Value: value})
+	}
+	return fields
+}
+
+func isConnectionHeader(name string) bool {
+	for _, h := range connectionHeaders {
+		if name == h {
+			return true
+		}
+	}
+	return false
+}
+
+// newRequest builds a request from a decoded header block, checking the
+// rules of RFC 9113 section 8.3.1.
+func newRequest(fields []hpack.HeaderField, remoteAddr string) (*request.Request, error) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{HttpVersion: "2.0"},
+		Headers:     headers.NewHeaders(),
+		RemoteAddr:  remoteAddr,
+	}
+	var scheme, authority string
+	seen := map[string]bool{}
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, fmt.Errorf("pseudo-header %s after regular fields", f.Name)
+			}
+			if seen[f.Name] {
+				return nil, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+			}
+			seen[f.Name] = true
+			switch f.Name {
+			case ":method":
+				req.RequestLine.Method = f.Value
+			case ":path":
+				req.RequestLine.RequestTarget = f.Value
+			case ":scheme":
+				scheme = f.Value
+			case ":authority":
+				authority = f.Value
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			continue
+		}
+
+		regular = true
+		if f.Name != strings.ToLower(f.Name) || !headers.IsKeyCharValid(f.Name) {
+			return nil, fmt.Errorf("invalid field name %q", f.Name)
+		}
+		if isConnectionHeader(f.Name) {
+			return nil, fmt.Errorf("connection-specific field %s", f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, errors.New("TE may only be \"trailers\"")
+		}
+		// Add joins cookie crumbs (section 8.2.3) with "; ".
+		req.Headers.Add(f.Name, f.Value)
+	}
+
+	if req.RequestLine.Method == "" {
+		return nil, errors.New("missing :method")
+	}
+	if req.RequestLine.Method == "CONNECT" {
+		if authority == "" || scheme != "" || req.RequestLine.RequestTarget != "" {
+			return nil, errors.New("CONNECT takes only :method and :authority")
+		}
+		req.RequestLine.RequestTarget = authority
+	} else if scheme == "" || req.RequestLine.RequestTarget == "" {
+		return nil, errors.New("missing :scheme or :path")
+	}
+	// h2 is only negotiated over TLS, h2c only without it.
+	req.TLS = scheme == "https"
+	if authority != "" && req.Headers.Get("host") == "" {
+		req.Headers.Set("host", authority)
+	}
+	return req, nil
+}
+
+// checkTrailers validates a request's trailer block. The request type has
+// nowhere to keep trailers, so they are dropped.
+func checkTrailers(fields []hpack.HeaderField) error {
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return fmt.Errorf("pseudo-header %s in trailers", f.Name)
+		}
+	}
+	return nil
+}
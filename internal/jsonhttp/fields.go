@@ -0,0 +1,264 @@
+package jsonhttp
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxFieldErrors caps how many problems one response lists.
+const maxFieldErrors = 20
+
+var (
+	unmarshalerType     = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// checkFields walks doc, a body decoded into generic values, alongside the
+// type of v and reports fields v has no place for and values of the wrong
+// JSON kind. encoding/json stops at the first such problem and cannot say
+// which array element it was in; this names every field by its full path.
+func checkFields(doc any, v any) []FieldError {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Pointer {
+		// Let json.Decoder report the misuse.
+		return nil
+	}
+	c := &fieldChecker{}
+	c.check("", doc, t.Elem())
+	return c.errs
+}
+
+type fieldChecker struct {
+	errs []FieldError
+}
+
+func (c *fieldChecker) add(path, detail string) {
+	if len(c.errs) < maxFieldErrors {
+		c.errs = append(c.errs, FieldError{Field: path, Detail: detail})
+	}
+}
+
+func (c *fieldChecker) check(path string, value any, t reflect.Type) {
+	if value == nil {
+		// null leaves any Go value as it is.
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if customDecoding(t) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return
+	case reflect.Struct:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			c.mismatch(path, value, t)
+			return
+		}
+		fields := structFields(t)
+		for _, name := range sortedKeys(obj) {
+			f, ok := lookupField(fields, name)
+			if !ok {
+				c.add(joinPath(path, name), "unknown field")
+				continue
+			}
+			if f.quoted {
+				// ",string" fields hold their value inside a JSON string.
+				if _, ok := obj[name].(string); !ok && obj[name] != nil {
+					c.mismatch(joinPath(path, name), obj[name], f.typ)
+				}
+				continue
+			}
+			c.check(joinPath(path, name), obj[name], f.typ)
+		}
+	case reflect.Map:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			c.mismatch(path, value, t)
+			return
+		}
+		for _, key := range sortedKeys(obj) {
+			c.check(joinPath(path, key), obj[key], t.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// []byte is base64 in a string.
+			if _, ok := value.(string); !ok {
+				c.mismatch(path, value, t)
+			}
+			return
+		}
+		arr, ok := value.([]any)
+		if !ok {
+			c.mismatch(path, value, t)
+			return
+		}
+		for i, elem := range arr {
+			c.check(path+"["+strconv.Itoa(i)+"]", elem, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			c.mismatch(path, value, t)
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			c.mismatch(path, value, t)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			c.mismatch(path, value, t)
+		}
+	}
+}
+
+func (c *fieldChecker) mismatch(path string, value any, t reflect.Type) {
+	c.add(path, "cannot use "+kindOf(value)+" as "+typeName(t))
+}
+
+// customDecoding reports whether t decodes itself, in which case any JSON
+// value may be fine.
+func customDecoding(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return t.Implements(unmarshalerType) || pt.Implements(unmarshalerType) ||
+		t.Implements(textUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// field is a JSON object member a struct accepts.
+type field struct {
+	name   string
+	typ    reflect.Type
+	quoted bool
+	depth  int
+}
+
+// structFields lists the members t accepts, following encoding/json: the
+// json tag names a field, "-" hides it, and untagged embedded structs
+// contribute their fields, shadowed by shallower ones.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	var walk func(t reflect.Type, depth int, seen map[reflect.Type]bool)
+	walk = func(t reflect.Type, depth int, seen map[reflect.Type]bool) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := sf.Type
+			if sf.Anonymous && name == "" {
+				et := ft
+				if et.Kind() == reflect.Pointer {
+					et = et.Elem()
+				}
+				if et.Kind() == reflect.Struct {
+					walk(et, depth+1, seen)
+					continue
+				}
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			quoted := false
+			for opt := range strings.SplitSeq(opts, ",") {
+				quoted = quoted || opt == "string"
+			}
+			fields = append(fields, field{name: name, typ: ft, quoted: quoted, depth: depth})
+		}
+	}
+	walk(t, 0, map[reflect.Type]bool{})
+	slices.SortStableFunc(fields, func(a, b field) int { return a.depth - b.depth })
+	return fields
+}
+
+// lookupField finds the member for an object key: an exact match first,
+// then, like encoding/json, a case-insensitive one.
+func lookupField(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// kindOf names the JSON kind of a generic value.
+func kindOf(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	}
+	return "null"
+}
+
+// typeName describes a Go type in JSON terms for error messages.
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return "base64 string"
+		}
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
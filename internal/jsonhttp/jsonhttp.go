@@ -0,0 +1,256 @@
+// Package jsonhttp decodes JSON request bodies and writes JSON responses.
+// Decoding is strict: the Content-Type must be JSON, the body must fit the
+// size limit, and fields the target type does not have are rejected. What
+// goes wrong comes back as an *Error, which WriteError renders as an RFC
+// 9457 problem with the path of every offending field.
+package jsonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultMaxBodySize bounds the bodies Decode accepts.
+const DefaultMaxBodySize = 1 << 20
+
+// Error is a request body Decode could not use. Status is 400 for
+// malformed JSON, 413 for a body over the limit, 415 for a body that is not
+// JSON and 422 for JSON that does not fit the target.
+type Error struct {
+	Status response.StatusCode
+	Detail string
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Detail)
+	for i, f := range e.Fields {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(f.Error())
+	}
+	return b.String()
+}
+
+// FieldError is a problem with one field, named by its path in the
+// document, such as "items[2].name".
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Detail
+	}
+	return e.Field + ": " + e.Detail
+}
+
+// FieldErrors lets Validate report several fields at once.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator is implemented by request types that check their values once
+// decoded. Returning a FieldError or FieldErrors names the fields at fault;
+// any error from Validate becomes a 422.
+type Validator interface {
+	Validate() error
+}
+
+type config struct {
+	maxBodySize int
+}
+
+// Option configures Decode.
+type Option func(*config)
+
+// WithMaxBodySize replaces DefaultMaxBodySize.
+func WithMaxBodySize(n int) Option {
+	return func(c *config) {
+		c.maxBodySize = n
+	}
+}
+
+// Decode decodes req's JSON body into v, which must be a non-nil pointer.
+// Errors about the request are *Error; anything else, such as a v that
+// cannot be decoded into, is a bug in the caller.
+func Decode(req *request.Request, v any, opts ...Option) error {
+	cfg := config{maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	contentType := req.Headers.Get("content-type")
+	if !isJSON(contentType) {
+		return &Error{Status: response.StatusUnsupportedMediaType, Detail: fmt.Sprintf("expected a JSON body, got Content-Type %q", contentType)}
+	}
+	if len(req.Body) > cfg.maxBodySize {
+		return &Error{Status: response.StatusRequestEntityTooLarge, Detail: fmt.Sprintf("body exceeds %d bytes", cfg.maxBodySize)}
+	}
+
+	doc, err := parseDocument(req.Body)
+	if err != nil {
+		return err
+	}
+	if fields := checkFields(doc, v); len(fields) > 0 {
+		return &Error{Status: response.StatusUnprocessableEntity, Detail: "body does not match the expected fields", Fields: fields}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		var invalidErr *json.InvalidUnmarshalError
+		switch {
+		case errors.As(err, &invalidErr):
+			return err
+		case errors.As(err, &typeErr):
+			return &Error{Status: response.StatusUnprocessableEntity, Detail: "body does not match the expected fields", Fields: []FieldError{
+				{Field: bracketIndices(typeErr.Field), Detail: "cannot use " + typeErr.Value + " as " + typeName(typeErr.Type)},
+			}}
+		}
+		return &Error{Status: response.StatusUnprocessableEntity, Detail: err.Error()}
+	}
+
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return validationError(err)
+		}
+	}
+	return nil
+}
+
+// parseDocument checks the body is exactly one JSON value and returns it
+// generically, numbers kept as json.Number.
+func parseDocument(body []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	err := dec.Decode(&doc)
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == io.EOF:
+		return nil, &Error{Status: response.StatusBadRequest, Detail: "body is empty"}
+	case err == io.ErrUnexpectedEOF:
+		return nil, &Error{Status: response.StatusBadRequest, Detail: "body ends in the middle of a JSON value"}
+	case errors.As(err, &syntaxErr):
+		return nil, &Error{Status: response.StatusBadRequest, Detail: fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, err)}
+	case err != nil:
+		return nil, &Error{Status: response.StatusBadRequest, Detail: err.Error()}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &Error{Status: response.StatusBadRequest, Detail: fmt.Sprintf("unexpected data after the JSON value at offset %d", dec.InputOffset())}
+	}
+	return doc, nil
+}
+
+// bracketIndices rewrites the array indices encoding/json puts in a field
+// path, as in "items.0.name", to match checkFields: "items[0].name".
+func bracketIndices(path string) string {
+	var b strings.Builder
+	for i, seg := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(seg); err == nil && i > 0 {
+			b.WriteString("[" + seg + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
+}
+
+func validationError(err error) *Error {
+	e := &Error{Status: response.StatusUnprocessableEntity, Detail: "validation failed"}
+	var fields FieldErrors
+	var field FieldError
+	switch {
+	case errors.As(err, &fields):
+		e.Fields = fields
+	case errors.As(err, &field):
+		e.Fields = []FieldError{field}
+	default:
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+// isJSON reports whether contentType is application/json or a +json type
+// such as application/merge-patch+json.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" ||
+		strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
+
+// Write sends v as a JSON response with the given status.
+func Write(w *response.Writer, status response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.WriteStatusLine(status)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// problem is the RFC 9457 problem details object, with the field errors as
+// an extension member.
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// WriteError answers a request whose body Decode rejected. An *Error
+// becomes application/problem+json with its status and field errors; any
+// other error is a 500 that does not reveal err to the client.
+func WriteError(w *response.Writer, req *request.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		server.NegotiatedErrors(w, req, response.StatusInternalError, nil)
+		return
+	}
+	title := response.StatusText(e.Status)
+	if title == "" {
+		title = "Error"
+	}
+	body, _ := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: int(e.Status),
+		Detail: e.Detail,
+		Errors: e.Fields,
+	})
+	w.WriteStatusLine(e.Status)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Write(body)
+}
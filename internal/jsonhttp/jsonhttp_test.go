@@ -0,0 +1,194 @@
+package jsonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+type order struct {
+	Customer string `json:"customer"`
+	Items    []item `json:"items"`
+	Note     string `json:"note,omitempty"`
+}
+
+func (o *order) Validate() error {
+	var errs FieldErrors
+	if o.Customer == "" {
+		errs = append(errs, FieldError{Field: "customer", Detail: "is required"})
+	}
+	for i, it := range o.Items {
+		if it.Quantity < 1 {
+			errs = append(errs, FieldError{Field: "items[" + strconv.Itoa(i) + "].quantity", Detail: "must be at least 1"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func jsonRequest(contentType, body string) *request.Request {
+	return &request.Request{
+		Headers: map[string]string{"content-type": contentType},
+		Body:    []byte(body),
+	}
+}
+
+func decodeError(t *testing.T, err error) *Error {
+	t.Helper()
+	var e *Error
+	require.True(t, errors.As(err, &e), "got %v", err)
+	return e
+}
+
+func TestDecode(t *testing.T) {
+	var o order
+	err := Decode(jsonRequest("application/json; charset=utf-8", `{"customer":"ada","items":[{"name":"tea","quantity":2}]}`), &o)
+	require.NoError(t, err)
+	assert.Equal(t, order{Customer: "ada", Items: []item{{Name: "tea", Quantity: 2}}}, o)
+
+	// +json media types are JSON too, and keys match case-insensitively
+	// as in encoding/json.
+	var it item
+	require.NoError(t, Decode(jsonRequest("application/merge-patch+json", `{"NAME":"tea"}`), &it))
+	assert.Equal(t, "tea", it.Name)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		body        string
+		status      response.StatusCode
+		fields      []FieldError
+	}{
+		"not json":       {"text/plain", `{}`, 415, nil},
+		"no type":        {"", `{}`, 415, nil},
+		"too large":      {"application/json", `{"note":"` + strings.Repeat("x", 300) + `"}`, 413, nil},
+		"empty":          {"application/json", ``, 400, nil},
+		"syntax":         {"application/json", `{"customer":}`, 400, nil},
+		"truncated":      {"application/json", `{"customer":"ada"`, 400, nil},
+		"trailing data":  {"application/json", `{"customer":"ada"} {}`, 400, nil},
+		"not an object":  {"application/json", `[1]`, 422, []FieldError{{"", "cannot use array as object"}}},
+		"fractional int": {"application/json", `{"customer":"ada","items":[{"quantity":1.5}]}`, 422, []FieldError{{"items[0].quantity", "cannot use number 1.5 as integer"}}},
+		"unknown and mistyped fields": {"application/json", `{"customer":7,"extra":true,"items":[{"name":"a","quantity":1},{"name":"b","qty":2,"quantity":"2"}]}`, 422, []FieldError{
+			{"customer", "cannot use number as string"},
+			{"extra", "unknown field"},
+			{"items[1].qty", "unknown field"},
+			{"items[1].quantity", "cannot use string as integer"},
+		}},
+		"validation": {"application/json", `{"items":[{"name":"a","quantity":0}]}`, 422, []FieldError{
+			{"customer", "is required"},
+			{"items[0].quantity", "must be at least 1"},
+		}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var o order
+			err := Decode(jsonRequest(tt.contentType, tt.body), &o, WithMaxBodySize(256))
+			e := decodeError(t, err)
+			assert.Equal(t, tt.status, e.Status, e.Error())
+			assert.Equal(t, tt.fields, e.Fields)
+		})
+	}
+}
+
+type base struct {
+	ID string `json:"id"`
+}
+
+type custom struct{ raw string }
+
+func (c *custom) UnmarshalJSON(data []byte) error {
+	c.raw = string(data)
+	return nil
+}
+
+type tagged struct {
+	base
+	Hidden  string            `json:"-"`
+	Count   int               `json:"count,string"`
+	Any     any               `json:"any"`
+	Labels  map[string]string `json:"labels"`
+	Data    []byte            `json:"data"`
+	Custom  custom            `json:"custom"`
+	private string
+}
+
+func TestDecodeFollowsEncodingJSON(t *testing.T) {
+	var v tagged
+	body := `{"id":"x","count":"3","any":[1,{"a":null}],"labels":{"k":"v"},"data":"aGk=","custom":{"anything":[true]}}`
+	require.NoError(t, Decode(jsonRequest("application/json", body), &v))
+	assert.Equal(t, "x", v.ID)
+	assert.Equal(t, 3, v.Count)
+	assert.Equal(t, []byte("hi"), v.Data)
+	assert.Equal(t, `{"anything":[true]}`, v.Custom.raw)
+
+	err := Decode(jsonRequest("application/json", `{"Hidden":"h","private":"p","labels":{"k":1}}`), &v)
+	assert.Equal(t, []FieldError{
+		{"Hidden", "unknown field"},
+		{"labels.k", "cannot use number as string"},
+		{"private", "unknown field"},
+	}, decodeError(t, err).Fields)
+}
+
+func TestDecodeNeedsPointer(t *testing.T) {
+	err := Decode(jsonRequest("application/json", `{}`), order{})
+	require.Error(t, err)
+	var e *Error
+	assert.False(t, errors.As(err, &e))
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, Write(w, response.StatusCreated, item{Name: "tea", Quantity: 1}))
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 Created\r\n"), out)
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.Contains(t, out, "content-length: 28\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+`{"name":"tea","quantity":1}`+"\n"), out)
+
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	assert.Error(t, Write(w, response.StatusOK, func() {}))
+	assert.Empty(t, buf.String())
+}
+
+func TestWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	req := jsonRequest("application/json", `{"extra":1}`)
+	WriteError(w, req, Decode(req, &item{}))
+	require.NoError(t, w.Finish())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 422 Unprocessable Content\r\n"), out)
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	var p map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &p))
+	assert.Equal(t, float64(422), p["status"])
+	assert.Equal(t, []any{map[string]any{"field": "extra", "detail": "unknown field"}}, p["errors"])
+
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	WriteError(w, &request.Request{Headers: map[string]string{"accept": "text/plain"}}, errors.New("secret database detail"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 "), buf.String())
+	assert.NotContains(t, buf.String(), "secret")
+}
@@ -0,0 +1,261 @@
+// Package negotiate picks, from the representations a server can produce,
+// the one a client prefers according to its Accept, Accept-Language,
+// Accept-Charset and Accept-Encoding headers (RFC 9110 section 12).
+//
+// The pickers return the offer with the highest q-value, taking each
+// offer's q from the most specific range that matches it. Offers rated
+// equally keep the server's order, and offers the client refuses (q=0, or
+// not matched at all) are never picked.
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// Spec is one element of an Accept-style header: a media range, language
+// range, charset or coding, with its parameters and q-value.
+type Spec struct {
+	// Value is lower-cased, e.g. "text/*", "en-gb" or "gzip".
+	Value string
+	// Params holds the media type parameters that precede q.
+	Params map[string]string
+	Q      float64
+}
+
+// ParseAccept splits an Accept-style header into its elements, in the order
+// they were sent. A missing or malformed q counts as 1.
+func ParseAccept(value string) []Spec {
+	var specs []Spec
+	for part := range strings.SplitSeq(value, ",") {
+		params := strings.Split(part, ";")
+		v := strings.ToLower(strings.TrimSpace(params[0]))
+		if v == "" {
+			continue
+		}
+		spec := Spec{Value: v, Q: 1}
+		for _, p := range params[1:] {
+			name, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok {
+				continue
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if name == "q" {
+				if q, err := strconv.ParseFloat(val, 64); err == nil && q >= 0 && q <= 1 {
+					spec.Q = q
+				}
+				// Anything after q is an accept extension, not a parameter.
+				break
+			}
+			if spec.Params == nil {
+				spec.Params = map[string]string{}
+			}
+			spec.Params[name] = strings.ToLower(val)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// matcher rates how specifically spec matches offer: -1 for no match,
+// higher for more specific ranges.
+type matcher func(spec Spec, offer string) int
+
+// best returns the offer the specs rate highest, or "" if none is
+// acceptable. implicit, if set, gives the q-value of offers no spec
+// matches.
+func best(specs []Spec, offers []string, match matcher, implicit func(offer string) float64) string {
+	bestOffer, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		if implicit != nil {
+			q = implicit(offer)
+		}
+		for _, spec := range specs {
+			if s := match(spec, offer); s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if q > bestQ {
+			bestOffer, bestQ = offer, q
+		}
+	}
+	return bestOffer
+}
+
+// pickFirst applies to headers that are empty, which accept everything.
+func pickFirst(header string, offers []string) (string, bool) {
+	if strings.TrimSpace(header) != "" {
+		return "", false
+	}
+	if len(offers) == 0 {
+		return "", true
+	}
+	return offers[0], true
+}
+
+// MediaType picks from offers, such as "application/json", using an Accept
+// header. Ranges with parameters only match offers carrying the same ones.
+func MediaType(accept string, offers ...string) string {
+	if offer, ok := pickFirst(accept, offers); ok {
+		return offer
+	}
+	return best(ParseAccept(accept), offers, matchMediaType, nil)
+}
+
+func matchMediaType(spec Spec, offer string) int {
+	offerType, offerParams := splitMediaType(offer)
+	typ, _, _ := strings.Cut(offerType, "/")
+	specificity := 0
+	switch spec.Value {
+	case "*/*":
+	case typ + "/*":
+		specificity = 1
+	case offerType:
+		specificity = 2
+	default:
+		return -1
+	}
+	for name, value := range spec.Params {
+		if offerParams[name] != value {
+			return -1
+		}
+		specificity++
+	}
+	return specificity
+}
+
+// splitMediaType lower-cases a media type and separates its parameters.
+func splitMediaType(mediaType string) (string, map[string]string) {
+	base, rest, _ := strings.Cut(mediaType, ";")
+	var params map[string]string
+	for p := range strings.SplitSeq(rest, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			continue
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`))
+	}
+	return strings.ToLower(strings.TrimSpace(base)), params
+}
+
+// Language picks from offers, such as "en-US", using an Accept-Language
+// header. A range matches a tag equal to it or starting with it followed
+// by "-", so "en" matches "en-US" (RFC 4647 basic filtering).
+func Language(acceptLanguage string, offers ...string) string {
+	if offer, ok := pickFirst(acceptLanguage, offers); ok {
+		return offer
+	}
+	return best(ParseAccept(acceptLanguage), offers, matchLanguage, nil)
+}
+
+func matchLanguage(spec Spec, offer string) int {
+	offer = strings.ToLower(offer)
+	switch {
+	case spec.Value == "*":
+		return 0
+	case offer == spec.Value || strings.HasPrefix(offer, spec.Value+"-"):
+		// Longer ranges are more specific.
+		return 1 + strings.Count(spec.Value, "-")
+	}
+	return -1
+}
+
+// Charset picks from offers, such as "utf-8", using an Accept-Charset
+// header.
+func Charset(acceptCharset string, offers ...string) string {
+	if offer, ok := pickFirst(acceptCharset, offers); ok {
+		return offer
+	}
+	return best(ParseAccept(acceptCharset), offers, matchToken, nil)
+}
+
+// Encoding picks from offers, such as "gzip", using an Accept-Encoding
+// header. An empty header asks for no coding at all, so only an "identity"
+// offer can match it. Identity stays acceptable unless the header refuses
+// it, but any coding the client lists outranks it.
+func Encoding(acceptEncoding string, offers ...string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		for _, offer := range offers {
+			if strings.EqualFold(offer, "identity") {
+				return offer
+			}
+		}
+		return ""
+	}
+	return best(ParseAccept(acceptEncoding), offers, matchToken, func(offer string) float64 {
+		if strings.EqualFold(offer, "identity") {
+			return identityQ
+		}
+		return 0
+	})
+}
+
+// identityQ is the q-value identity gets when Accept-Encoding does not
+// mention it: acceptable, but below anything the client listed.
+const identityQ = 0.001
+
+func matchToken(spec Spec, offer string) int {
+	switch {
+	case spec.Value == "*":
+		return 0
+	case strings.EqualFold(spec.Value, offer):
+		return 1
+	}
+	return -1
+}
+
+// Field is a request header that drives negotiation.
+type Field string
+
+const (
+	Accept         Field = "Accept"
+	AcceptLanguage Field = "Accept-Language"
+	AcceptCharset  Field = "Accept-Charset"
+	AcceptEncoding Field = "Accept-Encoding"
+)
+
+// pick runs the picker for f.
+func (f Field) pick(value string, offers []string) string {
+	switch f {
+	case AcceptLanguage:
+		return Language(value, offers...)
+	case AcceptCharset:
+		return Charset(value, offers...)
+	case AcceptEncoding:
+		return Encoding(value, offers...)
+	}
+	return MediaType(value, offers...)
+}
+
+// Select picks the best of offers using req's field header and adds field
+// to w's Vary header, since the response now depends on it. A request
+// without the header gets the first offer. When none of the offers is
+// acceptable Select answers 406 Not Acceptable, listing them, and returns
+// false.
+func Select(w *response.Writer, req *request.Request, field Field, offers ...string) (string, bool) {
+	w.AddVary(string(field))
+
+	value, sent := "", false
+	if req != nil && req.Headers != nil {
+		value, sent = req.Headers[strings.ToLower(string(field))]
+	}
+	if !sent && len(offers) > 0 {
+		return offers[0], true
+	}
+	if offer := field.pick(value, offers); offer != "" {
+		return offer, true
+	}
+
+	w.WriteStatusLine(response.StatusNotAcceptable)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteString("406 Not Acceptable\nAvailable: " + strings.Join(offers, ", ") + "\n")
+	return "", false
+}
@@ -0,0 +1,129 @@
+package negotiate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	specs := ParseAccept(`Text/HTML;level=1, text/*;q=0.3;ext=x, ,*/*;q=bogus, en;q=1.5, gzip;q="0.8"`)
+	assert.Equal(t, []Spec{
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+		{Value: "text/*", Q: 0.3},
+		{Value: "*/*", Q: 1},
+		{Value: "en", Q: 1},
+		{Value: "gzip", Q: 0.8},
+	}, specs)
+}
+
+func TestMediaType(t *testing.T) {
+	offers := []string{"text/html", "application/json", "text/plain"}
+	tests := map[string]string{
+		"":                                  "text/html",
+		"*/*":                               "text/html",
+		"application/json":                  "application/json",
+		"text/*":                            "text/html",
+		"text/*, text/html;q=0":             "text/plain",
+		"text/html;q=0.1, text/*;q=0.5":     "text/plain",
+		"application/json;q=0.9, */*;q=0.8": "application/json",
+		"Application/JSON":                  "application/json",
+		"text/html;level=1, application/json;q=0.5": "application/json",
+		"image/png": "",
+		"*/*;q=0":   "",
+	}
+	for accept, want := range tests {
+		assert.Equal(t, want, MediaType(accept, offers...), accept)
+	}
+
+	// Ranges with parameters need offers with the same parameters, and
+	// they are more specific than the bare type.
+	assert.Equal(t, "text/html;level=1", MediaType("text/html;q=0.2, text/html;level=1", "text/html", "text/html;level=1"))
+}
+
+func TestLanguage(t *testing.T) {
+	offers := []string{"en-US", "fr", "de-CH"}
+	tests := map[string]string{
+		"":                      "en-US",
+		"fr":                    "fr",
+		"en":                    "en-US",
+		"de-ch, fr;q=0.5":       "de-CH",
+		"de":                    "de-CH",
+		"de-DE":                 "",
+		"*;q=0.1, fr;q=0.5":     "fr",
+		"en-us;q=0, *":          "fr",
+		"es, pt":                "",
+		"en;q=0.4, en-US-x-foo": "en-US",
+		"en-US;q=0.3, en;q=0.9": "en-US",
+	}
+	for accept, want := range tests {
+		assert.Equal(t, want, Language(accept, offers...), accept)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	assert.Equal(t, "utf-8", Charset("", "utf-8", "iso-8859-1"))
+	assert.Equal(t, "iso-8859-1", Charset("ISO-8859-1, utf-8;q=0.5", "utf-8", "iso-8859-1"))
+	assert.Equal(t, "utf-8", Charset("*", "utf-8", "iso-8859-1"))
+	assert.Equal(t, "", Charset("utf-16", "utf-8"))
+}
+
+func TestEncoding(t *testing.T) {
+	offers := []string{"gzip", "br", "identity"}
+	tests := map[string]string{
+		"":                     "identity",
+		"gzip":                 "gzip",
+		"br;q=0.9, gzip;q=0.8": "br",
+		"*":                    "gzip",
+		"zstd":                 "identity",
+		"identity;q=0, zstd":   "",
+		"*;q=0":                "",
+		"*;q=0, identity":      "identity",
+		"gzip;q=0, br;q=0, *":  "identity",
+	}
+	for accept, want := range tests {
+		assert.Equal(t, want, Encoding(accept, offers...), accept)
+	}
+	// Without an identity offer nothing is picked for an empty header.
+	assert.Equal(t, "", Encoding("", "gzip"))
+}
+
+func TestSelect(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	req := &request.Request{Headers: map[string]string{"accept": "application/json", "accept-language": "fr"}}
+
+	offer, ok := Select(w, req, Accept, "text/html", "application/json")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", offer)
+	offer, ok = Select(w, req, AcceptLanguage, "en", "fr")
+	assert.True(t, ok)
+	assert.Equal(t, "fr", offer)
+	// The client sent no Accept-Charset, so anything goes.
+	offer, ok = Select(w, req, AcceptCharset, "utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, "utf-8", offer)
+	_, _ = Select(w, req, Accept, "application/json")
+	assert.Equal(t, "Accept, Accept-Language, Accept-Charset", w.Header().Get("Vary"))
+}
+
+func TestSelectNotAcceptable(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	req := &request.Request{Headers: map[string]string{"accept": "image/png"}}
+
+	offer, ok := Select(w, req, Accept, "text/html", "application/json")
+	assert.False(t, ok)
+	assert.Empty(t, offer)
+	require.NoError(t, w.Finish())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 406 Not Acceptable\r\n"), out)
+	assert.Contains(t, out, "vary: Accept\r\n")
+	assert.True(t, strings.HasSuffix(out, "Available: text/html, application/json\n"), out)
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/client"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// ForwardProxy is an HTTP proxy for clients configured to use this server
+// as theirs: absolute-form requests are forwarded to the host they name,
+// and CONNECT opens a tunnel. Use NewForward to build one.
+type ForwardProxy struct {
+	allowed  []string
+	user     string
+	password string
+	timeout  time.Duration
+	client   *client.Client
+}
+
+// ForwardOption configures a ForwardProxy.
+type ForwardOption func(*ForwardProxy)
+
+// WithAllowedHosts limits the hosts clients may reach. A pattern is a host
+// name, optionally with a port ("example.com:443"), or a "*." wildcard
+// that matches subdomains ("*.example.com"). Without this option every
+// host is allowed.
+func WithAllowedHosts(patterns ...string) ForwardOption {
+	return func(p *ForwardProxy) {
+		p.allowed = patterns
+	}
+}
+
+// WithBasicAuth requires clients to send these credentials in a
+// Proxy-Authorization header.
+func WithBasicAuth(user, password string) ForwardOption {
+	return func(p *ForwardProxy) {
+		p.user = user
+		p.password = password
+	}
+}
+
+// WithForwardTimeout replaces DefaultTimeout for connecting upstream and
+// waiting for response headers.
+func WithForwardTimeout(d time.Duration) ForwardOption {
+	return func(p *ForwardProxy) {
+		p.timeout = d
+	}
+}
+
+// NewForward returns a forward proxy.
+func NewForward(opts ...ForwardOption) *ForwardProxy {
+	p := &ForwardProxy{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.client = client.New(client.WithTimeout(p.timeout))
+	return p
+}
+
+// IsProxyRequest reports whether req is meant for a forward proxy rather
+// than for this server: a CONNECT or an absolute-form request target.
+func IsProxyRequest(req *request.Request) bool {
+	target := req.RequestLine.RequestTarget
+	return req.RequestLine.Method == "CONNECT" ||
+		strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// Handle serves a proxy request.
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if !p.authorized(req.Headers.Get("proxy-authorization")) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		server.NegotiatedErrors(w, req, response.StatusProxyAuthRequired, errors.New("proxy authentication required"))
+		return
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || !target.IsAbs() || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+		server.NegotiatedErrors(w, req, response.StatusBadRequest, fmt.Errorf("proxy requests need an absolute http URL, got %q", req.RequestLine.RequestTarget))
+		return
+	}
+	if !p.allowedHost(client.HostPort(target)) {
+		server.NegotiatedErrors(w, req, response.StatusForbidden, fmt.Errorf("host %s is not allowed", target.Hostname()))
+		return
+	}
+
+	outReq := &client.Request{
+		Method:  req.RequestLine.Method,
+		URL:     target,
+		Headers: stripHopHeaders(req.Headers),
+		Body:    req.Body,
+	}
+	outReq.Headers.Delete("Host")
+	addForwardingHeaders(outReq.Headers, req, req.Headers.Get("host"))
+
+	resp, err := p.client.Do(outReq)
+	if err != nil {
+		upstreamError(w, req, err)
+		return
+	}
+	defer resp.Body.Close()
+	relay(w, req, resp, false)
+}
+
+// tunnel answers CONNECT by dialing the requested authority and splicing
+// the two connections together until either side is done.
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	authority := req.RequestLine.RequestTarget
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil || host == "" || port == "" {
+		server.NegotiatedErrors(w, req, response.StatusBadRequest, fmt.Errorf("CONNECT needs host:port, got %q", authority))
+		return
+	}
+	if !p.allowedHost(authority) {
+		server.NegotiatedErrors(w, req, response.StatusForbidden, fmt.Errorf("host %s is not allowed", host))
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", authority, p.timeout)
+	if err != nil {
+		upstreamError(w, req, err)
+		return
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		server.NegotiatedErrors(w, req, response.StatusInternalError, err)
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	// Clients may start the tunneled protocol without waiting for the 200.
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			conn.Close()
+			upstream.Close()
+			return
+		}
+	}
+	splice(conn, upstream)
+}
+
+// splice copies bytes both ways between a and b, then closes both.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Pass the end of one direction on, keeping the other open.
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func upstreamError(w *response.Writer, req *request.Request, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		server.NegotiatedErrors(w, req, response.StatusGatewayTimeout, errors.New("upstream timed out"))
+		return
+	}
+	server.NegotiatedErrors(w, req, response.StatusBadGateway, errors.New("upstream unavailable"))
+}
+
+// authorized checks a Proxy-Authorization value against the configured
+// credentials.
+func (p *ForwardProxy) authorized(value string) bool {
+	if p.user == "" && p.password == "" {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(p.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(p.password)) == 1
+	return userOK && passwordOK
+}
+
+// allowedHost reports whether authority (host:port) matches the allow list.
+func (p *ForwardProxy) allowedHost(authority string) bool {
+	if len(p.allowed) == 0 {
+		return true
+	}
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+
+	for _, pattern := range p.allowed {
+		pattern = strings.ToLower(pattern)
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			patternHost, patternPort = pattern, ""
+		}
+		if patternPort != "" && patternPort != port {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == patternHost {
+			return true
+		}
+	}
+	return false
+}
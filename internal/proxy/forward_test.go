@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveForward runs req through p and parses what it wrote.
+func serveForward(t *testing.T, p *ForwardProxy, method, target string, auth string) (*http.Response, string) {
+	t.Helper()
+	req := newRequest(method, target, "")
+	if auth != "" {
+		req.Headers.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	p.Handle(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestForwardAbsoluteForm(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		io.WriteString(w, "from upstream")
+	}))
+	defer upstream.Close()
+
+	p := NewForward(WithBasicAuth("dev", "secret"))
+	resp, body := serveForward(t, p, "GET", upstream.URL+"/hello?x=1", "dev:secret")
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "from upstream", body)
+	require.NotNil(t, got)
+	assert.Equal(t, "/hello", got.URL.Path)
+	assert.Equal(t, "x=1", got.URL.RawQuery)
+	assert.Empty(t, got.Header.Get("Proxy-Authorization"))
+	assert.Equal(t, "192.0.2.7", got.Header.Get("X-Forwarded-For"))
+}
+
+func TestForwardAuth(t *testing.T) {
+	p := NewForward(WithBasicAuth("dev", "secret"))
+	for _, auth := range []string{"", "dev:wrong", "nobody:secret"} {
+		resp, _ := serveForward(t, p, "GET", "http://example.com/", auth)
+		assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode, auth)
+		assert.Equal(t, `Basic realm="proxy"`, resp.Header.Get("Proxy-Authenticate"))
+	}
+}
+
+func TestForwardRejects(t *testing.T) {
+	p := NewForward(WithAllowedHosts("example.com"))
+
+	resp, _ := serveForward(t, p, "GET", "http://evil.test/", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = serveForward(t, p, "CONNECT", "evil.test:443", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = serveForward(t, p, "GET", "/not/absolute", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = serveForward(t, p, "CONNECT", "example.com", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAllowedHost(t *testing.T) {
+	p := NewForward(WithAllowedHosts("example.com", "*.internal.test", "api.test:8443"))
+
+	assert.True(t, p.allowedHost("example.com:80"))
+	assert.True(t, p.allowedHost("EXAMPLE.com:443"))
+	assert.False(t, p.allowedHost("www.example.com:80"))
+	assert.True(t, p.allowedHost("a.internal.test:80"))
+	assert.True(t, p.allowedHost("a.b.internal.test:80"))
+	assert.False(t, p.allowedHost("internal.test:80"))
+	assert.True(t, p.allowedHost("api.test:8443"))
+	assert.False(t, p.allowedHost("api.test:443"))
+
+	assert.True(t, NewForward().allowedHost("anything:1"))
+}
+
+func TestConnectTunnel(t *testing.T) {
+	// An echo server stands in for the TLS endpoint.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	clientSide, proxySide := net.Pipe()
+	p := NewForward(WithAllowedHosts("127.0.0.1"))
+	done := make(chan struct{})
+	go func() {
+		w := response.NewWriter(proxySide)
+		p.Handle(w, newRequest("CONNECT", l.Addr().String(), ""))
+		assert.True(t, w.Hijacked())
+		close(done)
+	}()
+
+	br := bufio.NewReader(clientSide)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+	blank, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = clientSide.Write([]byte("ping"))
+	require.NoError(t, err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(br, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echo))
+
+	clientSide.Close()
+	<-done
+}
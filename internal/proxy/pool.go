@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/client"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+)
+
+// Policy selects which backend of a Pool serves a request.
+type Policy int
+
+const (
+	// RoundRobin cycles through the available backends in order.
+	RoundRobin Policy = iota
+	// LeastConnections picks the backend with the fewest requests in
+	// flight, breaking ties in pool order.
+	LeastConnections
+	// ConsistentHash maps each client (or hash header value) to the same
+	// backend for as long as that backend is available, moving only a
+	// small share of clients when the pool changes.
+	ConsistentHash
+)
+
+// hashReplicas is how many points each backend gets on the hash ring.
+const hashReplicas = 100
+
+// backend is one upstream server in a Pool.
+type backend struct {
+	url    *url.URL
+	active atomic.Int64 // requests in flight
+
+	mu           sync.Mutex
+	healthy      bool      // result of the last active health check
+	failures     int       // consecutive connection failures
+	ejectedUntil time.Time // passive ejection deadline
+}
+
+func (b *backend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy && !now.Before(b.ejectedUntil)
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *backend
+}
+
+// Pool is a set of interchangeable upstream servers. Use NewPool to build
+// one and NewBalanced to proxy to it.
+type Pool struct {
+	backends []*backend
+	policy   Policy
+	next     atomic.Uint64 // round-robin cursor
+	ring     []ringPoint
+	hashKey  string // header hashed by ConsistentHash; client IP if empty
+
+	maxFails     int
+	ejectTimeout time.Duration
+
+	checkPath     string
+	checkInterval time.Duration
+	checkClient   *client.Client
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithPolicy sets the selection policy. The default is RoundRobin.
+func WithPolicy(policy Policy) PoolOption {
+	return func(p *Pool) {
+		p.policy = policy
+	}
+}
+
+// WithHashHeader makes ConsistentHash key on the named request header
+// instead of the client IP.
+func WithHashHeader(name string) PoolOption {
+	return func(p *Pool) {
+		p.hashKey = name
+	}
+}
+
+// WithHealthCheck probes every backend with GET path each interval. A
+// backend is taken out of rotation when a probe fails or answers with a
+// status of 500 or above, and put back once a probe succeeds.
+func WithHealthCheck(path string, interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.checkPath = path
+		p.checkInterval = interval
+	}
+}
+
+// WithPassiveEjection takes a backend out of rotation for timeout after
+// maxFails consecutive connection failures seen while proxying. The
+// default is 3 failures and 30 seconds; maxFails <= 0 disables it.
+func WithPassiveEjection(maxFails int, timeout time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxFails = maxFails
+		p.ejectTimeout = timeout
+	}
+}
+
+// NewPool returns a pool of the given upstreams, absolute http or https
+// URLs. If health checks are configured, Close stops them.
+func NewPool(upstreams []string, opts ...PoolOption) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+
+	p := &Pool{
+		maxFails:     3,
+		ejectTimeout: 30 * time.Second,
+		stop:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.backends = append(p.backends, &backend{url: u, healthy: true})
+	}
+
+	if p.policy == ConsistentHash {
+		for _, b := range p.backends {
+			for i := 0; i < hashReplicas; i++ {
+				h := crc32.ChecksumIEEE([]byte(b.url.String() + "#" + strconv.Itoa(i)))
+				p.ring = append(p.ring, ringPoint{hash: h, backend: b})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+
+	if p.checkInterval > 0 {
+		p.checkClient = client.New(client.WithTimeout(p.checkInterval))
+		go p.runHealthChecks()
+	}
+	return p, nil
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: must be an absolute http or https URL", upstream)
+	}
+	return u, nil
+}
+
+// Close stops the health checks.
+func (p *Pool) Close() error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	return nil
+}
+
+// pick returns the backend for req, skipping those in tried and those out
+// of rotation, or nil if none is left.
+func (p *Pool) pick(req *request.Request, tried map[*backend]bool) *backend {
+	now := time.Now()
+	usable := func(b *backend) bool {
+		return !tried[b] && b.available(now)
+	}
+
+	switch p.policy {
+	case LeastConnections:
+		var best *backend
+		for _, b := range p.backends {
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+
+	case ConsistentHash:
+		h := crc32.ChecksumIEEE([]byte(p.hashValue(req)))
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for i := range p.ring {
+			b := p.ring[(start+i)%len(p.ring)].backend
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+
+	default:
+		n := uint64(len(p.backends))
+		start := p.next.Add(1) - 1
+		for i := uint64(0); i < n; i++ {
+			b := p.backends[(start+i)%n]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+func (p *Pool) hashValue(req *request.Request) string {
+	if p.hashKey != "" {
+		return req.Headers.Get(p.hashKey)
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// reportFailure records a connection failure and ejects b once it has
+// failed maxFails times in a row.
+func (p *Pool) reportFailure(b *backend) {
+	if p.maxFails <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= p.maxFails {
+		b.ejectedUntil = time.Now().Add(p.ejectTimeout)
+		b.failures = 0
+	}
+}
+
+func (p *Pool) reportSuccess(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+func (p *Pool) runHealthChecks() {
+	ticker := time.NewTicker(p.checkInterval)
+	defer ticker.Stop()
+
+	p.checkAll()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkAll()
+		}
+	}
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			healthy := p.check(b)
+			b.mu.Lock()
+			b.healthy = healthy
+			if healthy {
+				// A backend that answers probes again is no longer ejected.
+				b.ejectedUntil = time.Time{}
+				b.failures = 0
+			}
+			b.mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(b *backend) bool {
+	u := *b.url
+	u.Path = joinPath(b.url.Path, p.checkPath)
+	u.RawQuery = ""
+	resp, err := p.checkClient.Get(u.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedBackend is a local upstream that answers with its name.
+func namedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// deadAddr returns a URL nothing is listening on.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, c := namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")
+	pool, err := NewPool([]string{a.URL, b.URL, c.URL})
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	var got string
+	for i := 0; i < 6; i++ {
+		_, body := serve(t, p, newRequest("GET", "/", ""))
+		got += body
+	}
+	assert.Equal(t, "abcabc", got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+	fast := namedBackend(t, "fast")
+
+	pool, err := NewPool([]string{slow.URL, fast.URL}, WithPolicy(LeastConnections))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	done := make(chan string)
+	go func() {
+		_, body := serve(t, p, newRequest("GET", "/", ""))
+		done <- body
+	}()
+	<-entered
+
+	for i := 0; i < 3; i++ {
+		_, body := serve(t, p, newRequest("GET", "/", ""))
+		assert.Equal(t, "fast", body)
+	}
+	close(release)
+	assert.Equal(t, "slow", <-done)
+}
+
+func TestConsistentHash(t *testing.T) {
+	a, b, c := namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")
+	pool, err := NewPool([]string{a.URL, b.URL, c.URL}, WithPolicy(ConsistentHash))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	owners := map[string]string{}
+	for i := 0; i < 30; i++ {
+		req := newRequest("GET", "/", "")
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+		_, body := serve(t, p, req)
+		owners[req.RemoteAddr] = body
+
+		// Same client, different port: same backend.
+		req = newRequest("GET", "/", "")
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:4321", i)
+		_, again := serve(t, p, req)
+		assert.Equal(t, body, again)
+	}
+
+	used := map[string]bool{}
+	for _, owner := range owners {
+		used[owner] = true
+	}
+	assert.Len(t, used, 3)
+
+	// Taking "a" away only moves the clients that were on it.
+	pool.backends[0].ejectedUntil = time.Now().Add(time.Hour)
+	for addr, owner := range owners {
+		req := newRequest("GET", "/", "")
+		req.RemoteAddr = addr
+		_, body := serve(t, p, req)
+		if owner == "a" {
+			assert.NotEqual(t, "a", body)
+		} else {
+			assert.Equal(t, owner, body)
+		}
+	}
+}
+
+func TestHashHeader(t *testing.T) {
+	a, b := namedBackend(t, "a"), namedBackend(t, "b")
+	pool, err := NewPool([]string{a.URL, b.URL}, WithPolicy(ConsistentHash), WithHashHeader("X-User"))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	first := ""
+	for i := 0; i < 10; i++ {
+		req := newRequest("GET", "/", "")
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+		req.Headers.Set("X-User", "alice")
+		_, body := serve(t, p, req)
+		if first == "" {
+			first = body
+		}
+		assert.Equal(t, first, body)
+	}
+}
+
+func TestActiveHealthCheck(t *testing.T) {
+	var sick atomic.Bool
+	sick.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && sick.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "flaky")
+	}))
+	defer flaky.Close()
+	steady := namedBackend(t, "steady")
+
+	pool, err := NewPool([]string{flaky.URL, steady.URL}, WithHealthCheck("/healthz", 10*time.Millisecond))
+	require.NoError(t, err)
+	defer pool.Close()
+	p := NewBalanced(pool)
+
+	assert.Eventually(t, func() bool { return !pool.backends[0].available(time.Now()) }, time.Second, 5*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		_, body := serve(t, p, newRequest("GET", "/", ""))
+		assert.Equal(t, "steady", body)
+	}
+
+	sick.Store(false)
+	assert.Eventually(t, func() bool { return pool.backends[0].available(time.Now()) }, time.Second, 5*time.Millisecond)
+}
+
+func TestRetryAndPassiveEjection(t *testing.T) {
+	live := namedBackend(t, "live")
+	pool, err := NewPool([]string{deadAddr(t), live.URL}, WithPassiveEjection(2, time.Hour))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	// Round robin starts at the dead backend; GET moves on to the live one.
+	resp, body := serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "live", body)
+
+	// The cursor is back on the dead backend; POST is not retried.
+	resp, _ = serve(t, p, newRequest("POST", "/", "data"))
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	// Two failures in a row: the dead backend is out of rotation.
+	assert.False(t, pool.backends[0].available(time.Now()))
+	for i := 0; i < 3; i++ {
+		_, body := serve(t, p, newRequest("POST", "/", "data"))
+		assert.Equal(t, "live", body)
+	}
+}
+
+func TestNoBackendAvailable(t *testing.T) {
+	pool, err := NewPool([]string{deadAddr(t)}, WithPassiveEjection(1, time.Hour))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	resp, _ := serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	resp, _ = serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
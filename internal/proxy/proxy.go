@@ -0,0 +1,347 @@
+// Package proxy implements reverse and forward HTTP proxies.
+package proxy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/client"
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultTimeout bounds connecting to the upstream and waiting for its
+// response headers. Reading the body is not limited, so streams can stay
+// open.
+const DefaultTimeout = 30 * time.Second
+
+// hopHeaders describe a single connection and are never forwarded
+// (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards the requests it handles to a pool of upstream
+// servers. Use New or NewBalanced to build one and pass its Handle method
+// to server.Serve.
+type ReverseProxy struct {
+	pool         *Pool
+	timeout      time.Duration
+	retries      int
+	preserveHost bool
+	integrity    bool
+	client       *client.Client
+}
+
+// Option configures a ReverseProxy.
+type Option func(*ReverseProxy)
+
+// WithTimeout replaces DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(p *ReverseProxy) {
+		p.timeout = d
+	}
+}
+
+// WithRetries sets how many other backends an idempotent request is
+// retried on after a connection failure. The default is 2.
+func WithRetries(n int) Option {
+	return func(p *ReverseProxy) {
+		p.retries = n
+	}
+}
+
+// WithPreserveHost forwards the client's Host header instead of replacing
+// it with the upstream's host.
+func WithPreserveHost() Option {
+	return func(p *ReverseProxy) {
+		p.preserveHost = true
+	}
+}
+
+// WithIntegrityTrailers streams every response body chunked and ends it
+// with X-Content-SHA256 and X-Content-Length trailers computed over the
+// bytes relayed.
+func WithIntegrityTrailers() Option {
+	return func(p *ReverseProxy) {
+		p.integrity = true
+	}
+}
+
+// New returns a proxy for upstream, an absolute http or https URL. A path
+// in upstream is prepended to every request target.
+func New(upstream string, opts ...Option) (*ReverseProxy, error) {
+	// Ejecting the only backend would just turn 502s into 503s.
+	pool, err := NewPool([]string{upstream}, WithPassiveEjection(0, 0))
+	if err != nil {
+		return nil, err
+	}
+	return NewBalanced(pool, opts...), nil
+}
+
+// NewBalanced returns a proxy that spreads requests over pool.
+func NewBalanced(pool *Pool, opts ...Option) *ReverseProxy {
+	p := &ReverseProxy{pool: pool, timeout: DefaultTimeout, retries: 2}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.client = client.New(client.WithTimeout(p.timeout))
+	return p
+}
+
+// Handle forwards req to an upstream and relays its response. Idempotent
+// requests that fail to reach a backend are retried on another one.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.IsAbs() {
+		server.NegotiatedErrors(w, req, response.StatusBadRequest, fmt.Errorf("invalid request target %q", req.RequestLine.RequestTarget))
+		return
+	}
+
+	tried := map[*backend]bool{}
+	for attempt := 0; ; attempt++ {
+		b := p.pool.pick(req, tried)
+		if b == nil {
+			if attempt == 0 {
+				server.NegotiatedErrors(w, req, response.StatusServiceUnavailable, errors.New("no upstream available"))
+			} else {
+				server.NegotiatedErrors(w, req, response.StatusBadGateway, errors.New("upstream unavailable"))
+			}
+			return
+		}
+		tried[b] = true
+
+		outReq := p.outgoingRequest(req, b.url, target)
+		b.active.Add(1)
+		resp, err := p.client.Do(outReq)
+		if err != nil {
+			b.active.Add(-1)
+			p.pool.reportFailure(b)
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				server.NegotiatedErrors(w, req, response.StatusGatewayTimeout, errors.New("upstream timed out"))
+				return
+			}
+			if client.Idempotent(req.RequestLine.Method) && attempt < p.retries {
+				continue
+			}
+			server.NegotiatedErrors(w, req, response.StatusBadGateway, errors.New("upstream unavailable"))
+			return
+		}
+		p.pool.reportSuccess(b)
+
+		relay(w, req, resp, p.integrity)
+		resp.Body.Close()
+		b.active.Add(-1)
+		return
+	}
+}
+
+// outgoingRequest builds the request sent to upstream from the client's.
+func (p *ReverseProxy) outgoingRequest(req *request.Request, upstream, target *url.URL) *client.Request {
+	u := *upstream
+	u.Path = joinPath(upstream.Path, target.Path)
+	u.RawPath = ""
+	switch {
+	case upstream.RawQuery == "":
+		u.RawQuery = target.RawQuery
+	case target.RawQuery != "":
+		u.RawQuery = upstream.RawQuery + "&" + target.RawQuery
+	}
+
+	outReq := &client.Request{
+		Method:  req.RequestLine.Method,
+		URL:     &u,
+		Headers: stripHopHeaders(req.Headers),
+		Body:    req.Body,
+	}
+	outReq.Headers.Delete("Host")
+
+	clientHost := req.Headers.Get("host")
+	if p.preserveHost && clientHost != "" {
+		outReq.Host = clientHost
+	}
+	addForwardingHeaders(outReq.Headers, req, clientHost)
+	return outReq
+}
+
+// addForwardingHeaders records the client hop of req in both the
+// X-Forwarded-* headers and RFC 7239 Forwarded, appending to what earlier
+// proxies sent.
+func addForwardingHeaders(h headers.Headers, req *request.Request, host string) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+	proto := "http"
+	if req.TLS {
+		proto = "https"
+	}
+
+	if clientIP != "" {
+		if prior := h.Get("X-Forwarded-For"); prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			h.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	h.Set("X-Forwarded-Proto", proto)
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+
+	var elem []string
+	if clientIP != "" {
+		node := clientIP
+		if strings.Contains(node, ":") {
+			// IPv6 addresses must be bracketed and quoted.
+			node = `"[` + node + `]"`
+		}
+		elem = append(elem, "for="+node)
+	}
+	if host != "" {
+		elem = append(elem, "host="+quoteForwarded(host))
+	}
+	elem = append(elem, "proto="+proto)
+	forwarded := strings.Join(elem, ";")
+	if prior := h.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	h.Set("Forwarded", forwarded)
+}
+
+// quoteForwarded quotes a Forwarded parameter value unless it is a token.
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+// relay copies the upstream response to w, streaming the body. With
+// integrity set the body is followed by digest trailers.
+func relay(w *response.Writer, req *request.Request, resp *client.Response, integrity bool) {
+	// Strip the upstream's hop headers before merging, so the ones this
+	// server set for its own connection survive.
+	out := w.Header()
+	for key, value := range stripHopHeaders(resp.Headers) {
+		out.Set(key, value)
+	}
+	for _, value := range resp.SetCookies {
+		w.AddSetCookie(value)
+	}
+	w.WriteStatusLine(resp.StatusCode)
+
+	trailerNames := headers.Tokens(resp.Headers.Get("trailer"))
+	if integrity {
+		trailerNames = append(trailerNames, "X-Content-SHA256", "X-Content-Length")
+	}
+
+	// A HEAD response has no body to end with trailers, and its
+	// Content-Length describes the body GET would get.
+	chunked := len(trailerNames) > 0 && req.RequestLine.Method != "HEAD"
+	if chunked {
+		out.Delete("Content-Length")
+		out.Set("Trailer", strings.Join(trailerNames, ", "))
+	} else if resp.ContentLength >= 0 {
+		out.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	hash := sha256.New()
+	total := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			var werr error
+			if chunked {
+				_, werr = w.WriteChunkedBody(buf[:n])
+			} else {
+				_, werr = w.Write(buf[:n])
+			}
+			if werr != nil {
+				return
+			}
+			hash.Write(buf[:n])
+			total += n
+			// Relay each piece as it arrives rather than when the buffer
+			// fills, so streamed responses stay live.
+			if w.Flush() != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Too late for an error status; cutting the body short is all
+			// the client will see.
+			return
+		}
+	}
+
+	if !chunked {
+		return
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return
+	}
+	trailers := headers.NewHeaders()
+	for key, value := range resp.Trailers {
+		trailers.Set(key, value)
+	}
+	if integrity {
+		trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", hash.Sum(nil)))
+		trailers.Set("X-Content-Length", strconv.Itoa(total))
+	}
+	w.WriteTrailers(trailers)
+}
+
+// stripHopHeaders returns a copy of h without hop-by-hop fields, including
+// any the Connection header names.
+func stripHopHeaders(h headers.Headers) headers.Headers {
+	out := headers.NewHeaders()
+	for key, value := range h {
+		out.Set(key, value)
+	}
+	for _, key := range headers.Tokens(h.Get("connection")) {
+		out.Delete(key)
+	}
+	for _, key := range hopHeaders {
+		out.Delete(key)
+	}
+	return out
+}
+
+// joinPath joins the upstream base path and the request path with exactly
+// one slash between them.
+func joinPath(base, p string) string {
+	if base == "" || base == "/" {
+		if p == "" {
+			return "/"
+		}
+		return p
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
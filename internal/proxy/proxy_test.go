@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method, target string, body string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte(body),
+		RemoteAddr:  "192.0.2.7:5555",
+	}
+	req.Headers.Set("Host", "proxy.example")
+	return req
+}
+
+// serve runs req through p and parses what it wrote.
+func serve(t *testing.T, p *ReverseProxy, req *request.Request) (*http.Response, string) {
+	t.Helper()
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	p.Handle(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestForwardsRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL + "/api")
+	require.NoError(t, err)
+
+	req := newRequest("POST", "/items?x=1", `{"a":1}`)
+	req.Headers.Set("Content-Type", "application/json")
+	req.Headers.Set("Connection", "X-Secret")
+	req.Headers.Set("X-Secret", "hop")
+	req.Headers.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Headers.Set("X-Forwarded-For", "198.51.100.1")
+	resp, body := serve(t, p, req)
+
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "/api/items", got.URL.Path)
+	assert.Equal(t, "x=1", got.URL.RawQuery)
+	assert.Equal(t, `{"a":1}`, gotBody)
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Empty(t, got.Header.Get("X-Secret"))
+	assert.Empty(t, got.Header.Get("Proxy-Authorization"))
+	assert.Equal(t, "198.51.100.1, 192.0.2.7", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "proxy.example", got.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "for=192.0.2.7;host=proxy.example;proto=http", got.Header.Get("Forwarded"))
+	assert.NotEqual(t, "proxy.example", got.Host)
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	assert.Equal(t, "created", body)
+}
+
+func TestForwardsHead(t *testing.T) {
+	var method string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.Header().Set("Content-Length", "11")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithIntegrityTrailers())
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	w.DiscardBody()
+	p.Handle(w, newRequest("HEAD", "/", ""))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, "HEAD", method)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(11), resp.ContentLength)
+	assert.Empty(t, resp.TransferEncoding)
+}
+
+func TestPreserveHost(t *testing.T) {
+	var host string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithPreserveHost())
+	require.NoError(t, err)
+	serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, "proxy.example", host)
+}
+
+func TestStreamsWithTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Upstream-Done")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Upstream-Done", "true")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithIntegrityTrailers())
+	require.NoError(t, err)
+	resp, body := serve(t, p, newRequest("GET", "/stream", ""))
+
+	want := "line 0\nline 1\nline 2\n"
+	assert.Equal(t, want, body)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "true", resp.Trailer.Get("X-Upstream-Done"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(want))), resp.Trailer.Get("X-Content-SHA256"))
+	assert.Equal(t, fmt.Sprint(len(want)), resp.Trailer.Get("X-Content-Length"))
+}
+
+func TestUpstreamFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p, err := New("http://" + addr)
+	require.NoError(t, err)
+	resp, _ := serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	p, err = New(slow.URL, WithTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	resp, _ = serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestNewRejectsBadUpstream(t *testing.T) {
+	_, err := New("httpbin.org")
+	assert.Error(t, err)
+	_, err = New("ftp://example.com")
+	assert.Error(t, err)
+}
+
+func TestAddForwardingHeadersIPv6(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Forwarded", "for=203.0.113.9")
+	addForwardingHeaders(h, &request.Request{RemoteAddr: "[2001:db8::1]:80"}, "a b")
+	assert.Equal(t, `for=203.0.113.9, for="[2001:db8::1]";host="a b";proto=http`, h.Get("Forwarded"))
+}
+
+func TestForwardsTLSScheme(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	req := newRequest("GET", "/", "")
+	req.TLS = true
+	serve(t, p, req)
+
+	require.NotNil(t, got)
+	assert.Equal(t, "https", got.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "for=192.0.2.7;host=proxy.example;proto=https", got.Header.Get("Forwarded"))
+}
+
+func TestRelayKeepsOwnConnectionHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "upstream only")
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	w.Header().Set("Connection", "close")
+	p.Handle(w, newRequest("GET", "/", ""))
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	assert.Empty(t, resp.Header.Get("X-Hop"))
+}
+
+func TestRelaysSetCookieSeparately(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 02 Jan 2030 02:04:05 GMT")
+		w.Header().Add("Set-Cookie", "b=2; HttpOnly")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	resp, _ := serve(t, p, newRequest("GET", "/", ""))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 02:04:05 GMT", "b=2; HttpOnly"}, resp.Header.Values("Set-Cookie"))
+}
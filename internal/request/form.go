@@ -0,0 +1,187 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+)
+
+const (
+	// DefaultMaxParts limits the multipart parts or urlencoded fields in
+	// one form.
+	DefaultMaxParts = 1000
+	// DefaultMaxFieldSize limits each non-file value.
+	DefaultMaxFieldSize = 1 << 20
+)
+
+// ErrNotForm is returned when the request body is neither
+// application/x-www-form-urlencoded nor multipart/form-data.
+var ErrNotForm = errors.New("request body is not a form")
+
+// Form is a parsed form body. Value holds plain fields in the order they
+// were sent; File holds uploads, which exist only in multipart bodies.
+//
+// The server reads every request body into memory before the handler
+// runs, so forms, uploads included, only work for bodies that fit there.
+// Nothing is written to temporary files.
+type Form struct {
+	Value url.Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file, whose content is held in memory.
+type FileHeader struct {
+	Filename string
+	Header   headers.Headers
+	Size     int64
+
+	content []byte
+}
+
+// File is an uploaded file opened for reading.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the uploaded content.
+func (fh *FileHeader) Open() (File, error) {
+	return memoryFile{io.NewSectionReader(bytes.NewReader(fh.content), 0, int64(len(fh.content)))}, nil
+}
+
+type memoryFile struct {
+	*io.SectionReader
+}
+
+func (memoryFile) Close() error { return nil }
+
+type formConfig struct {
+	maxParts     int
+	maxFieldSize int64
+}
+
+// FormOption configures ParseForm.
+type FormOption func(*formConfig)
+
+// WithMaxParts replaces DefaultMaxParts.
+func WithMaxParts(n int) FormOption {
+	return func(c *formConfig) {
+		c.maxParts = n
+	}
+}
+
+// WithMaxFieldSize replaces DefaultMaxFieldSize.
+func WithMaxFieldSize(n int64) FormOption {
+	return func(c *formConfig) {
+		c.maxFieldSize = n
+	}
+}
+
+// ParseForm parses an application/x-www-form-urlencoded or
+// multipart/form-data body. It returns ErrNotForm for any other
+// Content-Type, and ErrTooManyParts or ErrFieldTooLarge when the body
+// breaks a limit.
+func (r *Request) ParseForm(opts ...FormOption) (*Form, error) {
+	cfg := formConfig{
+		maxParts:     DefaultMaxParts,
+		maxFieldSize: DefaultMaxFieldSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("content-type"))
+	if err != nil {
+		return nil, ErrNotForm
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := parseURLEncoded(r.Body, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &Form{Value: values, File: map[string][]*FileHeader{}}, nil
+	case "multipart/form-data":
+		mr, err := r.multipartReader(params, cfg.maxParts)
+		if err != nil {
+			return nil, err
+		}
+		return readMultipartForm(mr, cfg)
+	}
+	return nil, ErrNotForm
+}
+
+func parseURLEncoded(body []byte, cfg formConfig) (url.Values, error) {
+	values := url.Values{}
+	fields := 0
+	for pair := range strings.SplitSeq(string(body), "&") {
+		if pair == "" {
+			continue
+		}
+		fields++
+		if cfg.maxParts > 0 && fields > cfg.maxParts {
+			return nil, ErrTooManyParts
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		if int64(len(rawValue)) > cfg.maxFieldSize {
+			return nil, ErrFieldTooLarge
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("malformed form field %q: %w", rawKey, err)
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value for form field %q: %w", key, err)
+		}
+		values.Add(key, value)
+	}
+	return values, nil
+}
+
+// readMultipartForm reads every part of mr. Fields are read whole, up to
+// the field size limit; files are read whole into memory.
+func readMultipartForm(mr *MultipartReader, cfg formConfig) (*Form, error) {
+	form := &Form{Value: url.Values{}, File: map[string][]*FileHeader{}}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.Copy(&value, io.LimitReader(part, cfg.maxFieldSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if n > cfg.maxFieldSize {
+				return nil, ErrFieldTooLarge
+			}
+			form.Value.Add(name, value.String())
+			continue
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		fh := &FileHeader{Filename: part.FileName(), Header: part.Header, Size: int64(len(content)), content: content}
+		form.File[name] = append(form.File[name], fh)
+	}
+}
@@ -0,0 +1,112 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(contentType, body string) *Request {
+	return &Request{
+		Headers: map[string]string{"content-type": contentType},
+		Body:    []byte(body),
+	}
+}
+
+// multipartRequest builds a multipart/form-data request with one upload per
+// entry in files followed by one field per entry in fields.
+func multipartRequest(t *testing.T, fields, files map[string]string) *Request {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for name, content := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="`+name+`"; filename="`+name+`.bin"`)
+		h.Set("Content-Type", "application/octet-stream")
+		part, err := w.CreatePart(h)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+	}
+	for name, value := range fields {
+		require.NoError(t, w.WriteField(name, value))
+	}
+	require.NoError(t, w.Close())
+	return formRequest(w.FormDataContentType(), buf.String())
+}
+
+func readFile(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	r := formRequest("application/x-www-form-urlencoded; charset=utf-8", "name=caf%C3%A9&tag=a&tag=b+c&empty=&flag")
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "café", form.Value.Get("name"))
+	assert.Equal(t, []string{"a", "b c"}, form.Value["tag"])
+	assert.Equal(t, []string{""}, form.Value["empty"])
+	assert.Equal(t, []string{""}, form.Value["flag"])
+	assert.Empty(t, form.File)
+
+	_, err = formRequest("application/x-www-form-urlencoded", "bad=%zz").ParseForm()
+	assert.Error(t, err)
+}
+
+func TestParseFormMultipart(t *testing.T) {
+	r := multipartRequest(t, map[string]string{"title": "report"}, map[string]string{"small": "tiny", "big": strings.Repeat("x", 2048)})
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "report", form.Value.Get("title"))
+
+	require.Len(t, form.File["small"], 1)
+	small := form.File["small"][0]
+	assert.Equal(t, "small.bin", small.Filename)
+	assert.Equal(t, "application/octet-stream", small.Header.Get("Content-Type"))
+	assert.EqualValues(t, 4, small.Size)
+	assert.Equal(t, "tiny", readFile(t, small))
+
+	require.Len(t, form.File["big"], 1)
+	big := form.File["big"][0]
+	assert.EqualValues(t, 2048, big.Size)
+	assert.Equal(t, strings.Repeat("x", 2048), readFile(t, big))
+}
+
+func TestParseFormLimits(t *testing.T) {
+	_, err := formRequest("application/x-www-form-urlencoded", "a=1&b=2&c=3").ParseForm(WithMaxParts(2))
+	assert.ErrorIs(t, err, ErrTooManyParts)
+	_, err = formRequest("application/x-www-form-urlencoded", "a=12345").ParseForm(WithMaxFieldSize(4))
+	assert.ErrorIs(t, err, ErrFieldTooLarge)
+
+	r := multipartRequest(t, map[string]string{"a": "1", "b": "2", "c": "3"}, nil)
+	_, err = r.ParseForm(WithMaxParts(2))
+	assert.ErrorIs(t, err, ErrTooManyParts)
+
+	r = multipartRequest(t, map[string]string{"a": "12345"}, nil)
+	_, err = r.ParseForm(WithMaxFieldSize(4))
+	assert.ErrorIs(t, err, ErrFieldTooLarge)
+
+	// Files are not fields, so the field size limit does not apply.
+	r = multipartRequest(t, nil, map[string]string{"f": "12345"})
+	form, err := r.ParseForm(WithMaxFieldSize(4))
+	require.NoError(t, err)
+	assert.Equal(t, "12345", readFile(t, form.File["f"][0]))
+}
+
+func TestParseFormNotForm(t *testing.T) {
+	for _, contentType := range []string{"", "application/json", "text/plain; charset=utf-8"} {
+		_, err := formRequest(contentType, "a=1").ParseForm()
+		assert.ErrorIs(t, err, ErrNotForm, contentType)
+	}
+}
@@ -0,0 +1,261 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+)
+
+const (
+	// maxPartHeaderSize bounds the header section of one part.
+	maxPartHeaderSize = 16 << 10
+	// multipartBufferSize must leave room for a delimiter, whose boundary
+	// is at most 70 bytes (RFC 2046 section 5.1.1).
+	multipartBufferSize = 8 << 10
+)
+
+var (
+	// ErrTooManyParts is returned once a form has more parts or fields
+	// than allowed.
+	ErrTooManyParts = errors.New("form has too many parts")
+	// ErrFieldTooLarge is returned for a form value over the size limit.
+	ErrFieldTooLarge = errors.New("form field too large")
+
+	errMissingBoundary = errors.New("multipart body has no boundary")
+)
+
+// MultipartReader reads the parts of a multipart/form-data body one at a
+// time, from any io.Reader. Request.MultipartReader reads from the body the
+// server has already read into memory.
+type MultipartReader struct {
+	br *bufio.Reader
+	// delim ends a part's content: CRLF, two dashes and the boundary.
+	delim    []byte
+	maxParts int
+
+	parts   int
+	current *Part
+	done    bool
+}
+
+// NewMultipartReader reads the multipart body in r, whose parts are
+// separated by boundary. After maxParts parts NextPart fails with
+// ErrTooManyParts; zero means no limit.
+func NewMultipartReader(r io.Reader, boundary string, maxParts int) *MultipartReader {
+	return &MultipartReader{
+		br:       bufio.NewReaderSize(r, multipartBufferSize),
+		delim:    []byte("\r\n--" + boundary),
+		maxParts: maxParts,
+	}
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// request body. It does not copy the body, which RequestFromReader has
+// already read in full.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("content-type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotForm
+	}
+	return r.multipartReader(params, DefaultMaxParts)
+}
+
+func (r *Request) multipartReader(params map[string]string, maxParts int) (*MultipartReader, error) {
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, errMissingBoundary
+	}
+	return NewMultipartReader(bytes.NewReader(r.Body), boundary, maxParts), nil
+}
+
+// NextPart returns the next part, skipping whatever was left unread of the
+// previous one. It returns io.EOF after the last part.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	var last bool
+	var err error
+	if mr.current == nil {
+		last, err = mr.skipPreamble()
+	} else {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		mr.current = nil
+		if _, err := mr.br.Discard(len(mr.delim)); err != nil {
+			return nil, err
+		}
+		line, readErr := mr.br.ReadSlice('\n')
+		last, err = boundaryEnd(line, readErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if last {
+		mr.done = true
+		return nil, io.EOF
+	}
+
+	mr.parts++
+	if mr.maxParts > 0 && mr.parts > mr.maxParts {
+		return nil, ErrTooManyParts
+	}
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	mr.current = newPart(mr, h)
+	return mr.current, nil
+}
+
+// skipPreamble reads through the line holding the first boundary.
+func (mr *MultipartReader) skipPreamble() (last bool, err error) {
+	dashBoundary := mr.delim[2:]
+	midLine := false
+	for {
+		line, err := mr.br.ReadSlice('\n')
+		if !midLine && bytes.HasPrefix(line, dashBoundary) {
+			if last, err := boundaryEnd(line[len(dashBoundary):], err); err == nil {
+				return last, nil
+			}
+		}
+		switch err {
+		case nil:
+			midLine = false
+		case bufio.ErrBufferFull:
+			midLine = true
+		case io.EOF:
+			return false, fmt.Errorf("multipart: no opening boundary: %w", io.ErrUnexpectedEOF)
+		default:
+			return false, err
+		}
+	}
+}
+
+// boundaryEnd checks what follows a boundary on its line: "--" after the
+// last one, otherwise only transport padding and the line break.
+func boundaryEnd(rest []byte, err error) (last bool, _ error) {
+	rest = bytes.TrimRight(rest, " \t\r\n")
+	switch {
+	case bytes.Equal(rest, []byte("--")):
+		// The close delimiter may end the body without a line break.
+		if err == nil || err == io.EOF {
+			return true, nil
+		}
+	case len(rest) == 0 && err == nil:
+		return false, nil
+	}
+	if err == io.EOF {
+		return false, fmt.Errorf("multipart: reading boundary: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil && err != bufio.ErrBufferFull {
+		return false, fmt.Errorf("multipart: reading boundary: %w", err)
+	}
+	return false, errors.New("multipart: malformed boundary line")
+}
+
+// readPartHeaders reads a part's header section, through the empty line.
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	var raw []byte
+	for {
+		line, err := mr.br.ReadSlice('\n')
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("multipart: reading part header: %w", err)
+		}
+		raw = append(raw, line...)
+		if len(raw) > maxPartHeaderSize {
+			return nil, errors.New("multipart: part header too large")
+		}
+		if bytes.Equal(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	h := headers.NewHeaders()
+	if _, done, err := h.Parse(raw); err != nil || !done {
+		if err == nil {
+			err = errors.New("bare LF in header")
+		}
+		return nil, fmt.Errorf("multipart: malformed part header: %w", err)
+	}
+	return h, nil
+}
+
+// Part is one part of a multipart body. Reading it yields the part's
+// content; it is only valid until the next call to NextPart.
+type Part struct {
+	Header headers.Headers
+
+	mr        *MultipartReader
+	formName  string
+	fileName  string
+	remaining bool
+}
+
+func newPart(mr *MultipartReader, h headers.Headers) *Part {
+	p := &Part{Header: h, mr: mr, remaining: true}
+	if _, params, err := mime.ParseMediaType(h.Get("content-disposition")); err == nil {
+		p.formName = params["name"]
+		if name := params["filename"]; name != "" {
+			// Only the last element: clients must not pick where the
+			// file ends up.
+			p.fileName = filepath.Base(filepath.Clean("/" + filepath.FromSlash(name)))
+		}
+	}
+	return p
+}
+
+// FormName returns the name parameter of the part's Content-Disposition,
+// or "" if there is none.
+func (p *Part) FormName() string {
+	return p.formName
+}
+
+// FileName returns the base name of the uploaded file, or "" for plain
+// form fields.
+func (p *Part) FileName() string {
+	return p.fileName
+}
+
+// Read reads the part's content, stopping at the delimiter that ends it.
+func (p *Part) Read(b []byte) (int, error) {
+	if !p.remaining {
+		return 0, io.EOF
+	}
+	br := p.mr.br
+	peek, err := br.Peek(multipartBufferSize)
+	if i := bytes.Index(peek, p.mr.delim); i >= 0 {
+		n := copy(b, peek[:i])
+		br.Discard(n)
+		if n == i {
+			p.remaining = false
+			if n == 0 {
+				return 0, io.EOF
+			}
+		}
+		return n, nil
+	}
+	if err != nil && err != bufio.ErrBufferFull {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, fmt.Errorf("multipart: part not terminated: %w", err)
+	}
+
+	// Hold back a tail that might be the start of the delimiter.
+	safe := len(peek) - len(p.mr.delim) + 1
+	n := copy(b, peek[:safe])
+	br.Discard(n)
+	return n, nil
+}
@@ -0,0 +1,122 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartReader(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 2000)
+	body := "preamble to ignore\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"hello\r\n" +
+		"--xyz  \r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"../../etc/notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		large + "\r\n" +
+		"--xyz--\r\n" +
+		"epilogue"
+
+	// One byte per read keeps delimiters split across reads.
+	mr := NewMultipartReader(&chunkReader{data: body, numBytesPerRead: 1}, "xyz", 0)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Empty(t, part.FileName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName())
+	assert.Equal(t, "notes.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(data))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultipartReaderSkipsUnreadParts(t *testing.T) {
+	body := "--b\r\n\r\nfirst\r\n--b\r\nX-Part: 2\r\n\r\nsecond\r\n--b--"
+	mr := NewMultipartReader(strings.NewReader(body), "b", 0)
+
+	_, err := mr.NextPart()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "2", part.Header.Get("X-Part"))
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultipartReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"no boundary":         "just text\r\n",
+		"unterminated part":   "--b\r\n\r\nnever ends",
+		"garbage after part":  "--b\r\n\r\nvalue\r\n--bogus\r\n",
+		"bad part header":     "--b\r\nno colon\r\n\r\nvalue\r\n--b--\r\n",
+		"unterminated header": "--b\r\nX-A: 1\r\n",
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			mr := NewMultipartReader(strings.NewReader(body), "b", 0)
+			var err error
+			for err == nil {
+				var part *Part
+				if part, err = mr.NextPart(); err == nil {
+					_, err = io.ReadAll(part)
+				}
+			}
+			assert.NotEqual(t, io.EOF, err)
+		})
+	}
+}
+
+func TestMultipartReaderMaxParts(t *testing.T) {
+	body := strings.Repeat("--b\r\n\r\nx\r\n", 3) + "--b--\r\n"
+	mr := NewMultipartReader(strings.NewReader(body), "b", 2)
+	for range 2 {
+		_, err := mr.NextPart()
+		require.NoError(t, err)
+	}
+	_, err := mr.NextPart()
+	assert.ErrorIs(t, err, ErrTooManyParts)
+}
+
+func TestRequestMultipartReader(t *testing.T) {
+	r := &Request{Headers: map[string]string{"content-type": `multipart/form-data; boundary="a b"`}}
+	r.Body = []byte("--a b\r\nContent-Disposition: form-data; name=n\r\n\r\nv\r\n--a b--\r\n")
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	data, _ := io.ReadAll(part)
+	assert.Equal(t, "n", part.FormName())
+	assert.True(t, bytes.Equal([]byte("v"), data))
+
+	r.Headers["content-type"] = "multipart/form-data"
+	_, err = r.MultipartReader()
+	assert.Error(t, err)
+
+	r.Headers["content-type"] = "application/json"
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotForm)
+}
@@ -1,6 +1,7 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/RayanMalki/tcptohttp/internal/cookie"
 	"github.com/RayanMalki/tcptohttp/internal/headers"
 )
 
@@ -19,7 +21,11 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       int
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// TLS reports whether the request arrived over TLS, set by the server.
+	TLS   bool
+	state int
 }
 
 // RequestLine holds the three components of the HTTP request line
@@ -29,6 +35,28 @@ type RequestLine struct {
 	Method        string
 }
 
+// ParseError is returned by RequestFromReader when a request cannot be parsed.
+// Request holds whatever was parsed before the failure (possibly only part of
+// the headers), so callers can still look at fields such as Accept when
+// building the error response.
+type ParseError struct {
+	Request *Request
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Cookies parses the request's Cookie header.
+func (r *Request) Cookies() cookie.Cookies {
+	return cookie.Parse(r.Headers.Get("cookie"))
+}
+
 // Enum (int) for parser state
 const (
 	requestStateStart = iota
@@ -49,6 +77,11 @@ func parseRequestLine(data []byte) (RequestLine, int, error) {
 	}
 
 	line := string(data[:index])
+	if line == "PRI * HTTP/2.0" {
+		// The start of the HTTP/2 connection preface. It parses as a
+		// request with no headers; the server decides what to do with it.
+		return RequestLine{Method: "PRI", RequestTarget: "*", HttpVersion: "2.0"}, index + 2, nil
+	}
 	parts := strings.Split(line, " ")
 
 	if len(parts) != 3 {
@@ -60,7 +93,7 @@ func parseRequestLine(data []byte) (RequestLine, int, error) {
 	version := parts[2]
 
 	// Validate method: must be uppercase letters only
-	validMethods := []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS"}
+	validMethods := []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "CONNECT"}
 	isValid := false
 	for _, m := range validMethods {
 		if method == m {
@@ -178,9 +211,14 @@ func (r *Request) Parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
-// RequestFromReader reads from a stream (io.Reader) and builds a Request struct
+// RequestFromReader reads from a stream (io.Reader) and builds a Request struct.
+// Given a *bufio.Reader it consumes exactly the bytes of the request, so
+// whatever the client sent after it stays buffered there.
 func RequestFromReader(reader io.Reader) (*Request, error) {
 	r := &Request{state: requestStateParsingRequestLine, Headers: headers.NewHeaders()}
+	if br, ok := reader.(*bufio.Reader); ok {
+		return r.readBuffered(br)
+	}
 	buffer := make([]byte, 0, 8)
 	tmp := make([]byte, 8)
 
@@ -191,7 +229,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 			consumed, parseErr := r.Parse(buffer)
 			if parseErr != nil {
-				return nil, parseErr
+				return nil, &ParseError{Request: r, Err: parseErr}
 			}
 
 			if r.state == requestStateDone {
@@ -209,13 +247,41 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		}
 
 		if err != nil {
-			return nil, err
+			return nil, &ParseError{Request: r, Err: err}
 		}
 	}
 
 	if r.state != requestStateDone {
-		return nil, errors.New("incomplete request")
+		return nil, &ParseError{Request: r, Err: errors.New("incomplete request")}
 	}
 
 	return r, nil
 }
+
+// readBuffered parses straight out of br's buffer, discarding only what the
+// parser consumed.
+func (r *Request) readBuffered(br *bufio.Reader) (*Request, error) {
+	// pending counts buffered bytes the parser has seen but could not use
+	// yet; more input is only waited for once all of them are pending.
+	pending := 0
+	for r.state != requestStateDone {
+		if _, err := br.Peek(pending + 1); err != nil {
+			switch err {
+			case bufio.ErrBufferFull:
+				err = errors.New("request line or header too long")
+			case io.EOF:
+				err = errors.New("incomplete request")
+			}
+			return nil, &ParseError{Request: r, Err: err}
+		}
+
+		data, _ := br.Peek(br.Buffered())
+		consumed, parseErr := r.Parse(data)
+		if parseErr != nil {
+			return nil, &ParseError{Request: r, Err: parseErr}
+		}
+		br.Discard(consumed)
+		pending = len(data) - consumed
+	}
+	return r, nil
+}
@@ -1,6 +1,7 @@
 package request
 
 import (
+	"bufio"
 	"io"
 	"strings"
 	"testing"
@@ -82,6 +83,21 @@ func TestInvalidVersion(t *testing.T) {
 	_, err := RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestHTTP2Preface(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+	r, err := RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "PRI", r.RequestLine.Method)
+	assert.Equal(t, "2.0", r.RequestLine.HttpVersion)
+
+	// The rest of the preface is left for the HTTP/2 server.
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "SM\r\n\r\n", string(rest))
+
+	_, err = RequestFromReader(strings.NewReader("PRI / HTTP/2.0\r\n\r\n"))
+	require.Error(t, err)
+}
 func TestRequest_ParseHeaders(t *testing.T) {
 
 	reader := &chunkReader{
@@ -172,3 +188,48 @@ func TestRequest_ParseBody(t *testing.T) {
 	assert.Equal(t, "", string(r.Body))
 
 }
+
+func TestParseErrorKeepsPartialHeaders(t *testing.T) {
+	reader := strings.NewReader("GET / HTTP/1.1\r\nAccept: application/json\r\nBad Header\r\n\r\n")
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.NotNil(t, parseErr.Request)
+	assert.Equal(t, "GET", parseErr.Request.RequestLine.Method)
+	assert.Equal(t, "application/json", parseErr.Request.Headers.Get("Accept"))
+}
+
+func TestBufferedReaderKeepsFollowingBytes(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"helloNEXT BYTES"
+	br := bufio.NewReaderSize(&chunkReader{data: data, numBytesPerRead: 3}, 32)
+	r, err := RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "NEXT BYTES", string(rest))
+
+	// A header line that cannot fit in the buffer is an error, not a hang.
+	br = bufio.NewReaderSize(strings.NewReader("GET / HTTP/1.1\r\nX-Long: "+strings.Repeat("a", 64)+"\r\n\r\n"), 32)
+	_, err = RequestFromReader(br)
+	require.Error(t, err)
+
+	br = bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n"))
+	_, err = RequestFromReader(br)
+	require.Error(t, err)
+}
+
+func TestRequestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1; b=2\r\nCookie: a=3\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	assert.Equal(t, []string{"1", "3"}, cookies.Values("a"))
+	assert.Equal(t, "2", cookies.Get("b"))
+}
@@ -0,0 +1,190 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+)
+
+// Validator describes the current representation of a resource for
+// conditional requests. Either field may be left empty.
+type Validator struct {
+	ETag         string    // quoted entity tag, with W/ prefix if weak
+	LastModified time.Time // zero if unknown
+}
+
+// StrongETag returns a strong entity tag derived from content.
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns a weak entity tag derived from a modification time and
+// size. It is enough for If-None-Match revalidation, but weak tags never
+// satisfy If-Match or If-Range.
+func WeakETag(modTime time.Time, size int64) string {
+	return `W/"` + strconv.FormatInt(modTime.Unix(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
+}
+
+// FileETag returns a strong entity tag derived from a file's modification
+// time and size, which is cheap to compute. It is strong so that range
+// requests can be resumed with If-Range; a file rewritten in place within
+// the same nanosecond at the same size would go unnoticed.
+func FileETag(modTime time.Time, size int64) string {
+	return `"` + strconv.FormatInt(modTime.UnixNano(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
+}
+
+// LastModified formats t for the Last-Modified header.
+func LastModified(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// CheckPreconditions sets the validator headers on w and evaluates the
+// request's If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since headers in RFC 9110 order. If a precondition decides
+// the response it writes a 304 or 412 and returns true; the handler should
+// then return without writing a body.
+func CheckPreconditions(w *Writer, method string, h headers.Headers, v Validator) bool {
+	if v.ETag != "" {
+		w.Header().Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", LastModified(v.LastModified))
+	}
+
+	status := EvaluatePreconditions(method, h, v)
+	if status == StatusOK {
+		return false
+	}
+	if status == StatusNotModified {
+		// A 304 describes the cached representation; content headers set
+		// so far would describe a body that is not sent.
+		w.Header().Delete("Content-Length")
+		w.Header().Delete("Content-Type")
+	}
+	w.WriteStatusLine(status)
+	return true
+}
+
+// EvaluatePreconditions returns StatusNotModified or StatusPreconditionFailed
+// if the request's conditional headers decide the response, and StatusOK if
+// the request should be served normally.
+func EvaluatePreconditions(method string, h headers.Headers, v Validator) StatusCode {
+	safe := method == "GET" || method == "HEAD"
+	lastModified := v.LastModified.Truncate(time.Second)
+
+	if ifMatch := h.Get("if-match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, v.ETag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(h.Get("if-unmodified-since")); ok && !v.LastModified.IsZero() {
+		if lastModified.After(since) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := h.Get("if-none-match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, v.ETag, false) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(h.Get("if-modified-since")); ok && safe && !v.LastModified.IsZero() {
+		if !lastModified.After(since) {
+			return StatusNotModified
+		}
+	}
+
+	return StatusOK
+}
+
+// IfRangeMatches reports whether a Range request may be honored given its
+// If-Range header. An entity tag must match strongly; a date must equal the
+// Last-Modified time exactly.
+func IfRangeMatches(ifRange string, v Validator) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagsMatch(ifRange, v.ETag, true)
+	}
+	t, ok := parseHTTPDate(ifRange)
+	return ok && !v.LastModified.IsZero() && v.LastModified.Truncate(time.Second).Equal(t)
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(TimeFormat, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// etagListMatches reports whether etag is in list, a comma-separated
+// If-Match or If-None-Match value. "*" matches any current representation,
+// and a handler with a Validator always has one.
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range splitETags(list) {
+		if etagsMatch(candidate, etag, strong) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagsMatch compares two entity tags. The strong comparison requires both
+// to be strong; the weak one ignores the W/ prefix.
+func etagsMatch(a, b string, strong bool) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	weakA := strings.HasPrefix(a, "W/")
+	weakB := strings.HasPrefix(b, "W/")
+	if strong && (weakA || weakB) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// splitETags splits a list of entity tags, leaving commas inside quotes alone.
+func splitETags(list string) []string {
+	var tags []string
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return tags
+		}
+
+		start := 0
+		if strings.HasPrefix(list, "W/") {
+			start = 2
+		}
+		if len(list) <= start || list[start] != '"' {
+			// Not an entity tag; skip to the next element.
+			if i := strings.IndexByte(list, ','); i >= 0 {
+				list = list[i+1:]
+				continue
+			}
+			return tags
+		}
+		end := strings.IndexByte(list[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, list[:end])
+		list = list[end:]
+	}
+}
@@ -0,0 +1,95 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	modTime = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	strong  = Validator{ETag: `"abc"`, LastModified: modTime}
+)
+
+func conditionalHeaders(pairs ...string) headers.Headers {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestEvaluateIfNoneMatch(t *testing.T) {
+	assert.Equal(t, StatusNotModified, EvaluatePreconditions("GET", conditionalHeaders("If-None-Match", `"x", "abc"`), strong))
+	assert.Equal(t, StatusNotModified, EvaluatePreconditions("GET", conditionalHeaders("If-None-Match", `W/"abc"`), strong))
+	assert.Equal(t, StatusOK, EvaluatePreconditions("GET", conditionalHeaders("If-None-Match", `"other"`), strong))
+	assert.Equal(t, StatusPreconditionFailed, EvaluatePreconditions("PUT", conditionalHeaders("If-None-Match", "*"), strong))
+}
+
+func TestEvaluateIfMatch(t *testing.T) {
+	assert.Equal(t, StatusOK, EvaluatePreconditions("PUT", conditionalHeaders("If-Match", `"abc"`), strong))
+	assert.Equal(t, StatusPreconditionFailed, EvaluatePreconditions("PUT", conditionalHeaders("If-Match", `W/"abc"`), strong))
+	assert.Equal(t, StatusPreconditionFailed, EvaluatePreconditions("PUT", conditionalHeaders("If-Match", `"old"`), strong))
+}
+
+func TestEvaluateDates(t *testing.T) {
+	before := modTime.Add(-time.Hour).Format(TimeFormat)
+	after := modTime.Add(time.Hour).Format(TimeFormat)
+
+	assert.Equal(t, StatusNotModified, EvaluatePreconditions("GET", conditionalHeaders("If-Modified-Since", after), strong))
+	assert.Equal(t, StatusNotModified, EvaluatePreconditions("GET", conditionalHeaders("If-Modified-Since", modTime.Format(TimeFormat)), strong))
+	assert.Equal(t, StatusOK, EvaluatePreconditions("GET", conditionalHeaders("If-Modified-Since", before), strong))
+	assert.Equal(t, StatusPreconditionFailed, EvaluatePreconditions("PUT", conditionalHeaders("If-Unmodified-Since", before), strong))
+	assert.Equal(t, StatusOK, EvaluatePreconditions("POST", conditionalHeaders("If-Modified-Since", after), strong))
+}
+
+func TestEvaluatePrecedence(t *testing.T) {
+	// If-None-Match wins over If-Modified-Since.
+	h := conditionalHeaders("If-None-Match", `"other"`, "If-Modified-Since", modTime.Add(time.Hour).Format(TimeFormat))
+	assert.Equal(t, StatusOK, EvaluatePreconditions("GET", h, strong))
+
+	// If-Match wins over If-Unmodified-Since.
+	h = conditionalHeaders("If-Match", `"abc"`, "If-Unmodified-Since", modTime.Add(-time.Hour).Format(TimeFormat))
+	assert.Equal(t, StatusOK, EvaluatePreconditions("PUT", h, strong))
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	assert.True(t, IfRangeMatches("", strong))
+	assert.True(t, IfRangeMatches(`"abc"`, strong))
+	assert.False(t, IfRangeMatches(`W/"abc"`, strong))
+	assert.True(t, IfRangeMatches(modTime.Format(TimeFormat), strong))
+	assert.False(t, IfRangeMatches(modTime.Add(time.Second).Format(TimeFormat), strong))
+}
+
+func TestCheckPreconditionsWritesNotModified(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	body := []byte("dynamic content")
+	v := Validator{ETag: StrongETag(body)}
+
+	w.Header().Set("Content-Type", "text/plain")
+	done := CheckPreconditions(w, "GET", conditionalHeaders("If-None-Match", v.ETag), v)
+	require.True(t, done)
+	require.NoError(t, w.Finish())
+
+	got := out.String()
+	assert.True(t, strings.HasPrefix(got, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, got, "etag: "+v.ETag+"\r\n")
+	assert.NotContains(t, got, "content-length")
+	assert.NotContains(t, got, "content-type")
+}
+
+func TestETagHelpers(t *testing.T) {
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	assert.Equal(t, `"1816c11eeef33200-b"`, FileETag(modTime, 11))
+	assert.Equal(t, `W/"67760225-b"`, WeakETag(modTime, 11))
+	assert.False(t, etagsMatch(WeakETag(modTime, 11), WeakETag(modTime, 11), true))
+	assert.True(t, etagsMatch(WeakETag(modTime, 11), WeakETag(modTime, 11), false))
+	assert.Equal(t, []string{`"a,b"`, `W/"c"`}, splitETags(`"a,b", W/"c"`))
+}
@@ -0,0 +1,516 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+)
+
+// Response is an HTTP response read by ResponseFromReader.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	// SetCookies holds one value per Set-Cookie field, which Headers
+	// cannot combine.
+	SetCookies []string
+	Body       []byte
+	Trailers   headers.Headers
+
+	method      string
+	state       int
+	left        int64 // body or chunk bytes still to come
+	headerBytes int   // status line and header bytes seen so far
+}
+
+// Enum (int) for parser state
+const (
+	responseStateParsingStatusLine = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateParsingUntilClose
+	responseStateParsingChunkSize
+	responseStateParsingChunk
+	responseStateParsingChunkEnd
+	responseStateParsingTrailers
+	responseStateDone
+)
+
+// StatusLine holds the three components of the HTTP status line.
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// MaxHeaderBytes caps the status line plus header block of a response, and
+// the trailer block of a chunked body.
+const MaxHeaderBytes = 1 << 20
+
+// ErrHeaderTooLarge is returned for a header block over MaxHeaderBytes.
+var ErrHeaderTooLarge = errors.New("response header too large")
+
+// ParseStatusLine parses a status line such as "HTTP/1.1 200 OK", without
+// its CRLF. The reason phrase may be empty.
+func ParseStatusLine(line string) (StatusLine, error) {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return StatusLine{}, errors.New("invalid status line: must contain version and status code")
+	}
+
+	versionNumber, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok {
+		return StatusLine{}, fmt.Errorf("invalid version format: %s", version)
+	}
+	if versionNumber != "1.1" && versionNumber != "1.0" {
+		return StatusLine{}, fmt.Errorf("unsupported HTTP version: %s", versionNumber)
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	n, err := strconv.Atoi(code)
+	if len(code) != 3 || err != nil || n < 100 {
+		return StatusLine{}, fmt.Errorf("invalid status code: %s", code)
+	}
+
+	return StatusLine{
+		HttpVersion:  versionNumber,
+		StatusCode:   StatusCode(n),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// ReadStatusLine reads and parses the status line at the start of br.
+func ReadStatusLine(br *bufio.Reader) (StatusLine, error) {
+	line, err := readLine(br, MaxHeaderBytes)
+	if err != nil {
+		return StatusLine{}, err
+	}
+	return ParseStatusLine(line)
+}
+
+// ReadHeaderBlock reads field lines up to and including the empty line and
+// parses them into h with the same parser the server uses for requests.
+// Set-Cookie fields cannot be combined, so their values are returned one
+// per field instead.
+func ReadHeaderBlock(br *bufio.Reader, h headers.Headers) (setCookies []string, _ error) {
+	var block bytes.Buffer
+	read := 0
+	for {
+		line, err := readLine(br, MaxHeaderBytes-read)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		read += len(line) + 2
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "set-cookie") {
+			setCookies = append(setCookies, strings.TrimSpace(value))
+			continue
+		}
+		block.WriteString(line)
+		block.WriteString("\r\n")
+		if line == "" {
+			break
+		}
+	}
+
+	_, done, err := h.Parse(block.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		return nil, errors.New("malformed header block")
+	}
+	return setCookies, nil
+}
+
+// readLine reads one CRLF-terminated line of at most limit bytes and
+// returns it without the line ending.
+func readLine(br *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", ErrHeaderTooLarge
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// BodyFraming is how the end of a response body is found.
+type BodyFraming int
+
+const (
+	FramingNone BodyFraming = iota // no body
+	FramingLength
+	FramingChunked
+	FramingClose // body runs until the server closes the connection
+)
+
+// Framing decides how the body of a response to a request with the given
+// method is framed (RFC 9112 section 6.3). It also returns the
+// Content-Length, or -1 if there is none; for HEAD and other bodyless
+// responses that describes the body a GET would have returned.
+func Framing(method string, status StatusCode, h headers.Headers) (BodyFraming, int64, error) {
+	switch {
+	case method == "HEAD", !bodyAllowed(status):
+		if n, err := strconv.ParseInt(h.Get("content-length"), 10, 64); err == nil {
+			return FramingNone, n, nil
+		}
+		return FramingNone, -1, nil
+	}
+
+	if te := h.Get("transfer-encoding"); te != "" {
+		codings := strings.Split(te, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return FramingChunked, -1, nil
+		}
+		return FramingClose, -1, nil
+	}
+
+	if cl := h.Get("content-length"); cl != "" {
+		// Repeated fields were merged with commas; they must all agree.
+		values := strings.Split(cl, ",")
+		n, err := strconv.ParseInt(strings.TrimSpace(values[0]), 10, 64)
+		if err != nil || n < 0 {
+			return FramingNone, -1, fmt.Errorf("invalid Content-Length: %q", cl)
+		}
+		for _, v := range values[1:] {
+			if strings.TrimSpace(v) != strings.TrimSpace(values[0]) {
+				return FramingNone, -1, fmt.Errorf("conflicting Content-Length: %q", cl)
+			}
+		}
+		return FramingLength, n, nil
+	}
+	return FramingClose, -1, nil
+}
+
+// ChunkedReader decodes a chunked body and reads the trailers into
+// trailers when it reaches the last chunk.
+type ChunkedReader struct {
+	br       *bufio.Reader
+	trailers headers.Headers
+	left     int64 // bytes left in the current chunk
+	done     bool
+	err      error
+}
+
+// NewChunkedReader decodes the chunked body at the start of br. The
+// trailers are parsed into trailers.
+func NewChunkedReader(br *bufio.Reader, trailers headers.Headers) *ChunkedReader {
+	return &ChunkedReader{br: br, trailers: trailers}
+}
+
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.done {
+		return 0, io.EOF
+	}
+
+	if r.left == 0 {
+		size, err := r.readChunkSize()
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		if size == 0 {
+			// Set-Cookie is not allowed in trailers and is dropped.
+			if _, err := ReadHeaderBlock(r.br, r.trailers); err != nil {
+				r.err = err
+				return 0, err
+			}
+			r.done = true
+			return 0, io.EOF
+		}
+		r.left = size
+	}
+
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.br.Read(p)
+	r.left -= int64(n)
+	if r.left == 0 && err == nil {
+		err = r.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *ChunkedReader) readChunkSize() (int64, error) {
+	line, err := readLine(r.br, 4096)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Chunk extensions are ignored.
+	sizeStr, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	return size, nil
+}
+
+func (r *ChunkedReader) readCRLF() error {
+	line, err := readLine(r.br, 0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errors.New("missing CRLF after chunk data")
+	}
+	return nil
+}
+
+var crlf = []byte("\r\n")
+
+// maxChunkSizeLine bounds a chunk size line, extensions included.
+const maxChunkSizeLine = 4096
+
+// nextLine returns the line at the start of data without its CRLF, or
+// ok=false if it is not complete yet. A partial line already over limit
+// is an error.
+func nextLine(data []byte, limit int) (line []byte, ok bool, err error) {
+	idx := bytes.Index(data, crlf)
+	if idx == -1 {
+		if len(data) > limit {
+			return nil, false, ErrHeaderTooLarge
+		}
+		return nil, false, nil
+	}
+	if idx > limit {
+		return nil, false, ErrHeaderTooLarge
+	}
+	return data[:idx], true, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateParsingStatusLine:
+		line, ok, err := nextLine(data, MaxHeaderBytes)
+		if err != nil || !ok {
+			return 0, err
+		}
+		statusLine, err := ParseStatusLine(string(line))
+		if err != nil {
+			return 0, err
+		}
+		r.StatusLine = statusLine
+		r.headerBytes = len(line) + 2
+		r.state = responseStateParsingHeaders
+		return len(line) + 2, nil
+
+	case responseStateParsingHeaders:
+		line, ok, err := nextLine(data, MaxHeaderBytes-r.headerBytes)
+		if err != nil || !ok {
+			return 0, err
+		}
+		n := len(line) + 2
+		r.headerBytes += n
+		if len(line) == 0 {
+			return n, r.endHeaders()
+		}
+		if name, value, ok := bytes.Cut(line, []byte(":")); ok && strings.EqualFold(string(name), "set-cookie") {
+			// Set-Cookie fields cannot be combined into Headers.
+			r.SetCookies = append(r.SetCookies, strings.TrimSpace(string(value)))
+			return n, nil
+		}
+		if _, _, err := r.Headers.Parse(data[:n]); err != nil {
+			return 0, err
+		}
+		return n, nil
+
+	case responseStateParsingBody, responseStateParsingChunk:
+		toRead := min(int64(len(data)), r.left)
+		r.Body = append(r.Body, data[:toRead]...)
+		r.left -= toRead
+		if r.left == 0 {
+			if r.state == responseStateParsingBody {
+				r.state = responseStateDone
+			} else {
+				r.state = responseStateParsingChunkEnd
+			}
+		}
+		return int(toRead), nil
+
+	case responseStateParsingUntilClose:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+
+	case responseStateParsingChunkSize:
+		line, ok, err := nextLine(data, maxChunkSizeLine)
+		if err != nil || !ok {
+			return 0, err
+		}
+		// Chunk extensions are ignored.
+		sizeStr, _, _ := strings.Cut(string(line), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		if size == 0 {
+			r.headerBytes = 0
+			r.state = responseStateParsingTrailers
+		} else {
+			r.left = size
+			r.state = responseStateParsingChunk
+		}
+		return len(line) + 2, nil
+
+	case responseStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, crlf) {
+			return 0, errors.New("missing CRLF after chunk data")
+		}
+		r.state = responseStateParsingChunkSize
+		return 2, nil
+
+	case responseStateParsingTrailers:
+		line, ok, err := nextLine(data, MaxHeaderBytes-r.headerBytes)
+		if err != nil || !ok {
+			return 0, err
+		}
+		n := len(line) + 2
+		r.headerBytes += n
+		if len(line) == 0 {
+			r.state = responseStateDone
+			return n, nil
+		}
+		if name, _, ok := bytes.Cut(line, []byte(":")); ok && strings.EqualFold(string(name), "set-cookie") {
+			// Set-Cookie is not allowed in trailers and is dropped.
+			return n, nil
+		}
+		if _, _, err := r.Trailers.Parse(data[:n]); err != nil {
+			return 0, err
+		}
+		return n, nil
+
+	default:
+		return 0, fmt.Errorf("invalid parser state: %v", r.state)
+	}
+}
+
+// endHeaders picks the state after the header block: the next status
+// line after an interim response, otherwise the body as framed.
+func (r *Response) endHeaders() error {
+	status := r.StatusLine.StatusCode
+	if status >= 100 && status < 200 && status != StatusSwitchingProtocols {
+		// An interim response; the final one follows.
+		r.Headers = headers.NewHeaders()
+		r.SetCookies = nil
+		r.state = responseStateParsingStatusLine
+		return nil
+	}
+
+	framing, length, err := Framing(r.method, status, r.Headers)
+	if err != nil {
+		return err
+	}
+	switch {
+	case framing == FramingNone, framing == FramingLength && length == 0:
+		r.state = responseStateDone
+	case framing == FramingLength:
+		r.left = length
+		r.state = responseStateParsingBody
+	case framing == FramingChunked:
+		r.state = responseStateParsingChunkSize
+	default:
+		r.state = responseStateParsingUntilClose
+	}
+	return nil
+}
+
+// Parse processes chunks of bytes and updates the response state. It
+// returns how many bytes were consumed.
+func (r *Response) Parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+
+	for r.state != responseStateDone {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return totalBytesParsed, err
+		}
+		if n == 0 {
+			break
+		}
+
+		totalBytesParsed += n
+	}
+
+	return totalBytesParsed, nil
+}
+
+// ResponseFromReader reads from a stream (io.Reader) and builds a Response.
+// method is the method of the request being answered; HEAD responses
+// never have a body, and "" is treated as GET. Interim 1xx responses are
+// skipped. A body without Content-Length or chunked encoding runs until
+// the reader returns io.EOF.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	r := &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		method:   method,
+		state:    responseStateParsingStatusLine,
+	}
+	var buffer []byte
+	tmp := make([]byte, bufferSize)
+
+	for r.state != responseStateDone {
+		n, err := reader.Read(tmp)
+		if n > 0 {
+			buffer = append(buffer, tmp[:n]...)
+
+			consumed, parseErr := r.Parse(buffer)
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			// Remove parsed data from buffer
+			buffer = buffer[consumed:]
+		}
+
+		if err == io.EOF {
+			if r.state == responseStateParsingUntilClose {
+				r.state = responseStateDone
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.state != responseStateDone {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return r, nil
+}
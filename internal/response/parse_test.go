@@ -0,0 +1,185 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkReader simulates reading a variable number of bytes per chunk
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
+
+func TestParseStatusLine(t *testing.T) {
+	sl, err := ParseStatusLine("HTTP/1.1 404 Not Found")
+	require.NoError(t, err)
+	assert.Equal(t, StatusLine{HttpVersion: "1.1", StatusCode: StatusNotFound, ReasonPhrase: "Not Found"}, sl)
+
+	sl, err = ParseStatusLine("HTTP/1.0 200")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, sl.StatusCode)
+	assert.Equal(t, "", sl.ReasonPhrase)
+
+	for _, bad := range []string{"HTTP/1.1", "HTTP/2 200 OK", "HTTP/1.1 20 OK", "HTTP/1.1 abc OK", "FTP/1.1 200 OK"} {
+		_, err := ParseStatusLine(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestResponseContentLength(t *testing.T) {
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers.Get("content-type"))
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Body shorter than reported
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestResponseSetCookies(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 02 Jan 2030 02:04:05 GMT\r\n" +
+			"Content-Length: 0\r\nset-cookie: b=2\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 02:04:05 GMT", "b=2"}, r.SetCookies)
+	assert.Empty(t, r.Headers.Get("set-cookie"))
+}
+
+func TestResponseChunked(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5\r\nhello\r\n7;name=value\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers.Get("x-sum"))
+
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n",
+		numBytesPerRead: 64,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestResponseParseIncremental(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\nHTTP/1.1 next"
+	r := &Response{Headers: map[string]string{}, Trailers: map[string]string{}, state: responseStateParsingStatusLine}
+
+	// Fed one byte at a time, the parser only consumes complete elements.
+	var buffer []byte
+	for i := 0; i < len(raw) && r.state != responseStateDone; i++ {
+		buffer = append(buffer, raw[i])
+		n, err := r.Parse(buffer)
+		require.NoError(t, err)
+		buffer = buffer[n:]
+	}
+	assert.Equal(t, responseStateDone, r.state)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.Empty(t, buffer, "bytes after the response were consumed")
+
+	r = &Response{Headers: map[string]string{}, state: responseStateParsingStatusLine}
+	_, err := r.Parse([]byte("HTTP/1.1 200 OK\r\nX-Big: " + strings.Repeat("a", MaxHeaderBytes)))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestResponseUntilClose(t *testing.T) {
+	reader := &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nall of this\r\nuntil EOF",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "all of this\r\nuntil EOF", string(r.Body))
+}
+
+func TestResponseInterim(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 7,
+	}
+	r, err := ResponseFromReader(reader, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCreated, r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.Headers.Get("link"))
+	assert.Equal(t, "ok", string(r.Body))
+}
+
+func TestResponseBodyless(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		raw    string
+	}{
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n"},
+		{"HEAD", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"},
+		{"GET", "HTTP/1.1 204 No Content\r\n\r\n"},
+		{"GET", "HTTP/1.1 304 Not Modified\r\nContent-Length: 1000\r\n\r\n"},
+		{"GET", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"},
+	} {
+		// The reader never ends: a parser waiting for a body would hang
+		// or fail, so finishing proves it did not.
+		reader := io.MultiReader(strings.NewReader(tc.raw), neverEOF{})
+		r, err := ResponseFromReader(reader, tc.method)
+		require.NoError(t, err, tc.raw)
+		assert.Empty(t, r.Body, tc.raw)
+	}
+}
+
+// neverEOF fails the test run if it is read, standing in for a connection
+// that stays open.
+type neverEOF struct{}
+
+func (neverEOF) Read([]byte) (int, error) {
+	panic("read past the end of a bodyless response")
+}
+
+func TestResponseErrors(t *testing.T) {
+	for _, raw := range []string{
+		"HTTP/1.1 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"HTTP/1.1 200 OK\r\n",
+	} {
+		_, err := ResponseFromReader(strings.NewReader(raw), "GET")
+		assert.Error(t, err, raw)
+	}
+}
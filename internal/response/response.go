@@ -1,136 +1,569 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
+	"github.com/RayanMalki/tcptohttp/internal/cookie"
 	"github.com/RayanMalki/tcptohttp/internal/headers"
 )
 
-type StatusCode int
+// TimeFormat is the IMF-fixdate layout used by HTTP date headers.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
 
+// bufferSize is both the size of the bufio layer in front of the connection
+// and the largest body the writer will hold back to compute Content-Length.
+const bufferSize = 4096
+
+// Enum (int) for writer state
 const (
-	StatusOK            StatusCode = 200
-	StatusBadRequest    StatusCode = 400
-	StatusInternalError StatusCode = 500
+	writerStateHeader   = iota // nothing sent yet, status and headers can still change
+	writerStateBody            // status line and headers sent
+	writerStateTrailers        // last chunk sent, trailers may follow
+	writerStateDone
+	writerStateHijacked // the handler took over the connection
+)
+
+var (
+	errHeaderSent = errors.New("headers already sent")
+	errFinished   = errors.New("response already finished")
+	errNotChunked = errors.New("response is not using chunked encoding")
+
+	// ErrNotHijackable is returned by Hijack when the writer is not backed
+	// by a network connection.
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+	// ErrHijacked is returned by writes after the connection was hijacked.
+	ErrHijacked = errors.New("connection has been hijacked")
 )
 
+// Writer writes an HTTP/1.1 response. It implements io.Writer: the status
+// line (200 unless WriteStatusLine says otherwise) and the Header map are
+// sent implicitly before the first body bytes reach the connection.
 type Writer struct {
-	conn  io.Writer
-	state string //"init", "status_written", "headers_written"
+	raw    io.Writer
+	conn   *bufio.Writer
+	state  int
+	status StatusCode
+	header headers.Headers
+	// setCookies holds one value per Set-Cookie field, since they cannot
+	// be combined into one header value.
+	setCookies []string
+
+	// body holds output while the headers have not been sent, so
+	// Content-Length can be filled in from it.
+	body []byte
+
+	// chunked is set when the body is framed with chunked encoding.
+	chunked bool
+
+	// head is set for responses to HEAD requests: body bytes never reach
+	// the connection. Small bodies are still buffered so they can be
+	// encoded as for GET; past that they are only counted into discarded.
+	head      bool
+	discarded int
+
+	// encode may pick a content coding just before the headers are sent;
+	// encoder is the one in use, writing into the body framing.
+	encode  ContentEncoder
+	encoder io.WriteCloser
+
+	// framer replaces conn for protocols that frame the response
+	// themselves, such as HTTP/2.
+	framer Framer
 }
 
+// Framer puts a response on the wire for a protocol with its own message
+// framing. The Writer keeps deciding status, headers, content coding and
+// when the body ends; the Framer only encodes those events.
+type Framer interface {
+	// WriteHeaders sends the status and header fields, then one
+	// Set-Cookie field for each of setCookies.
+	WriteHeaders(status StatusCode, h headers.Headers, setCookies []string) error
+	// WriteData sends body bytes.
+	WriteData(p []byte) error
+	// WriteTrailers sends trailer fields and ends the response.
+	WriteTrailers(h headers.Headers) error
+	// EndStream ends the response without trailers.
+	EndStream() error
+	// Flush sends anything buffered.
+	Flush() error
+}
+
+// ContentEncoder is consulted once, right before the headers are sent.
+// size is the length of the whole body when it is known and -1 otherwise.
+// It may adjust h (Content-Encoding, Vary, ...) and return a function that
+// wraps the body writer, or nil to send the body as it is.
+type ContentEncoder func(status StatusCode, h headers.Headers, size int) func(io.Writer) io.WriteCloser
+
 func NewWriter(conn io.Writer) *Writer {
 	return &Writer{
-		conn:  conn,
-		state: "init",
+		raw:    conn,
+		conn:   bufio.NewWriterSize(conn, bufferSize),
+		state:  writerStateHeader,
+		header: headers.NewHeaders(),
 	}
 }
 
-func (w *Writer) WriteStatusLine(code StatusCode) error {
-	if w.state != "init" {
-		return fmt.Errorf("status already written")
+// NewFramedWriter returns a Writer that hands the response to f instead of
+// writing HTTP/1.1 to a connection. Such a writer cannot be hijacked.
+func NewFramedWriter(f Framer) *Writer {
+	return &Writer{
+		framer: f,
+		state:  writerStateHeader,
+		header: headers.NewHeaders(),
 	}
+}
 
-	reason := ""
-	switch code {
-	case StatusOK:
-		reason = "OK"
-	case StatusBadRequest:
-		reason = "Bad Request"
-	case StatusInternalError:
-		reason = "Internal Server Error"
-	default:
-		reason = ""
+func GetDefaultHeaders(contentLen int) headers.Headers {
+
+	headersMap := headers.NewHeaders()
+
+	lenghthStr := strconv.Itoa(contentLen)
+
+	headersMap.Set("Content-Length", lenghthStr)
+	headersMap.Set("Connection", "close")
+	headersMap.Set("Content-Type", "text/plain")
+
+	return headersMap
+
+}
+
+// DiscardBody makes the writer answer a HEAD request. Handlers write the
+// same body they would for GET; the bytes are dropped, but unless the
+// handler set Content-Length itself it is filled in from their count.
+// Chunked framing is never emitted.
+func (w *Writer) DiscardBody() {
+	w.head = true
+}
+
+// SetContentEncoder installs fn to choose a content coding for the body.
+// Responses to HEAD requests get the coding and headers GET would, without
+// the body.
+func (w *Writer) SetContentEncoder(fn ContentEncoder) {
+	w.encode = fn
+}
+
+// Header returns the header map that will be sent with the response.
+// Changes made after the headers were sent have no effect.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+// AddVary records that the response depends on the request header field,
+// adding it to the Vary header unless it is already listed or Vary is "*".
+// Middleware and handlers that look at a request header to shape the
+// response call it, so caches keep the variants apart.
+func (w *Writer) AddVary(field string) {
+	vary := w.header.Get("vary")
+	if headers.HasToken(vary, "*") || headers.HasToken(vary, field) {
+		return
+	}
+	if vary == "" {
+		w.header.Set("Vary", field)
+	} else {
+		w.header.Set("Vary", vary+", "+field)
 	}
+}
 
-	// Dynamically write the status line
-	if _, err := fmt.Fprintf(w.conn, "HTTP/1.1 %d %s\r\n", code, reason); err != nil {
+// SetCookie adds a Set-Cookie header for c, after checking it with Valid.
+// Each cookie goes out as its own Set-Cookie field.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
 		return err
 	}
-
-	w.state = "status_written"
+	w.AddSetCookie(c.String())
 	return nil
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+// AddSetCookie adds a Set-Cookie field whose value is already formatted,
+// such as one relayed from another server.
+func (w *Writer) AddSetCookie(value string) {
+	w.setCookies = append(w.setCookies, value)
+}
 
-	headersMap := headers.NewHeaders()
+// SetCookieValues returns the values added with SetCookie and AddSetCookie,
+// one per Set-Cookie field.
+func (w *Writer) SetCookieValues() []string {
+	return w.setCookies
+}
 
-	lenghthStr := strconv.Itoa(contentLen)
+// WriteStatusLine sets the response status. It does not touch the
+// connection; the status line goes out together with the headers.
+func (w *Writer) WriteStatusLine(code StatusCode) error {
+	if w.state != writerStateHeader || w.status != 0 {
+		return fmt.Errorf("status already written")
+	}
+	w.status = code
+	return nil
+}
 
-	headersMap["Content-Length"] = lenghthStr
-	headersMap["Connection"] = "close"
-	headersMap["Content-Type"] = "text/plain"
+// WriteHeaders merges h into the header map. If h says how the body is
+// framed (Content-Length or Transfer-Encoding), the headers are sent right
+// away; otherwise they wait for the body so the length can be filled in.
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.state == writerStateHijacked {
+		return ErrHijacked
+	}
+	if w.state != writerStateHeader {
+		return errHeaderSent
+	}
 
-	return headersMap
+	for key, value := range h {
+		w.header.Set(key, value)
+	}
 
+	if w.hasFraming() {
+		return w.startBody()
+	}
+	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	if w.state != "status_written" {
-		return fmt.Errorf("must write status line before headers")
+func (w *Writer) hasFraming() bool {
+	if w.head {
+		// A HEAD response only ever carries the length of the would-be body.
+		return w.header.Get("content-length") != ""
+	}
+	return w.header.Get("content-length") != "" || w.header.Get("transfer-encoding") != ""
+}
+
+func (w *Writer) sendHeader() error {
+	if w.status == 0 {
+		w.status = StatusOK
+	}
+
+	if !bodyAllowed(w.status) {
+		// Nothing follows the headers, so there is nothing to frame.
+		w.header.Delete("Transfer-Encoding")
+	}
+
+	if w.framer != nil {
+		if err := w.framer.WriteHeaders(w.status, w.header, w.setCookies); err != nil {
+			return err
+		}
+		w.chunked = !w.head && strings.Contains(strings.ToLower(w.header.Get("transfer-encoding")), "chunked")
+		w.state = writerStateBody
+		return nil
 	}
 
-	for key, value := range headers {
+	if _, err := fmt.Fprintf(w.conn, "HTTP/1.1 %d %s\r\n", w.status, StatusText(w.status)); err != nil {
+		return err
+	}
+
+	for key, value := range w.header {
 		if _, err := fmt.Fprintf(w.conn, "%s: %s\r\n", key, value); err != nil {
 			return err
 		}
 	}
+	for _, value := range w.setCookies {
+		if _, err := fmt.Fprintf(w.conn, "set-cookie: %s\r\n", value); err != nil {
+			return err
+		}
+	}
 
 	if _, err := fmt.Fprint(w.conn, "\r\n"); err != nil {
 		return err
 	}
 
-	w.state = "headers_written"
+	w.chunked = !w.head && strings.Contains(strings.ToLower(w.header.Get("transfer-encoding")), "chunked")
+	w.state = writerStateBody
 	return nil
 }
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state != "headers_written" {
-		return 0, fmt.Errorf("must write headers before body")
+// chooseEncoding asks the content encoder how to encode a body of the
+// given size, or -1 if unknown.
+func (w *Writer) chooseEncoding(size int) func(io.Writer) io.WriteCloser {
+	if w.encode == nil {
+		return nil
+	}
+	if w.status == 0 {
+		w.status = StatusOK
 	}
-	bytesWritten, err := w.conn.Write(p)
+	if !bodyAllowed(w.status) {
+		return nil
+	}
+	return w.encode(w.status, w.header, size)
+}
 
-	if err != nil {
-		return bytesWritten, err
+// startBody sends the headers for a body that is streamed rather than
+// buffered. An encoded body no longer has a known length, so it is chunked.
+func (w *Writer) startBody() error {
+	size := -1
+	if n, err := strconv.Atoi(w.header.Get("content-length")); err == nil {
+		size = n
 	}
 
-	w.state = "body_written"
+	wrap := w.chooseEncoding(size)
+	if wrap != nil {
+		w.header.Delete("Content-Length")
+		if !w.head {
+			w.header.Set("Transfer-Encoding", "chunked")
+		}
+	}
+	if err := w.sendHeader(); err != nil {
+		return err
+	}
+	if wrap != nil {
+		w.encoder = wrap(framedWriter{w})
+	}
+	return nil
+}
 
-	return bytesWritten, nil
+// startChunked sends the headers with chunked encoding and emits whatever
+// body was held back as the first chunk.
+func (w *Writer) startChunked() error {
+	w.header.Set("Transfer-Encoding", "chunked")
+	return w.startBuffered()
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+// startBuffered starts the body and writes whatever was held back before
+// the handler set its own framing.
+func (w *Writer) startBuffered() error {
+	if err := w.startBody(); err != nil {
+		return err
+	}
 
-	hexSize := fmt.Sprintf("%x\r\n", len(p))
+	body := w.body
+	w.body = nil
+	return w.writeBody(body)
+}
 
-	n1, err := w.conn.Write([]byte(hexSize))
-	if err != nil {
-		return n1, err
+// framedWriter lets a content encoder write into the body framing.
+type framedWriter struct {
+	w *Writer
+}
+
+func (f framedWriter) Write(p []byte) (int, error) {
+	if err := f.w.writeFramed(p); err != nil {
+		return 0, err
 	}
+	return len(p), nil
+}
 
-	n2, err := w.conn.Write(p)
-	if err != nil {
-		return n1 + n2, err
+// writeBody writes body bytes through the content encoder, if any.
+func (w *Writer) writeBody(p []byte) error {
+	if w.encoder != nil {
+		_, err := w.encoder.Write(p)
+		return err
 	}
+	return w.writeFramed(p)
+}
 
-	n3, err := w.conn.Write([]byte("\r\n"))
-	if err != nil {
-		return n1 + n2 + n3, err
+// closeEncoder flushes the tail of an encoded body into the framing.
+func (w *Writer) closeEncoder() error {
+	if w.encoder == nil {
+		return nil
 	}
+	err := w.encoder.Close()
+	w.encoder = nil
+	return err
+}
 
-	return n1 + n2 + n3, nil
+// endBody closes the content encoder and writes the last chunk.
+func (w *Writer) endBody() error {
+	if err := w.closeEncoder(); err != nil {
+		return err
+	}
+	if w.framer != nil {
+		return w.framer.EndStream()
+	}
+	if w.chunked {
+		if _, err := w.conn.Write([]byte("0\r\n\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (w *Writer) writeFramed(p []byte) error {
+	// HEAD, 1xx, 204 and 304 responses have no body; its bytes are dropped.
+	if len(p) == 0 || w.head || !bodyAllowed(w.status) {
+		return nil
+	}
+	if w.framer != nil {
+		return w.framer.WriteData(p)
+	}
+	if !w.chunked {
+		_, err := w.conn.Write(p)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w.conn, "%x\r\n", len(p)); err != nil {
+		return err
+	}
+	if _, err := w.conn.Write(p); err != nil {
+		return err
+	}
+	_, err := w.conn.Write([]byte("\r\n"))
+	return err
 }
 
+// Write writes body bytes, sending the headers first if needed. A body that
+// fits in the buffer by the time the handler returns gets a Content-Length;
+// a larger one switches the response to chunked encoding.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.head {
+		return w.discard(p)
+	}
+
+	switch w.state {
+	case writerStateHeader:
+		if w.hasFraming() {
+			if err := w.startBuffered(); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if len(w.body)+len(p) <= bufferSize {
+			w.body = append(w.body, p...)
+			return len(p), nil
+		}
+		if err := w.startChunked(); err != nil {
+			return 0, err
+		}
+	case writerStateBody:
+	default:
+		return 0, w.doneErr()
+	}
+
+	if err := w.writeBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) discard(p []byte) (int, error) {
+	switch w.state {
+	case writerStateHeader:
+		if w.hasFraming() {
+			if err := w.startBody(); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if w.discarded == 0 && len(w.body)+len(p) <= bufferSize {
+			w.body = append(w.body, p...)
+			return len(p), nil
+		}
+		w.discarded += len(w.body)
+		w.body = nil
+	case writerStateBody:
+	default:
+		return 0, w.doneErr()
+	}
+
+	w.discarded += len(p)
+	return len(p), nil
+}
+
+// WriteString is like Write but takes a string.
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ReadFrom copies r into the body until EOF.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, bufferSize)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteBody is kept for handlers written against the original sequential
+// API; it is the same as Write.
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	return w.Write(p)
+}
+
+// WriteChunkedBody writes p as a single chunk. If the headers have not been
+// sent yet they go out with Transfer-Encoding: chunked.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.head {
+		return w.discard(p)
+	}
+
+	switch w.state {
+	case writerStateHeader:
+		w.header.Delete("Content-Length")
+		if err := w.startChunked(); err != nil {
+			return 0, err
+		}
+	case writerStateBody:
+		if !w.chunked {
+			return 0, errNotChunked
+		}
+	default:
+		return 0, w.doneErr()
+	}
+
+	if err := w.writeBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteChunkedBodyDone writes the last (zero-length) chunk. Trailers may be
+// written afterwards with WriteTrailers.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.head || !bodyAllowed(w.status) {
+		return 0, nil
+	}
+	if w.state == writerStateHeader {
+		w.header.Delete("Content-Length")
+		if err := w.startChunked(); err != nil {
+			return 0, err
+		}
+	}
+	if w.state != writerStateBody {
+		return 0, w.doneErr()
+	}
+	if !w.chunked {
+		return 0, errNotChunked
+	}
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
+	}
 
-	return w.conn.Write([]byte("0\r\n\r\n"))
+	w.state = writerStateTrailers
+	if w.framer != nil {
+		// The trailers, or the end of the stream, close the body.
+		return 0, nil
+	}
+	return w.conn.Write([]byte("0\r\n"))
 }
 
+// WriteTrailers writes the trailer fields that follow the last chunk and
+// ends the body.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.head || !bodyAllowed(w.status) {
+		return nil
+	}
+	if w.state != writerStateTrailers {
+		return fmt.Errorf("must write the last chunk before trailers")
+	}
+	if w.framer != nil {
+		w.state = writerStateDone
+		return w.framer.WriteTrailers(h)
+	}
 
 	for key, value := range h {
 		_, err := w.conn.Write([]byte(key + ": " + value + "\r\n"))
@@ -146,6 +579,208 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 
 	}
 
+	w.state = writerStateDone
 	return nil
 
 }
+
+// Flush sends everything written so far to the connection. Headers that
+// are still waiting for the body are sent with chunked encoding, since more
+// body may follow.
+func (w *Writer) Flush() error {
+	if w.state == writerStateHijacked {
+		return ErrHijacked
+	}
+	if w.state == writerStateHeader && !w.head {
+		var err error
+		if w.hasFraming() {
+			err = w.startBody()
+		} else {
+			err = w.startChunked()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// flush sends what the writer or the framer has buffered.
+func (w *Writer) flush() error {
+	if w.framer != nil {
+		return w.framer.Flush()
+	}
+	return w.conn.Flush()
+}
+
+// Finish completes the response: unsent headers get a Content-Length
+// matching the buffered body, a chunked body is terminated, and the buffer
+// is flushed. The server calls Finish after the handler returns.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case writerStateHijacked:
+		return nil
+	case writerStateHeader:
+		if w.hasFraming() {
+			if err := w.startBuffered(); err != nil {
+				return err
+			}
+			if err := w.endBody(); err != nil {
+				return err
+			}
+			break
+		}
+
+		body := w.body
+		w.body = nil
+		length := len(body)
+		if w.head {
+			w.header.Delete("Transfer-Encoding")
+		}
+		if w.head && w.discarded > 0 {
+			// GET would have streamed this body, so only its length
+			// before any encoding is known.
+			length = w.discarded
+			if w.chooseEncoding(-1) != nil {
+				length = -1
+			}
+		} else if wrap := w.chooseEncoding(len(body)); wrap != nil {
+			// The whole body is at hand, so encode it in one go and keep
+			// the Content-Length.
+			var encoded bytes.Buffer
+			enc := wrap(&encoded)
+			if _, err := enc.Write(body); err != nil {
+				return err
+			}
+			if err := enc.Close(); err != nil {
+				return err
+			}
+			body = encoded.Bytes()
+			length = len(body)
+		}
+		if bodyAllowed(w.status) && length >= 0 {
+			w.header.Set("Content-Length", strconv.Itoa(length))
+		}
+		if err := w.sendHeader(); err != nil {
+			return err
+		}
+		if err := w.writeFramed(body); err != nil {
+			return err
+		}
+		if w.framer != nil {
+			if err := w.framer.EndStream(); err != nil {
+				return err
+			}
+		}
+	case writerStateBody:
+		if err := w.endBody(); err != nil {
+			return err
+		}
+	case writerStateTrailers:
+		if w.framer != nil {
+			if err := w.framer.EndStream(); err != nil {
+				return err
+			}
+		} else if _, err := w.conn.Write([]byte("\r\n")); err != nil {
+			return err
+		}
+	}
+
+	w.state = writerStateDone
+	return w.flush()
+}
+
+// Hijacker is implemented by connections that can hand themselves over
+// together with bytes already read from them but not yet parsed. The
+// server's connections implement it; Hijack falls back to a plain
+// net.Conn with no buffered bytes.
+type Hijacker interface {
+	Hijack() (net.Conn, []byte, error)
+}
+
+// Hijack hands the underlying connection to the caller, who becomes
+// responsible for closing it, along with any bytes the client already sent
+// past the end of the request. Anything already sent is flushed first;
+// unsent headers and buffered body are dropped. After Hijack the writer
+// refuses further output and Finish does nothing.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.state == writerStateHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.framer != nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if w.state != writerStateHeader {
+		if err := w.conn.Flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var conn net.Conn
+	var buffered []byte
+	switch c := w.raw.(type) {
+	case Hijacker:
+		var err error
+		conn, buffered, err = c.Hijack()
+		if err != nil {
+			return nil, nil, err
+		}
+	case net.Conn:
+		conn = c
+	default:
+		return nil, nil, ErrNotHijackable
+	}
+
+	w.state = writerStateHijacked
+	w.body = nil
+	return conn, buffered, nil
+}
+
+// doneErr is the error for output attempted after the body has ended.
+func (w *Writer) doneErr() error {
+	if w.state == writerStateHijacked {
+		return ErrHijacked
+	}
+	return errFinished
+}
+
+// CloseNotifier is implemented by connections that can tell when the
+// client has gone away. The server's connections implement it.
+type CloseNotifier interface {
+	CloseNotify() <-chan struct{}
+}
+
+// CloseNotify returns a channel that is closed once the client closes the
+// connection, for handlers that stream for a long time. It returns nil,
+// a channel that never fires, when the connection cannot tell. A
+// connection watched this way can no longer be hijacked.
+func (w *Writer) CloseNotify() <-chan struct{} {
+	if n, ok := w.raw.(CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+	if n, ok := w.framer.(CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+	return nil
+}
+
+// Hijacked reports whether Hijack has been called.
+func (w *Writer) Hijacked() bool {
+	return w.state == writerStateHijacked
+}
+
+// bodyAllowed reports whether a response with this status may carry a body.
+func bodyAllowed(status StatusCode) bool {
+	switch {
+	case status >= 100 && status < 200:
+		return false
+	case status == StatusNoContent, status == StatusNotModified:
+		return false
+	}
+	return true
+}
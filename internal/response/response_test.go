@@ -0,0 +1,340 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RayanMalki/tcptohttp/internal/cookie"
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingWriter records how many Write calls reach the connection.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func TestWriterFillsContentLength(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, conn.writes)
+
+	require.NoError(t, w.Finish())
+	out := conn.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+	assert.Equal(t, 1, conn.writes)
+}
+
+func TestWriterSwitchesToChunkedOnOverflow(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	body := bytes.Repeat([]byte("a"), bufferSize+1)
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out, "content-length")
+	assert.Contains(t, out, "\r\n1001\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n0\r\n\r\n"))
+}
+
+func TestWriterKeepsExplicitContentLength(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 2\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhi"))
+}
+
+func TestWriterContentLengthAfterWrite(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	_, err := w.WriteString("hello")
+	require.NoError(t, err)
+	w.Header().Set("Content-Length", "5")
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+}
+
+func TestWriterImplicitStatusAndHeaders(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(map[string]int{"a": 1}))
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.Contains(t, out, "content-length: 8\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n{\"a\":1}\n"))
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	n, err := io.Copy(w, strings.NewReader("streamed"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), n)
+	_, err = w.WriteString(" body")
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 13\r\n")
+	assert.True(t, strings.HasSuffix(out, "streamed body"))
+}
+
+func TestWriterHeadersLockedAfterSend(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	require.NoError(t, w.Flush())
+	assert.Error(t, w.WriteStatusLine(StatusBadRequest))
+	assert.Error(t, w.WriteHeaders(headers.NewHeaders()))
+}
+
+func TestWriterChunkedTrailers(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	w.Header().Set("Trailer", "X-Sum")
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "6")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n3\r\nabc\r\n0\r\nx-sum: 6\r\n\r\n"))
+
+	_, err = w.Write([]byte("late"))
+	assert.Error(t, err)
+}
+
+func TestWriterRejectsChunksOnLengthBody(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	assert.Error(t, err)
+}
+
+func TestWriterHeadCountsDiscardedBody(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	w.DiscardBody()
+
+	body := bytes.Repeat([]byte("a"), bufferSize*2)
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 8192\r\n")
+	assert.NotContains(t, out, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestWriterHeadSuppressesChunkedFraming(t *testing.T) {
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	w.DiscardBody()
+
+	w.Header().Set("Transfer-Encoding", "chunked")
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	require.NoError(t, w.Finish())
+
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 3\r\n")
+	assert.NotContains(t, out, "chunked")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestWriterDropsBodyForBodylessStatus(t *testing.T) {
+	for _, status := range []StatusCode{StatusNoContent, StatusNotModified} {
+		conn := &countingWriter{}
+		w := NewWriter(conn)
+
+		require.NoError(t, w.WriteStatusLine(status))
+		_, err := w.WriteString("oops")
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\n"), "status %d", status)
+		assert.NotContains(t, conn.String(), "content-length")
+
+		conn = &countingWriter{}
+		w = NewWriter(conn)
+		require.NoError(t, w.WriteStatusLine(status))
+		_, err = w.WriteChunkedBody([]byte("oops"))
+		require.NoError(t, err)
+		_, err = w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\n"), "status %d", status)
+		assert.NotContains(t, conn.String(), "transfer-encoding")
+		assert.NotContains(t, conn.String(), "oops")
+	}
+}
+
+func TestWriterHijack(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	w = NewWriter(server)
+	w.WriteString("dropped")
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Empty(t, buffered)
+	assert.True(t, w.Hijacked())
+
+	_, err = w.WriteString("more")
+	assert.ErrorIs(t, err, ErrHijacked)
+	assert.ErrorIs(t, w.Flush(), ErrHijacked)
+	require.NoError(t, w.Finish())
+
+	// Nothing the writer held back reached the connection.
+	go conn.Write([]byte("raw"))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(buf))
+}
+
+// recordingFramer logs the events a framed writer produces.
+type recordingFramer struct {
+	events []string
+}
+
+func (f *recordingFramer) WriteHeaders(status StatusCode, h headers.Headers, setCookies []string) error {
+	f.events = append(f.events, "headers "+strconv.Itoa(int(status))+" content-length="+h.Get("content-length"))
+	return nil
+}
+
+func (f *recordingFramer) WriteData(p []byte) error {
+	f.events = append(f.events, "data "+string(p))
+	return nil
+}
+
+func (f *recordingFramer) WriteTrailers(h headers.Headers) error {
+	f.events = append(f.events, "trailers x-sum="+h.Get("x-sum"))
+	return nil
+}
+
+func (f *recordingFramer) EndStream() error {
+	f.events = append(f.events, "end")
+	return nil
+}
+
+func (f *recordingFramer) Flush() error {
+	f.events = append(f.events, "flush")
+	return nil
+}
+
+func TestFramedWriter(t *testing.T) {
+	f := &recordingFramer{}
+	w := NewFramedWriter(f)
+	w.WriteStatusLine(StatusNotFound)
+	w.WriteString("missing")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, []string{"headers 404 content-length=7", "data missing", "end", "flush"}, f.events)
+
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+}
+
+func TestFramedWriterStreamsWithTrailers(t *testing.T) {
+	f := &recordingFramer{}
+	w := NewFramedWriter(f)
+	w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, w.Flush())
+	w.WriteChunkedBody([]byte("def"))
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "12")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, []string{
+		"headers 200 content-length=", "data abc", "flush", "data def", "trailers x-sum=12", "flush",
+	}, f.events)
+}
+
+func TestWriterAddVary(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	w.AddVary("Accept-Encoding")
+	w.AddVary("Accept")
+	w.AddVary("accept-encoding")
+	assert.Equal(t, "Accept-Encoding, Accept", w.Header().Get("Vary"))
+
+	w = NewWriter(&bytes.Buffer{})
+	w.Header().Set("Vary", "*")
+	w.AddVary("Accept")
+	assert.Equal(t, "*", w.Header().Get("Vary"))
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/"}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "bad name"}))
+	require.NoError(t, w.Finish())
+
+	out := buf.String()
+	assert.Contains(t, out, "set-cookie: a=1; Path=/\r\n")
+	assert.Contains(t, out, "set-cookie: b=2; HttpOnly\r\n")
+	assert.NotContains(t, out, "bad")
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// readBufferSize bounds the request line and each header line.
+const readBufferSize = 8192
+
+// conn is a client connection owned by the server until a handler
+// hijacks it.
+type conn struct {
+	rwc      io.ReadWriteCloser
+	br       *bufio.Reader
+	s        *Server
+	hijacked bool
+
+	mu     sync.Mutex
+	closed chan struct{} // set once CloseNotify was called
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.rwc.Write(p)
+}
+
+// Hijack implements response.Hijacker. The server forgets the connection,
+// so neither the end of the handler nor Server.Close will close it.
+func (c *conn) Hijack() (net.Conn, []byte, error) {
+	nc, ok := c.rwc.(net.Conn)
+	c.mu.Lock()
+	if !ok || c.closed != nil {
+		c.mu.Unlock()
+		// CloseNotify's reader owns the read side.
+		return nil, nil, response.ErrNotHijackable
+	}
+	c.hijacked = true
+	c.mu.Unlock()
+	c.s.untrack(c)
+
+	// Bytes the client sent after the request, e.g. the first frames of
+	// the protocol being switched to.
+	buffered, _ := c.br.Peek(c.br.Buffered())
+	return nc, append([]byte(nil), buffered...), nil
+}
+
+// CloseNotify implements response.CloseNotifier by reading the connection
+// until the client closes it. The server answers a single request per
+// connection, so whatever the client sends meanwhile is discarded.
+func (c *conn) CloseNotify() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed == nil {
+		closed := make(chan struct{})
+		c.closed = closed
+		go func() {
+			io.Copy(io.Discard, c.br)
+			close(closed)
+		}()
+	}
+	return c.closed
+}
+
+func (s *Server) track(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// dateCache keeps the formatted Date header, reformatting it at most once
+// per second.
+type dateCache struct {
+	mu     sync.Mutex
+	second int64
+	value  string
+}
+
+var date dateCache
+
+func (c *dateCache) get(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sec := now.Unix(); sec != c.second || c.value == "" {
+		c.second = sec
+		c.value = now.UTC().Format(response.TimeFormat)
+	}
+	return c.value
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+
+	"github.com/RayanMalki/tcptohttp/internal/negotiate"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// ErrorRenderer writes the response for a request the server could not hand
+// to the handler. req is whatever was parsed before the failure and may be
+// nil or only partially filled in.
+type ErrorRenderer func(w *response.Writer, req *request.Request, status response.StatusCode, err error)
+
+// problem is the RFC 9457 problem details object.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func errorTitle(status response.StatusCode) string {
+	if text := response.StatusText(status); text != "" {
+		return text
+	}
+	return "Error"
+}
+
+func writeError(w *response.Writer, status response.StatusCode, contentType string, body []byte) {
+	w.WriteStatusLine(status)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// PlainTextErrors renders errors as text/plain.
+func PlainTextErrors(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	body := fmt.Sprintf("%d %s\n", status, errorTitle(status))
+	if err != nil {
+		body += err.Error() + "\n"
+	}
+	writeError(w, status, "text/plain; charset=utf-8", []byte(body))
+}
+
+// HTMLErrors renders errors as a small HTML page.
+func HTMLErrors(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	title := fmt.Sprintf("%d %s", status, errorTitle(status))
+	detail := ""
+	if err != nil {
+		detail = "<p>" + html.EscapeString(err.Error()) + "</p>"
+	}
+	body := `<html>
+  <head><title>` + html.EscapeString(title) + `</title></head>
+  <body><h1>` + html.EscapeString(errorTitle(status)) + `</h1>` + detail + `</body>
+</html>`
+	writeError(w, status, "text/html; charset=utf-8", []byte(body))
+}
+
+// ProblemJSONErrors renders errors as RFC 9457 application/problem+json.
+func ProblemJSONErrors(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  errorTitle(status),
+		Status: int(status),
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	body, _ := json.Marshal(p)
+	writeError(w, status, "application/problem+json", body)
+}
+
+// NegotiatedErrors picks between the built-in renderers using the request's
+// Accept header. HTML is used when there is no usable Accept header.
+func NegotiatedErrors(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	accept := ""
+	if req != nil && req.Headers != nil {
+		accept = req.Headers.Get("accept")
+	}
+	w.AddVary("Accept")
+
+	switch preferredErrorType(accept) {
+	case "application/problem+json":
+		ProblemJSONErrors(w, req, status, err)
+	case "text/plain":
+		PlainTextErrors(w, req, status, err)
+	default:
+		HTMLErrors(w, req, status, err)
+	}
+}
+
+// preferredErrorType returns the error media type the Accept header ranks
+// highest. Ties keep the server's order: HTML, problem+json, plain text.
+// Clients asking for plain JSON get problem+json.
+func preferredErrorType(accept string) string {
+	switch offer := negotiate.MediaType(accept, "text/html", "application/problem+json", "application/json", "text/plain"); offer {
+	case "":
+		return "text/html"
+	case "application/json":
+		return "application/problem+json"
+	default:
+		return offer
+	}
+}
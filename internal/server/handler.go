@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// StripPrefix returns a handler that removes prefix from the request target
+// before calling h. Requests whose target does not start with prefix get a
+// 404.
+func StripPrefix(prefix string, h Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		target := req.RequestLine.RequestTarget
+		if !strings.HasPrefix(target, prefix) {
+			NegotiatedErrors(w, req, response.StatusNotFound, fmt.Errorf("no such resource: %s", target))
+			return
+		}
+
+		rest := strings.TrimPrefix(target, prefix)
+		if !strings.HasPrefix(rest, "/") {
+			rest = "/" + rest
+		}
+		req.RequestLine.RequestTarget = rest
+		h(w, req)
+	}
+}
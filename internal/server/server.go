@@ -1,48 +1,239 @@
 package server
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/http2"
 	"github.com/RayanMalki/tcptohttp/internal/request"
 	"github.com/RayanMalki/tcptohttp/internal/response"
 )
 
 type Server struct {
-	closed bool
+	closed         atomic.Bool
+	errorRenderer  ErrorRenderer
+	serverName     string
+	defaultHeaders headers.Headers
+
+	// h2c enables HTTP/2 without TLS. h2Options configure HTTP/2 both
+	// there and over TLS.
+	h2c       bool
+	h2Options []http2.Option
+
+	// tlsConfig, when set, makes every connection start with a TLS
+	// handshake offering h2 and http/1.1.
+	tlsConfig *tls.Config
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[*conn]struct{} // open connections that are not hijacked
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
-func runConnection(s *Server, conn io.ReadWriteCloser, handler Handler) {
-	defer conn.Close()
+// Option configures a Server created by Serve.
+type Option func(*Server)
+
+// WithErrorRenderer replaces the renderer used for requests that fail to
+// parse. The default is NegotiatedErrors.
+func WithErrorRenderer(renderer ErrorRenderer) Option {
+	return func(s *Server) {
+		s.errorRenderer = renderer
+	}
+}
+
+// WithServerHeader sends name as the Server header on every response.
+func WithServerHeader(name string) Option {
+	return func(s *Server) {
+		s.serverName = name
+	}
+}
+
+// WithDefaultHeaders adds h to every response, e.g. security headers.
+// Handlers can override or delete them through the writer's Header map.
+func WithDefaultHeaders(h headers.Headers) Option {
+	return func(s *Server) {
+		for key, value := range h {
+			s.defaultHeaders.Set(key, value)
+		}
+	}
+}
+
+// WithH2C serves HTTP/2 over cleartext connections, both to clients with
+// prior knowledge and to those that ask for an h2c upgrade. Each stream
+// goes to the same handler as an HTTP/1.1 request would.
+func WithH2C(opts ...http2.Option) Option {
+	return func(s *Server) {
+		s.h2c = true
+		s.h2Options = append(s.h2Options, opts...)
+	}
+}
 
-	req, err := request.RequestFromReader(conn)
+// WithHTTP2Options configures the HTTP/2 connections served over TLS or,
+// with WithH2C, in cleartext.
+func WithHTTP2Options(opts ...http2.Option) Option {
+	return func(s *Server) {
+		s.h2Options = append(s.h2Options, opts...)
+	}
+}
+
+// WithTLS serves every connection over TLS using cfg, which needs at least
+// one certificate. Clients pick HTTP/2 or HTTP/1.1 through ALPN; both go
+// to the same handler. cfg is cloned, and its NextProtos are replaced.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg.Clone()
+		s.tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		// HTTP/2 requires TLS 1.2 or later (RFC 9113 section 9.2).
+		s.tlsConfig.MinVersion = max(s.tlsConfig.MinVersion, tls.VersionTLS12)
+	}
+}
+
+func runConnection(s *Server, rwc io.ReadWriteCloser, handler Handler) {
+	if s.tlsConfig != nil {
+		tc, err := s.handshake(rwc)
+		if err != nil {
+			rwc.Close()
+			return
+		}
+		rwc = tc
+	}
+
+	c := &conn{rwc: rwc, br: bufio.NewReaderSize(rwc, readBufferSize), s: s}
+	if !s.track(c) {
+		rwc.Close()
+		return
+	}
+	defer func() {
+		if !c.hijacked {
+			s.untrack(c)
+			rwc.Close()
+		}
+	}()
+
+	tc, isTLS := rwc.(*tls.Conn)
+	if isTLS && tc.ConnectionState().NegotiatedProtocol == "h2" {
+		s.newH2Server(handler).ServeConn(readWriter{c.br, c}, tc.RemoteAddr().String())
+		return
+	}
+
+	req, err := request.RequestFromReader(c.br)
 	if err != nil {
-		w := response.NewWriter(conn)
-		html := `<html>
-  <head><title>400 Bad Request</title></head>
-  <body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body>
-</html>`
-		w.WriteStatusLine(response.StatusBadRequest)
-		headers := response.GetDefaultHeaders(len(html))
-		headers.Set("Content-Type", "text/html")
-		w.WriteHeaders(headers)
-		w.WriteBody([]byte(html))
+		var partial *request.Request
+		var parseErr *request.ParseError
+		if errors.As(err, &parseErr) {
+			partial = parseErr.Request
+		}
+		w := s.newWriter(c)
+		s.errorRenderer(w, partial, response.StatusBadRequest, err)
+		w.Finish()
 		return
 	}
 
-	w := response.NewWriter(conn)
+	if nc, ok := rwc.(net.Conn); ok {
+		req.RemoteAddr = nc.RemoteAddr().String()
+	}
+	req.TLS = isTLS
+
+	// Over TLS, HTTP/2 is only reached through ALPN.
+	h2c := s.h2c && !isTLS
+	if req.RequestLine.Method == "PRI" {
+		if !h2c {
+			w := s.newWriter(c)
+			s.errorRenderer(w, req, response.StatusHTTPVersionNotSupported, errors.New("HTTP/2 is not enabled"))
+			w.Finish()
+			return
+		}
+		// The parser consumed the start of the preface; hand it back.
+		r := io.MultiReader(strings.NewReader("PRI * HTTP/2.0\r\n\r\n"), c.br)
+		s.newH2Server(handler).ServeConn(readWriter{r, c}, req.RemoteAddr)
+		return
+	}
+	if h2c && http2.UpgradeRequested(req) {
+		s.newH2Server(handler).ServeUpgrade(readWriter{c.br, c}, req, req.RemoteAddr)
+		return
+	}
+
+	w := s.newWriter(c)
+	if req.RequestLine.Method == "HEAD" {
+		// Handlers answer HEAD as they would GET; the writer drops the body.
+		w.DiscardBody()
+	}
 	handler(w, req)
+	if w.Hijacked() {
+		// The connection belongs to the handler now.
+		return
+	}
+	w.Finish()
+}
+
+// handshakeTimeout bounds the TLS handshake of a new connection.
+const handshakeTimeout = 10 * time.Second
+
+// handshake runs the server side of the TLS handshake on rwc, which must
+// be a net.Conn.
+func (s *Server) handshake(rwc io.ReadWriteCloser) (*tls.Conn, error) {
+	nc, ok := rwc.(net.Conn)
+	if !ok {
+		return nil, errors.New("TLS needs a network connection")
+	}
+	tc := tls.Server(nc, s.tlsConfig)
+	tc.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// newWriter returns a response writer for conn with the server's default
+// headers already in place. Connections serve a single request, so every
+// response announces that the connection will close.
+func (s *Server) newWriter(conn io.Writer) *response.Writer {
+	w := response.NewWriter(conn)
+	s.setDefaultHeaders(w.Header())
+	w.Header().Set("Connection", "close")
+	return w
+}
+
+func (s *Server) setDefaultHeaders(h headers.Headers) {
+	for key, value := range s.defaultHeaders {
+		h.Set(key, value)
+	}
+	h.Set("Date", date.get(time.Now()))
+	if s.serverName != "" {
+		h.Set("Server", s.serverName)
+	}
+}
+
+// newH2Server returns an HTTP/2 server whose responses get the same
+// default headers as HTTP/1.1 ones.
+func (s *Server) newH2Server(handler Handler) *http2.Server {
+	return http2.NewServer(func(w *response.Writer, req *request.Request) {
+		s.setDefaultHeaders(w.Header())
+		handler(w, req)
+	}, s.h2Options...)
+}
+
+// readWriter joins the two halves of a connection whose reads must go
+// through a buffer.
+type readWriter struct {
+	io.Reader
+	io.Writer
 }
 
 func runServer(s *Server, listener net.Listener, handler Handler) {
 	for {
 		conn, err := listener.Accept()
-		if s.closed {
-			return
-		}
 		if err != nil {
 			return
 		}
@@ -50,17 +241,52 @@ func runServer(s *Server, listener net.Listener, handler Handler) {
 	}
 }
 
-func Serve(port uint16, handler Handler) (*Server, error) {
+func newServer(opts ...Option) *Server {
+	s := &Server{
+		errorRenderer:  NegotiatedErrors,
+		defaultHeaders: headers.NewHeaders(),
+		conns:          map[*conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func Serve(port uint16, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
-	server := &Server{}
+	server := newServer(opts...)
+	server.listener = listener
 	go runServer(server, listener, handler)
 	return server, nil
 }
 
+// Addr returns the address the server listens on, which tells callers the
+// port picked when Serve was given port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes the open ones. Connections
+// hijacked by a handler are left alone.
 func (s *Server) Close() error {
-	s.closed = true
-	return nil
+	if s.closed.Swap(true) {
+		return nil
+	}
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.rwc.Close()
+		delete(s.conns, c)
+	}
+	return err
 }
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/http2"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn feeds a canned request to the server and records what it writes.
+type fakeConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *fakeConn) Close() error                { return nil }
+
+func newFakeConn(data string) *fakeConn {
+	return &fakeConn{Reader: strings.NewReader(data)}
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	body := "ok"
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestBadRequestNegotiatesProblemJSON(t *testing.T) {
+	s := newServer()
+	conn := newFakeConn("GET / HTTP/1.1\r\nAccept: application/problem+json\r\nBad Header\r\n\r\n")
+	runConnection(s, conn, okHandler)
+
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "content-type: application/problem+json")
+	assert.Contains(t, out, `"status":400`)
+	assert.NotContains(t, out, "kinda sucked")
+}
+
+func TestBadRequestDefaultsToHTML(t *testing.T) {
+	s := newServer()
+	conn := newFakeConn("geT / HTTP/1.1\r\n\r\n")
+	runConnection(s, conn, okHandler)
+
+	out := conn.out.String()
+	assert.Contains(t, out, "content-type: text/html")
+	assert.Contains(t, out, "invalid HTTP method: geT")
+}
+
+func TestCustomErrorRenderer(t *testing.T) {
+	var gotStatus response.StatusCode
+	s := newServer(WithErrorRenderer(func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		gotStatus = status
+		PlainTextErrors(w, req, status, err)
+	}))
+	conn := newFakeConn("GET / HTTP/1.1\r\n")
+	runConnection(s, conn, okHandler)
+
+	assert.Equal(t, response.StatusBadRequest, gotStatus)
+	assert.Contains(t, conn.out.String(), "content-type: text/plain")
+}
+
+func TestPreferredErrorType(t *testing.T) {
+	assert.Equal(t, "text/html", preferredErrorType(""))
+	assert.Equal(t, "text/html", preferredErrorType("*/*"))
+	assert.Equal(t, "text/plain", preferredErrorType("text/plain"))
+	assert.Equal(t, "application/problem+json", preferredErrorType("application/json"))
+	assert.Equal(t, "text/plain", preferredErrorType("text/html;q=0.1, text/*;q=0.5"))
+}
+
+func TestHeadKeepsMethodWithoutBody(t *testing.T) {
+	s := newServer()
+	var method string
+	conn := newFakeConn("HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	runConnection(s, conn, func(w *response.Writer, req *request.Request) {
+		method = req.RequestLine.Method
+		w.WriteString("hello")
+	})
+
+	out := conn.out.String()
+	assert.Equal(t, "HEAD", method)
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestDateServerAndDefaultHeaders(t *testing.T) {
+	defaults := headers.NewHeaders()
+	defaults.Set("X-Frame-Options", "DENY")
+	defaults.Set("X-Content-Type-Options", "nosniff")
+	s := newServer(WithServerHeader("tcptohttp"), WithDefaultHeaders(defaults))
+
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	runConnection(s, conn, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.WriteString("ok")
+	})
+
+	out := conn.out.String()
+	assert.Contains(t, out, "date: ")
+	assert.Contains(t, out, "server: tcptohttp\r\n")
+	assert.Contains(t, out, "x-content-type-options: nosniff\r\n")
+	assert.Contains(t, out, "x-frame-options: SAMEORIGIN\r\n")
+	assert.NotContains(t, out, "DENY")
+}
+
+func TestDateCacheFormatsOncePerSecond(t *testing.T) {
+	var c dateCache
+	now := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.Equal(t, "Tue, 04 Mar 2025 05:06:07 GMT", c.get(now))
+	assert.Equal(t, "Tue, 04 Mar 2025 05:06:07 GMT", c.get(now.Add(900*time.Millisecond)))
+	assert.Equal(t, "Tue, 04 Mar 2025 05:06:08 GMT", c.get(now.Add(time.Second)))
+}
+
+// startServer serves handler on a local port until the test ends.
+func startServer(t *testing.T, handler Handler, opts ...Option) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := newServer(opts...)
+	s.listener = l
+	go runServer(s, l, handler)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func TestHijack(t *testing.T) {
+	got := make(chan string, 1)
+	s, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(buffered)
+		go func() {
+			defer conn.Close()
+			io.WriteString(conn, "switched\n")
+			io.Copy(conn, conn)
+		}()
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The first bytes of the new protocol arrive with the request.
+	_, err = io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: x\r\n\r\nearly")
+	require.NoError(t, err)
+	assert.Equal(t, "early", <-got)
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "switched\n", line)
+
+	// Closing the server leaves the hijacked connection alone.
+	require.NoError(t, s.Close())
+	_, err = io.WriteString(conn, "echo")
+	require.NoError(t, err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(br, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "echo", string(echo))
+}
+
+func TestCloseStopsServer(t *testing.T) {
+	s, addr := startServer(t, okHandler)
+
+	// An open connection that has not sent its request yet.
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, s.Close())
+
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestHijackNotSupported(t *testing.T) {
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	var hijackErr error
+	runConnection(newServer(), conn, func(w *response.Writer, req *request.Request) {
+		_, _, hijackErr = w.Hijack()
+	})
+	assert.ErrorIs(t, hijackErr, response.ErrNotHijackable)
+	assert.True(t, strings.HasPrefix(conn.out.String(), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestCloseNotify(t *testing.T) {
+	gone := make(chan error, 1)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteString("streaming")
+		w.Flush()
+		select {
+		case <-w.CloseNotify():
+			_, _, err := w.Hijack()
+			gone <- err
+		case <-time.After(5 * time.Second):
+			gone <- errors.New("client close not noticed")
+		}
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: x\r\n\r\n")
+	require.NoError(t, err)
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.ErrorIs(t, <-gone, response.ErrNotHijackable)
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteString("over " + req.RequestLine.HttpVersion)
+	}, WithH2C(), WithServerHeader("tcptohttp"))
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "over 2.0", string(body))
+	assert.Equal(t, "tcptohttp", resp.Header.Get("Server"))
+	assert.NotEmpty(t, resp.Header.Get("Date"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	_, addr := startServer(t, okHandler, WithH2C())
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+}
+
+func TestH2CUpgradeIgnoredWhenDisabled(t *testing.T) {
+	conn := newFakeConn("GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	runConnection(newServer(), conn, okHandler)
+	assert.True(t, strings.HasPrefix(conn.out.String(), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestPrefaceWithoutH2C(t *testing.T) {
+	conn := newFakeConn("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	runConnection(newServer(), conn, okHandler)
+	assert.True(t, strings.HasPrefix(conn.out.String(), "HTTP/1.1 505 HTTP Version Not Supported\r\n"))
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a
+// pool that trusts it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tcptohttp test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestTLSNegotiatesProtocol(t *testing.T) {
+	cert, pool := testCertificate(t)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteString("over " + req.RequestLine.HttpVersion + " tls=" + strconv.FormatBool(req.TLS))
+	}, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	tests := []struct {
+		name      string
+		protocols func(*http.Protocols)
+		wantProto int
+		wantBody  string
+		wantClose bool
+	}{
+		{"h2", func(p *http.Protocols) { p.SetHTTP2(true) }, 2, "over 2.0 tls=true", false},
+		{"http/1.1", func(p *http.Protocols) { p.SetHTTP1(true) }, 1, "over 1.1 tls=true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var protocols http.Protocols
+			tt.protocols(&protocols)
+			transport := &http.Transport{Protocols: &protocols, TLSClientConfig: &tls.Config{RootCAs: pool}}
+			defer transport.CloseIdleConnections()
+
+			resp, err := (&http.Client{Transport: transport, Timeout: 5 * time.Second}).Get("https://" + addr + "/")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantProto, resp.ProtoMajor)
+			assert.Equal(t, tt.wantBody, string(body))
+			assert.Equal(t, tt.wantClose, resp.Close)
+		})
+	}
+}
+
+func TestTLSWithoutALPN(t *testing.T) {
+	cert, pool := testCertificate(t)
+	_, addr := startServer(t, okHandler, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), WithH2C())
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Empty(t, conn.ConnectionState().NegotiatedProtocol)
+
+	// The h2c preface is not HTTP/2 over TLS.
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 505, resp.StatusCode)
+}
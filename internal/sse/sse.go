@@ -0,0 +1,277 @@
+// Package sse streams Server-Sent Events (text/event-stream) over a
+// response.Writer.
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+)
+
+// ErrClosed is returned by Send and Comment once the stream has ended,
+// either because the client went away or because Close was called.
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is one message on the stream.
+type Event struct {
+	ID    string        // sent as the id field; the client echoes it in Last-Event-ID
+	Event string        // the event type; "" means "message"
+	Data  string        // may span several lines
+	Retry time.Duration // reconnection delay for the client, if non-zero
+}
+
+// encode formats e as an event-stream block.
+func (e Event) encode() (string, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return "", errors.New("sse: event ID contains a line break or NUL")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return "", errors.New("sse: event type contains a line break")
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range splitLines(e.Data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// splitLines splits s at CRLF, CR or LF, the line endings event streams
+// recognize.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// Stream is an open event stream. Send and Comment may be called from
+// several goroutines.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+	heartbeat   time.Duration
+	retry       time.Duration
+	history     *History
+
+	mu     sync.Mutex // serializes writes to w
+	done   chan struct{}
+	ended  sync.Once
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed sync.Once
+}
+
+// Option configures a Stream.
+type Option func(*Stream)
+
+// WithHeartbeat sends a comment every interval so that proxies and the
+// client keep an idle stream open, and so a dead client is noticed.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(s *Stream) {
+		s.heartbeat = interval
+	}
+}
+
+// WithRetry tells the client how long to wait before reconnecting.
+func WithRetry(d time.Duration) Option {
+	return func(s *Stream) {
+		s.retry = d
+	}
+}
+
+// WithHistory replays the events h recorded after the client's
+// Last-Event-ID when a client reconnects.
+func WithHistory(h *History) Option {
+	return func(s *Stream) {
+		s.history = h
+	}
+}
+
+// NewStream sends the event-stream response headers and returns the
+// stream. The handler should call Close before it returns, and stop
+// sending once Done is closed.
+func NewStream(w *response.Writer, req *request.Request, opts ...Option) (*Stream, error) {
+	s := &Stream{
+		w:           w,
+		lastEventID: req.Headers.Get("last-event-id"),
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Delete("Content-Length")
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if s.retry > 0 {
+		if err := s.write("retry: " + strconv.FormatInt(s.retry.Milliseconds(), 10) + "\n\n"); err != nil {
+			return nil, err
+		}
+	}
+	if s.history != nil && s.lastEventID != "" {
+		for _, e := range s.history.Since(s.lastEventID) {
+			if err := s.Send(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	s.wg.Add(1)
+	go s.watch(w.CloseNotify())
+	return s, nil
+}
+
+// LastEventID returns the Last-Event-ID the client sent when it
+// reconnected, or "".
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the stream ends: the client disconnected, a write
+// failed or Close was called.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes e and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	block, err := e.encode()
+	if err != nil {
+		return err
+	}
+	return s.write(block)
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Close ends the stream and stops the heartbeat. The response itself ends
+// when the handler returns.
+func (s *Stream) Close() {
+	s.closed.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		s.end()
+	})
+}
+
+func (s *Stream) write(block string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+	if _, err := s.w.WriteString(block); err != nil {
+		s.end()
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.end()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) end() {
+	s.ended.Do(func() { close(s.done) })
+}
+
+// watch sends heartbeats and ends the stream when the client disconnects.
+func (s *Stream) watch(gone <-chan struct{}) {
+	defer s.wg.Done()
+
+	var tick <-chan time.Time
+	if s.heartbeat > 0 {
+		ticker := time.NewTicker(s.heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			if s.Comment("heartbeat") != nil {
+				return
+			}
+		case <-gone:
+			s.end()
+			return
+		case <-s.done:
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// History keeps the most recent events with an ID so that reconnecting
+// clients can catch up.
+type History struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+}
+
+// NewHistory returns a History holding up to size events.
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+// Add records e. Events without an ID cannot be resumed from and are not
+// kept.
+func (h *History) Add(e Event) {
+	if e.ID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		h.events = append([]Event(nil), h.events[len(h.events)-h.size:]...)
+	}
+}
+
+// Since returns the events recorded after the one with the given ID. If
+// that event is no longer kept, every event kept is returned, since the
+// client may have missed any of them.
+func (h *History) Since(id string) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.events) - 1; i >= 0; i-- {
+		if h.events[i].ID == id {
+			return append([]Event(nil), h.events[i+1:]...)
+		}
+	}
+	return append([]Event(nil), h.events...)
+}
@@ -0,0 +1,206 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventEncode(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{Data: ""}, "data: \n\n"},
+		{Event{ID: "7", Event: "update", Data: "cpu=3"}, "id: 7\nevent: update\ndata: cpu=3\n\n"},
+		{Event{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{Event{Data: "x", Retry: 2500 * time.Millisecond}, "retry: 2500\ndata: x\n\n"},
+	}
+	for _, tt := range tests {
+		got, err := tt.event.encode()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := Event{ID: "1\n2"}.encode()
+	assert.Error(t, err)
+	_, err = Event{Event: "a\rb"}.encode()
+	assert.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.Add(Event{ID: fmt.Sprint(i), Data: "x"})
+	}
+	h.Add(Event{Data: "no id"})
+
+	ids := func(events []Event) []string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"5"}, ids(h.Since("4")))
+	assert.Empty(t, h.Since("5"))
+	// Event 1 was dropped; the client gets all that is left.
+	assert.Equal(t, []string{"3", "4", "5"}, ids(h.Since("1")))
+}
+
+// serve runs handler on a local server and sends one GET request with the
+// given extra header lines.
+func serve(t *testing.T, handler server.Handler, extra string) (net.Conn, *http.Response) {
+	t.Helper()
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: x\r\n"+extra+"\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return conn, resp
+}
+
+// readEvent reads up to and including the blank line that ends a block.
+func readEvent(t *testing.T, br *bufio.Reader) string {
+	t.Helper()
+	var b strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		b.WriteString(line)
+		if line == "\n" {
+			return b.String()
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	_, resp := serve(t, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req, WithRetry(3*time.Second))
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		stream.Send(Event{ID: "1", Event: "update", Data: "first"})
+		stream.Comment("between events")
+		stream.Send(Event{ID: "2", Data: "second\nline"})
+	}, "")
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 3000\n\n"+
+		"id: 1\nevent: update\ndata: first\n\n"+
+		": between events\n\n"+
+		"id: 2\ndata: second\ndata: line\n\n", string(body))
+}
+
+func TestStreamFlushesEachEvent(t *testing.T) {
+	next := make(chan struct{})
+	_, resp := serve(t, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req)
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		for i := range 3 {
+			<-next
+			stream.Send(Event{Data: fmt.Sprint(i)})
+		}
+	}, "")
+
+	br := bufio.NewReader(resp.Body)
+	for i := range 3 {
+		// Each event arrives before the handler is allowed to go on.
+		next <- struct{}{}
+		assert.Equal(t, fmt.Sprintf("data: %d\n\n", i), readEvent(t, br))
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	_, resp := serve(t, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req, WithHeartbeat(10*time.Millisecond))
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		<-release
+	}, "")
+
+	br := bufio.NewReader(resp.Body)
+	assert.Equal(t, ": heartbeat\n\n", readEvent(t, br))
+	assert.Equal(t, ": heartbeat\n\n", readEvent(t, br))
+}
+
+func TestStreamResume(t *testing.T) {
+	history := NewHistory(10)
+	for i := 1; i <= 4; i++ {
+		history.Add(Event{ID: fmt.Sprint(i), Data: fmt.Sprint("event ", i)})
+	}
+
+	lastID := make(chan string, 1)
+	_, resp := serve(t, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req, WithHistory(history))
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		lastID <- stream.LastEventID()
+	}, "Last-Event-ID: 2\r\n")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "2", <-lastID)
+	assert.Equal(t, "id: 3\ndata: event 3\n\nid: 4\ndata: event 4\n\n", string(body))
+}
+
+func TestStreamStopsWhenClientDisconnects(t *testing.T) {
+	result := make(chan error, 1)
+	conn, resp := serve(t, func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req, WithHeartbeat(time.Hour))
+		if err != nil {
+			result <- err
+			return
+		}
+		defer stream.Close()
+		stream.Send(Event{Data: "hello"})
+
+		select {
+		case <-stream.Done():
+			result <- stream.Send(Event{Data: "too late"})
+		case <-time.After(5 * time.Second):
+			result <- fmt.Errorf("disconnect not noticed")
+		}
+	}, "")
+
+	assert.Equal(t, "data: hello\n\n", readEvent(t, bufio.NewReader(resp.Body)))
+	conn.Close()
+	assert.ErrorIs(t, <-result, ErrClosed)
+}
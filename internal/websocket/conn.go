@@ -0,0 +1,502 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// CloseCode is a status code carried by a close frame (RFC 6455 section
+// 7.4).
+type CloseCode int
+
+const (
+	CloseNormal             CloseCode = 1000
+	CloseGoingAway          CloseCode = 1001
+	CloseProtocolError      CloseCode = 1002
+	CloseUnsupportedData    CloseCode = 1003
+	CloseNoStatus           CloseCode = 1005 // never sent; the peer's close frame had no code
+	CloseAbnormal           CloseCode = 1006 // never sent
+	CloseInvalidPayload     CloseCode = 1007
+	ClosePolicyViolation    CloseCode = 1008
+	CloseMessageTooBig      CloseCode = 1009
+	CloseMandatoryExtension CloseCode = 1010
+	CloseInternalError      CloseCode = 1011
+)
+
+// closeTimeout bounds how long Close waits for the peer to answer the close
+// frame.
+const closeTimeout = 5 * time.Second
+
+// fragmentSize is the payload size at which a message writer sends a
+// fragment.
+const fragmentSize = 16 << 10
+
+// ErrCloseSent is returned when writing after a close frame was sent.
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed by peer (%d)", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed by peer (%d %s)", e.Code, e.Reason)
+}
+
+// failure is a violation by the peer. The connection is closed with code.
+type failure struct {
+	code   CloseCode
+	reason string
+}
+
+func (f *failure) Error() string {
+	return "websocket: " + f.reason
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read while
+// others write; writes of whole messages are serialized.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	readLimit   int64
+
+	// Set when permessage-deflate was negotiated.
+	compressor   *compressor
+	decompressor *decompressor
+
+	readMu      sync.Mutex
+	readErr     error
+	pongHandler func(data []byte)
+
+	msgMu     sync.Mutex // held while a data message is being written
+	writeMu   sync.Mutex // held per frame, so control frames fit between fragments
+	closeSent bool
+
+	peerClosed     chan struct{}
+	peerClosedOnce sync.Once
+}
+
+func newConn(nc net.Conn, buffered []byte, subprotocol string, readLimit int64, deflate *deflateParams) *Conn {
+	var r io.Reader = nc
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), nc)
+	}
+	c := &Conn{
+		conn:        nc,
+		br:          bufio.NewReader(r),
+		subprotocol: subprotocol,
+		readLimit:   readLimit,
+		peerClosed:  make(chan struct{}),
+	}
+	if deflate != nil {
+		c.compressor = newCompressor(!deflate.serverNoContextTakeover)
+		c.decompressor = &decompressor{contextTakeover: !deflate.clientNoContextTakeover}
+	}
+	return c
+}
+
+// Subprotocol returns the subprotocol chosen during the handshake, or "".
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the client's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for reading the next message.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler installs fn to be called, from ReadMessage, for every pong
+// frame received.
+func (c *Conn) SetPongHandler(fn func(data []byte)) {
+	c.pongHandler = fn
+}
+
+// ReadMessage returns the next data message. Pings are answered and pongs
+// handed to the pong handler along the way. When the peer closes the
+// connection the error is a *CloseError; when it breaks the protocol the
+// connection is closed with the matching status code. Either way every
+// later call returns the same error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, data, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		c.fail(err)
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		started    bool
+		typ        opcode
+		compressed bool
+		payload    []byte
+	)
+
+	for {
+		h, err := readFrameHeader(c.br)
+		if err == errLengthTooLarge {
+			return 0, nil, &failure{CloseProtocolError, err.Error()}
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := c.checkFrame(h, started); err != nil {
+			return 0, nil, err
+		}
+		if !h.opcode.isControl() && int64(len(payload))+h.length > c.readLimit {
+			return 0, nil, &failure{CloseMessageTooBig, "message exceeds the read limit"}
+		}
+
+		data := make([]byte, h.length)
+		if _, err := io.ReadFull(c.br, data); err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		maskBytes(h.mask, data)
+
+		switch h.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, data); err != nil && err != ErrCloseSent {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(data)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(data)
+		case opText, opBinary:
+			started = true
+			typ = h.opcode
+			compressed = h.rsv&rsv1Bit != 0
+		}
+
+		payload = append(payload, data...)
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		var err error
+		payload, err = c.decompressor.decompress(payload, c.readLimit)
+		if err == errTooBig {
+			return 0, nil, &failure{CloseMessageTooBig, err.Error()}
+		}
+		if err != nil {
+			return 0, nil, &failure{CloseInvalidPayload, "invalid compressed message"}
+		}
+	}
+	if typ == opText && !utf8.Valid(payload) {
+		return 0, nil, &failure{CloseInvalidPayload, "text message is not valid UTF-8"}
+	}
+	return MessageType(typ), payload, nil
+}
+
+// checkFrame validates a frame header against the state of the message
+// being read.
+func (c *Conn) checkFrame(h frameHeader, started bool) error {
+	if !h.masked {
+		return &failure{CloseProtocolError, "client frame is not masked"}
+	}
+	if h.rsv&(rsv2Bit|rsv3Bit) != 0 {
+		return &failure{CloseProtocolError, "reserved bits set"}
+	}
+	if h.rsv&rsv1Bit != 0 && (c.decompressor == nil || h.opcode == opContinuation || h.opcode.isControl()) {
+		return &failure{CloseProtocolError, "unexpected RSV1 bit"}
+	}
+
+	switch h.opcode {
+	case opClose, opPing, opPong:
+		if !h.fin {
+			return &failure{CloseProtocolError, "fragmented control frame"}
+		}
+		if h.length > maxControlPayload {
+			return &failure{CloseProtocolError, "control frame payload too long"}
+		}
+	case opContinuation:
+		if !started {
+			return &failure{CloseProtocolError, "continuation frame without a message"}
+		}
+	case opText, opBinary:
+		if started {
+			return &failure{CloseProtocolError, "new message before the previous one ended"}
+		}
+	default:
+		return &failure{CloseProtocolError, fmt.Sprintf("reserved opcode %#x", byte(h.opcode))}
+	}
+	return nil
+}
+
+// handleClose answers the peer's close frame, unless this side started the
+// closing handshake, and returns the error ReadMessage reports.
+func (c *Conn) handleClose(data []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(data) == 1:
+		return &failure{CloseProtocolError, "close frame payload too short"}
+	case len(data) >= 2:
+		closeErr.Code = CloseCode(binary.BigEndian.Uint16(data))
+		if !validCloseCode(closeErr.Code) {
+			return &failure{CloseProtocolError, fmt.Sprintf("invalid close code %d", closeErr.Code)}
+		}
+		if !utf8.Valid(data[2:]) {
+			return &failure{CloseInvalidPayload, "close reason is not valid UTF-8"}
+		}
+		closeErr.Reason = string(data[2:])
+	}
+
+	c.peerClosedOnce.Do(func() { close(c.peerClosed) })
+	// Echo the status code, as RFC 6455 section 5.5.1 suggests.
+	reply := data
+	if len(reply) > 2 {
+		reply = reply[:2]
+	}
+	if err := c.writeControl(opClose, reply); err != nil && err != ErrCloseSent {
+		return err
+	}
+	return closeErr
+}
+
+// validCloseCode reports whether code may appear in a close frame.
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail ends the connection after ReadMessage returned err.
+func (c *Conn) fail(err error) {
+	var f *failure
+	if errors.As(err, &f) {
+		c.writeClose(f.code, f.reason)
+	}
+	c.conn.Close()
+}
+
+// WriteMessage sends data as a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	w, err := c.newMessageWriter(typ, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter returns a writer for a message that is sent in fragments as
+// it is written. The message ends when the writer is closed; until then
+// other messages wait.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	return c.newMessageWriter(typ, fragmentSize)
+}
+
+func (c *Conn) newMessageWriter(typ MessageType, fragment int) (*messageWriter, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	c.msgMu.Lock()
+	w := &messageWriter{c: c, op: opcode(typ), fragment: fragment}
+	if c.compressor != nil {
+		c.compressor.begin()
+		w.compress = true
+	}
+	return w, nil
+}
+
+// messageWriter writes one data message. With fragment zero the message
+// goes out as a single frame on Close.
+type messageWriter struct {
+	c        *Conn
+	op       opcode // opContinuation once the first frame is out
+	fragment int
+	compress bool
+	rsv1     bool
+	buf      []byte
+	closed   bool
+	err      error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if !w.compress {
+		w.buf = append(w.buf, p...)
+		for w.fragment > 0 && len(w.buf) >= w.fragment {
+			if err := w.flushFrame(false, w.buf[:w.fragment]); err != nil {
+				return 0, err
+			}
+			w.buf = w.buf[w.fragment:]
+		}
+		return len(p), nil
+	}
+
+	if err := w.c.compressor.write(p); err != nil {
+		w.err = err
+		return 0, err
+	}
+	if w.fragment > 0 {
+		if out := w.c.compressor.pending(w.fragment); out != nil {
+			if err := w.flushFrame(false, out); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends the last frame of the message.
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.c.msgMu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	payload := w.buf
+	if w.compress {
+		tail, err := w.c.compressor.finish()
+		if err != nil {
+			return err
+		}
+		payload = tail
+	}
+	return w.flushFrame(true, payload)
+}
+
+func (w *messageWriter) flushFrame(fin bool, payload []byte) error {
+	// Only the first frame of a compressed message has RSV1 set.
+	rsv1 := w.compress && w.op != opContinuation
+	err := w.c.writeFrame(fin, rsv1, w.op, payload)
+	w.op = opContinuation
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// Ping sends a ping frame. The reply arrives at the pong handler.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeControl(opPing, data)
+}
+
+// Close starts the closing handshake with CloseNormal.
+func (c *Conn) Close() error {
+	return c.CloseWithStatus(CloseNormal, "")
+}
+
+// CloseWithStatus sends a close frame with code and reason, waits a while
+// for the peer's close frame and closes the connection.
+func (c *Conn) CloseWithStatus(code CloseCode, reason string) error {
+	err := c.writeClose(code, reason)
+	if err == ErrCloseSent {
+		err = nil
+	}
+
+	if c.readMu.TryLock() {
+		// Nobody is reading; drain frames until the peer answers.
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for c.readErr == nil {
+			_, _, c.readErr = c.readMessage()
+		}
+		c.readMu.Unlock()
+	} else {
+		// The reader sees the peer's close frame and stops on its own.
+		select {
+		case <-c.peerClosed:
+		case <-time.After(closeTimeout):
+		}
+	}
+
+	if cerr := c.conn.Close(); err == nil && !errors.Is(cerr, net.ErrClosed) {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Conn) writeClose(code CloseCode, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return c.writeControl(opClose, payload)
+}
+
+func (c *Conn) writeControl(op opcode, payload []byte) error {
+	return c.writeFrame(true, false, op, payload)
+}
+
+// writeFrame writes one frame. Nothing goes out after a close frame.
+func (c *Conn) writeFrame(fin, rsv1 bool, op opcode, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	return writeFrame(c.conn, fin, rsv1, op, nil, payload)
+}
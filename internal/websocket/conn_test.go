@@ -0,0 +1,283 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func closePayload(code CloseCode, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// The cases below follow the sections of the Autobahn test suite; each one
+// runs against an echo server.
+
+func TestEchoPayloadSizes(t *testing.T) {
+	// Autobahn 1.1 and 1.2: lengths around the 7-bit, 16-bit and 64-bit
+	// length encodings.
+	for _, size := range []int{0, 1, 125, 126, 127, 0xffff, 0x10000, 1 << 20} {
+		for _, op := range []opcode{opText, opBinary} {
+			c, _ := dial(t, NewUpgrader(), echo, nil)
+			payload := bytes.Repeat([]byte("*"), size)
+			c.send(finBit|byte(op), payload)
+
+			h, data := c.read()
+			assert.Equal(t, op, h.opcode, "size %d", size)
+			assert.True(t, h.fin)
+			assert.Equal(t, payload, data, "size %d", size)
+		}
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	// Autobahn 2.x: pings are answered with the same payload, pongs nobody
+	// asked for are ignored.
+	c, _ := dial(t, NewUpgrader(), echo, nil)
+
+	c.send(finBit|byte(opPing), []byte("are you there"))
+	h, data := c.read()
+	assert.Equal(t, opPong, h.opcode)
+	assert.Equal(t, "are you there", string(data))
+
+	c.send(finBit|byte(opPing), nil)
+	h, data = c.read()
+	assert.Equal(t, opPong, h.opcode)
+	assert.Empty(t, data)
+
+	c.send(finBit|byte(opPong), []byte("unsolicited"))
+	c.send(finBit|byte(opText), []byte("still here"))
+	h, data = c.read()
+	assert.Equal(t, opText, h.opcode)
+	assert.Equal(t, "still here", string(data))
+}
+
+func TestPongHandler(t *testing.T) {
+	pongs := make(chan string, 1)
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		ws.SetPongHandler(func(data []byte) { pongs <- string(data) })
+		ws.Ping([]byte("tick"))
+		echo(ws)
+	}, nil)
+
+	h, data := c.read()
+	require.Equal(t, opPing, h.opcode)
+	c.send(finBit|byte(opPong), data)
+	c.send(finBit|byte(opText), nil)
+	c.read()
+	assert.Equal(t, "tick", <-pongs)
+}
+
+func TestFragmentation(t *testing.T) {
+	// Autobahn 5.x: fragmented messages, with control frames in between.
+	c, _ := dial(t, NewUpgrader(), echo, nil)
+
+	c.send(byte(opText), []byte("frag"))
+	c.send(finBit|byte(opPing), []byte("ping"))
+	c.send(byte(opContinuation), []byte("men"))
+	c.send(finBit|byte(opPong), nil)
+	c.send(finBit|byte(opContinuation), []byte("ted"))
+
+	h, data := c.read()
+	assert.Equal(t, opPong, h.opcode)
+	assert.Equal(t, "ping", string(data))
+	h, data = c.read()
+	assert.Equal(t, opText, h.opcode)
+	assert.Equal(t, "fragmented", string(data))
+
+	// Empty fragments are allowed too.
+	c.send(byte(opBinary), nil)
+	c.send(byte(opContinuation), nil)
+	c.send(finBit|byte(opContinuation), nil)
+	h, data = c.read()
+	assert.Equal(t, opBinary, h.opcode)
+	assert.Empty(t, data)
+}
+
+func TestUTF8SplitAcrossFragments(t *testing.T) {
+	// Autobahn 6.x: only the whole message has to be valid UTF-8.
+	c, _ := dial(t, NewUpgrader(), echo, nil)
+	msg := []byte("κόσμε")
+	c.send(byte(opText), msg[:3])
+	c.send(finBit|byte(opContinuation), msg[3:])
+
+	_, data := c.read()
+	assert.Equal(t, "κόσμε", string(data))
+}
+
+func TestProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames func(c *testClient)
+		code   CloseCode
+	}{
+		{"rsv1 without extension", func(c *testClient) { c.send(finBit|rsv1Bit|byte(opText), []byte("x")) }, CloseProtocolError},
+		{"rsv2", func(c *testClient) { c.send(finBit|rsv2Bit|byte(opText), []byte("x")) }, CloseProtocolError},
+		{"rsv3 on ping", func(c *testClient) { c.send(finBit|rsv3Bit|byte(opPing), nil) }, CloseProtocolError},
+		{"reserved data opcode", func(c *testClient) { c.send(finBit|0x3, nil) }, CloseProtocolError},
+		{"reserved control opcode", func(c *testClient) { c.send(finBit|0xB, nil) }, CloseProtocolError},
+		{"ping too long", func(c *testClient) { c.send(finBit|byte(opPing), make([]byte, 126)) }, CloseProtocolError},
+		{"fragmented ping", func(c *testClient) { c.send(byte(opPing), []byte("x")) }, CloseProtocolError},
+		{"continuation first", func(c *testClient) { c.send(finBit|byte(opContinuation), []byte("x")) }, CloseProtocolError},
+		{"interleaved messages", func(c *testClient) {
+			c.send(byte(opText), []byte("a"))
+			c.send(finBit|byte(opText), []byte("b"))
+		}, CloseProtocolError},
+		{"unmasked", func(c *testClient) {
+			c.conn.Write([]byte{finBit | byte(opText), 1, 'x'})
+		}, CloseProtocolError},
+		{"invalid utf8", func(c *testClient) { c.send(finBit|byte(opText), []byte{0xce, 0xba, 0xff}) }, CloseInvalidPayload},
+		{"invalid utf8 fragment", func(c *testClient) {
+			c.send(byte(opText), []byte("ok"))
+			c.send(finBit|byte(opContinuation), []byte{0xed, 0xa0, 0x80}) // a surrogate
+		}, CloseInvalidPayload},
+		{"one byte close", func(c *testClient) { c.send(finBit|byte(opClose), []byte{0x03}) }, CloseProtocolError},
+		{"reserved close code", func(c *testClient) { c.send(finBit|byte(opClose), closePayload(1005, "")) }, CloseProtocolError},
+		{"close code below 1000", func(c *testClient) { c.send(finBit|byte(opClose), closePayload(999, "")) }, CloseProtocolError},
+		{"close code 2999", func(c *testClient) { c.send(finBit|byte(opClose), closePayload(2999, "")) }, CloseProtocolError},
+		{"invalid close reason", func(c *testClient) {
+			c.send(finBit|byte(opClose), append(closePayload(CloseNormal, ""), 0xff))
+		}, CloseInvalidPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := dial(t, NewUpgrader(), echo, nil)
+			tt.frames(c)
+			c.expectClose(tt.code)
+		})
+	}
+}
+
+func TestMessageTooBig(t *testing.T) {
+	c, _ := dial(t, NewUpgrader(WithReadLimit(10)), echo, nil)
+	c.send(byte(opBinary), make([]byte, 6))
+	c.send(finBit|byte(opContinuation), make([]byte, 6))
+	c.expectClose(CloseMessageTooBig)
+}
+
+func TestPeerClose(t *testing.T) {
+	// Autobahn 7.x: the close frame is answered with the same code and the
+	// server drops the connection.
+	for _, code := range []CloseCode{1000, 1001, 1002, 1003, 1007, 1011, 3000, 4999} {
+		closed := make(chan error, 1)
+		c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+			_, _, err := ws.ReadMessage()
+			closed <- err
+		}, nil)
+
+		c.send(finBit|byte(opClose), closePayload(code, "bye"))
+		c.expectClose(code)
+
+		var closeErr *CloseError
+		require.ErrorAs(t, <-closed, &closeErr)
+		assert.Equal(t, code, closeErr.Code)
+		assert.Equal(t, "bye", closeErr.Reason)
+	}
+}
+
+func TestPeerCloseWithoutStatus(t *testing.T) {
+	closed := make(chan error, 1)
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		_, _, err := ws.ReadMessage()
+		closed <- err
+	}, nil)
+
+	c.send(finBit|byte(opClose), nil)
+	h, data := c.read()
+	assert.Equal(t, opClose, h.opcode)
+	assert.Empty(t, data)
+
+	var closeErr *CloseError
+	require.ErrorAs(t, <-closed, &closeErr)
+	assert.Equal(t, CloseNoStatus, closeErr.Code)
+}
+
+func TestServerClose(t *testing.T) {
+	closed := make(chan error, 1)
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		closed <- ws.CloseWithStatus(CloseGoingAway, "restarting")
+	}, nil)
+
+	h, data := c.read()
+	require.Equal(t, opClose, h.opcode)
+	assert.Equal(t, closePayload(CloseGoingAway, "restarting"), data)
+
+	// Messages sent before the answer are dropped.
+	c.send(finBit|byte(opText), []byte("late"))
+	c.send(finBit|byte(opClose), data[:2])
+	_, err := c.br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	assert.NoError(t, <-closed)
+}
+
+func TestServerCloseWhileReading(t *testing.T) {
+	read := make(chan error, 1)
+	closed := make(chan error, 1)
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		go func() {
+			_, _, err := ws.ReadMessage()
+			read <- err
+		}()
+		// Give the reader time to block.
+		time.Sleep(20 * time.Millisecond)
+		closed <- ws.Close()
+	}, nil)
+
+	h, data := c.read()
+	require.Equal(t, opClose, h.opcode)
+	c.send(finBit|byte(opClose), data)
+
+	assert.NoError(t, <-closed)
+	var closeErr *CloseError
+	require.ErrorAs(t, <-read, &closeErr)
+	assert.Equal(t, CloseNormal, closeErr.Code)
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	errs := make(chan error, 1)
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		ws.Close()
+		errs <- ws.WriteMessage(TextMessage, []byte("too late"))
+	}, nil)
+
+	h, data := c.read()
+	require.Equal(t, opClose, h.opcode)
+	c.send(finBit|byte(opClose), data)
+	assert.ErrorIs(t, <-errs, ErrCloseSent)
+}
+
+func TestNextWriterFragments(t *testing.T) {
+	message := strings.Repeat("0123456789", 4000) // 40000 bytes
+	c, _ := dial(t, NewUpgrader(), func(ws *Conn) {
+		w, _ := ws.NextWriter(TextMessage)
+		for i := 0; i < len(message); i += 1000 {
+			w.Write([]byte(message[i : i+1000]))
+		}
+		w.Close()
+	}, nil)
+
+	var got []byte
+	var ops []opcode
+	for {
+		h, data := c.read()
+		ops = append(ops, h.opcode)
+		got = append(got, data...)
+		if h.fin {
+			break
+		}
+	}
+	assert.Equal(t, []opcode{opText, opContinuation, opContinuation}, ops)
+	assert.Equal(t, message, string(got))
+}
+
+func TestInvalidMessageType(t *testing.T) {
+	ws := newConn(nil, nil, "", DefaultReadLimit, nil)
+	assert.Error(t, ws.WriteMessage(MessageType(opPing), nil))
+}
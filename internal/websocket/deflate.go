@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// windowSize is the DEFLATE window, which is also the most history a
+// decompressor needs to keep between messages.
+const windowSize = 32 << 10
+
+// deflateTail is appended to a compressed message before inflating it: the
+// sync flush marker the sender stripped (RFC 7692 section 7.2.2), then an
+// empty final stored block so the reader ends with io.EOF.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flushMarker = deflateTail[:4]
+
+var errTooBig = errors.New("message exceeds the read limit")
+
+// deflateParams is an accepted permessage-deflate offer.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// header returns the Sec-WebSocket-Extensions value accepting p.
+func (p deflateParams) header() string {
+	value := "permessage-deflate"
+	if p.serverNoContextTakeover {
+		value += "; server_no_context_takeover"
+	}
+	if p.clientNoContextTakeover {
+		value += "; client_no_context_takeover"
+	}
+	return value
+}
+
+// negotiateDeflate picks the first permessage-deflate offer in a
+// Sec-WebSocket-Extensions value that this server can honor.
+func negotiateDeflate(extensions string) (deflateParams, bool) {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+		if p, ok := parseDeflateOffer(params[1:]); ok {
+			return p, true
+		}
+	}
+	return deflateParams{}, false
+}
+
+func parseDeflateOffer(params []string) (deflateParams, bool) {
+	var p deflateParams
+	seen := map[string]bool{}
+	for _, param := range params {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(param), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if seen[name] {
+			return p, false
+		}
+		seen[name] = true
+
+		switch name {
+		case "server_no_context_takeover":
+			if hasValue {
+				return p, false
+			}
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if hasValue {
+				return p, false
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			// compress/flate always uses the full window, so a smaller
+			// one cannot be promised.
+			if bits, ok := windowBits(value); !ok || bits != 15 {
+				return p, false
+			}
+		case "client_max_window_bits":
+			// Only a hint that the client supports the parameter; the
+			// client's window never exceeds what we can inflate.
+			if _, ok := windowBits(value); hasValue && !ok {
+				return p, false
+			}
+		default:
+			return p, false
+		}
+	}
+	return p, true
+}
+
+func windowBits(value string) (int, bool) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 8 || bits > 15 {
+		return 0, false
+	}
+	return bits, true
+}
+
+// compressor deflates outgoing messages.
+type compressor struct {
+	fw              *flate.Writer
+	out             bytes.Buffer
+	contextTakeover bool
+}
+
+func newCompressor(contextTakeover bool) *compressor {
+	c := &compressor{contextTakeover: contextTakeover}
+	c.fw, _ = flate.NewWriter(&c.out, flate.DefaultCompression)
+	return c
+}
+
+// begin starts a message, dropping the history of earlier ones unless it
+// is kept across messages.
+func (c *compressor) begin() {
+	c.out.Reset()
+	if !c.contextTakeover {
+		c.fw.Reset(&c.out)
+	}
+}
+
+// write compresses p into the pending output.
+func (c *compressor) write(p []byte) error {
+	_, err := c.fw.Write(p)
+	return err
+}
+
+// pending returns compressed bytes that can be sent as a fragment now and
+// removes them from the output. The last four bytes are held back since
+// they may turn out to be the flush marker that ends the message.
+func (c *compressor) pending(min int) []byte {
+	n := c.out.Len() - len(flushMarker)
+	if n < min || n <= 0 {
+		return nil
+	}
+	return bytes.Clone(c.out.Next(n))
+}
+
+// finish flushes the message and returns the rest of its payload, without
+// the trailing flush marker.
+func (c *compressor) finish() ([]byte, error) {
+	if err := c.fw.Flush(); err != nil {
+		return nil, err
+	}
+	tail := c.out.Bytes()
+	if !bytes.HasSuffix(tail, flushMarker) {
+		return nil, errors.New("deflate flush did not end with a sync marker")
+	}
+	tail = bytes.Clone(tail[:len(tail)-len(flushMarker)])
+	c.out.Reset()
+	return tail, nil
+}
+
+// decompressor inflates incoming messages.
+type decompressor struct {
+	fr              io.ReadCloser
+	contextTakeover bool
+	window          []byte // the end of earlier output, used as dictionary
+}
+
+// decompress inflates one message, refusing to produce more than limit
+// bytes.
+func (d *decompressor) decompress(payload []byte, limit int64) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail))
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(src, d.window)
+	} else if err := d.fr.(flate.Resetter).Reset(src, d.window); err != nil {
+		return nil, err
+	}
+
+	out, err := io.ReadAll(io.LimitReader(d.fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errTooBig
+	}
+
+	if d.contextTakeover {
+		d.window = append(d.window, out...)
+		if len(d.window) > windowSize {
+			d.window = append([]byte(nil), d.window[len(d.window)-windowSize:]...)
+		}
+	}
+	return out, nil
+}
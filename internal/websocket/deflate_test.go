@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		offer  string
+		ok     bool
+		header string
+	}{
+		{"permessage-deflate", true, "permessage-deflate"},
+		{"permessage-deflate; client_max_window_bits", true, "permessage-deflate"},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", true,
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{`permessage-deflate; server_max_window_bits="15"`, true, "permessage-deflate"},
+		// A smaller server window cannot be honored; the fallback can.
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true, "permessage-deflate"},
+		{"permessage-deflate; server_max_window_bits=10", false, ""},
+		{"permessage-deflate; client_max_window_bits=7", false, ""},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", false, ""},
+		{"permessage-deflate; server_no_context_takeover=1", false, ""},
+		{"permessage-deflate; unknown", false, ""},
+		{"x-webkit-deflate-frame", false, ""},
+		{"", false, ""},
+	}
+
+	for _, tt := range tests {
+		p, ok := negotiateDeflate(tt.offer)
+		assert.Equal(t, tt.ok, ok, tt.offer)
+		if ok {
+			assert.Equal(t, tt.header, p.header(), tt.offer)
+		}
+	}
+}
+
+// deflateClient compresses messages the way a client with context
+// takeover does and inflates the server's replies.
+type deflateClient struct {
+	fw      *flate.Writer
+	out     bytes.Buffer
+	history []byte
+}
+
+func newDeflateClient() *deflateClient {
+	d := &deflateClient{}
+	d.fw, _ = flate.NewWriter(&d.out, flate.BestSpeed)
+	return d
+}
+
+func (d *deflateClient) compress(t *testing.T, msg string) []byte {
+	d.out.Reset()
+	_, err := d.fw.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, d.fw.Flush())
+	return bytes.TrimSuffix(d.out.Bytes(), flushMarker)
+}
+
+func (d *deflateClient) inflate(t *testing.T, payload []byte, contextTakeover bool) string {
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateTail))
+	var dict []byte
+	if contextTakeover {
+		dict = d.history
+	}
+	out, err := io.ReadAll(flate.NewReaderDict(src, dict))
+	require.NoError(t, err)
+	d.history = append(d.history, out...)
+	return string(out)
+}
+
+func TestDeflateEcho(t *testing.T) {
+	u := NewUpgrader(WithCompression())
+	c, resp := dial(t, u, echo, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits"})
+	require.Equal(t, "permessage-deflate", resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	client := newDeflateClient()
+	messages := []string{
+		strings.Repeat("dashboard update ", 50),
+		strings.Repeat("dashboard update ", 50), // mostly back-references now
+		"",
+		"κόσμε",
+	}
+	for _, msg := range messages {
+		c.send(finBit|rsv1Bit|byte(opText), client.compress(t, msg))
+
+		h, data := c.read()
+		require.Equal(t, opText, h.opcode)
+		require.True(t, h.fin)
+		require.Equal(t, byte(rsv1Bit), h.rsv, "replies are compressed")
+		assert.Equal(t, msg, client.inflate(t, data, true))
+	}
+}
+
+func TestDeflateUncompressedMessages(t *testing.T) {
+	// With the extension in use a message may still be sent as is.
+	u := NewUpgrader(WithCompression())
+	c, _ := dial(t, u, echo, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"})
+
+	c.send(finBit|byte(opBinary), []byte("plain"))
+	h, data := c.read()
+	require.Equal(t, byte(rsv1Bit), h.rsv)
+	assert.Equal(t, "plain", newDeflateClient().inflate(t, data, false))
+}
+
+func TestDeflateNoContextTakeover(t *testing.T) {
+	u := NewUpgrader(WithCompression())
+	c, resp := dial(t, u, echo, map[string]string{
+		"Sec-WebSocket-Extensions": "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+	})
+	require.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "server_no_context_takeover")
+
+	msg := strings.Repeat("abc", 100)
+	for range 3 {
+		// Each message is compressed with a fresh window on both sides.
+		c.send(finBit|rsv1Bit|byte(opText), newDeflateClient().compress(t, msg))
+		_, data := c.read()
+		assert.Equal(t, msg, newDeflateClient().inflate(t, data, false))
+	}
+}
+
+func TestDeflateFragmentedMessage(t *testing.T) {
+	u := NewUpgrader(WithCompression())
+	c, _ := dial(t, u, echo, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"})
+
+	payload := newDeflateClient().compress(t, strings.Repeat("fragment ", 200))
+	c.send(rsv1Bit|byte(opText), payload[:10])
+	c.send(finBit|byte(opContinuation), payload[10:])
+
+	_, data := c.read()
+	assert.Equal(t, strings.Repeat("fragment ", 200), newDeflateClient().inflate(t, data, false))
+}
+
+func TestDeflateNextWriter(t *testing.T) {
+	u := NewUpgrader(WithCompression())
+	// Random bytes barely compress, so the output spans several fragments.
+	message := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(message)
+	c, _ := dial(t, u, func(ws *Conn) {
+		w, _ := ws.NextWriter(BinaryMessage)
+		w.Write(message)
+		w.Close()
+	}, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"})
+
+	var payload []byte
+	frames := 0
+	for {
+		h, data := c.read()
+		if frames == 0 {
+			assert.Equal(t, byte(rsv1Bit), h.rsv)
+		} else {
+			assert.Zero(t, h.rsv, "only the first frame has RSV1")
+		}
+		frames++
+		payload = append(payload, data...)
+		if h.fin {
+			break
+		}
+	}
+	assert.Greater(t, frames, 1)
+	assert.Equal(t, string(message), newDeflateClient().inflate(t, payload, false))
+}
+
+func TestDeflateProtocolErrors(t *testing.T) {
+	u := NewUpgrader(WithCompression(), WithReadLimit(1000))
+	ext := map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"}
+
+	t.Run("rsv1 on continuation", func(t *testing.T) {
+		c, _ := dial(t, u, echo, ext)
+		c.send(byte(opText), []byte("a"))
+		c.send(finBit|rsv1Bit|byte(opContinuation), []byte("b"))
+		c.expectClose(CloseProtocolError)
+	})
+	t.Run("rsv1 on control frame", func(t *testing.T) {
+		c, _ := dial(t, u, echo, ext)
+		c.send(finBit|rsv1Bit|byte(opPing), nil)
+		c.expectClose(CloseProtocolError)
+	})
+	t.Run("corrupt data", func(t *testing.T) {
+		c, _ := dial(t, u, echo, ext)
+		c.send(finBit|rsv1Bit|byte(opBinary), []byte{0xff, 0xff, 0xff})
+		c.expectClose(CloseInvalidPayload)
+	})
+	t.Run("inflates past the limit", func(t *testing.T) {
+		c, _ := dial(t, u, echo, ext)
+		c.send(finBit|rsv1Bit|byte(opBinary), newDeflateClient().compress(t, strings.Repeat("x", 5000)))
+		c.expectClose(CloseMessageTooBig)
+	})
+}
+
+func TestCompressionNotOffered(t *testing.T) {
+	_, resp := dial(t, NewUpgrader(), echo, map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"})
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+}
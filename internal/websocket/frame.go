@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// opcode is the frame type (RFC 6455 section 5.2).
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+// isControl reports whether op is a control frame. Control frames may not
+// be fragmented and carry at most maxControlPayload bytes.
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40 // set on the first frame of a compressed message
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+)
+
+var errLengthTooLarge = errors.New("frame length has the most significant bit set")
+
+type frameHeader struct {
+	fin    bool
+	rsv    byte // RSV1-3 bits, in place
+	opcode opcode
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+// readFrameHeader reads the header of the next frame. It only checks what
+// can be checked without knowing the connection's state.
+func readFrameHeader(r io.Reader) (frameHeader, error) {
+	var h frameHeader
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = buf[0]&finBit != 0
+	h.rsv = buf[0] & (rsv1Bit | rsv2Bit | rsv3Bit)
+	h.opcode = opcode(buf[0] & 0x0f)
+	h.masked = buf[1]&maskBit != 0
+
+	h.length = int64(buf[1] & 0x7f)
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.length = int64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		n := binary.BigEndian.Uint64(buf[:8])
+		if n>>63 != 0 {
+			return h, errLengthTooLarge
+		}
+		h.length = int64(n)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// writeFrame writes one frame with a single Write. Servers never mask;
+// mask is only non-nil for frames written by a client.
+func writeFrame(w io.Writer, fin, rsv1 bool, op opcode, mask *[4]byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+
+	b := byte(op)
+	if fin {
+		b |= finBit
+	}
+	if rsv1 {
+		b |= rsv1Bit
+	}
+	frame = append(frame, b)
+
+	var m byte
+	if mask != nil {
+		m = maskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, m|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, m|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, m|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if mask != nil {
+		frame = append(frame, mask[:]...)
+	}
+	start := len(frame)
+	frame = append(frame, payload...)
+	if mask != nil {
+		maskBytes(*mask, frame[start:])
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// maskBytes XORs b with the masking key. Masking is its own inverse.
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) with optional permessage-deflate compression (RFC 7692).
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/RayanMalki/tcptohttp/internal/server"
+)
+
+// DefaultReadLimit is the largest message ReadMessage accepts, after
+// decompression, unless WithReadLimit says otherwise.
+const DefaultReadLimit = 16 << 20
+
+// acceptGUID is appended to Sec-WebSocket-Key to compute
+// Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader turns HTTP requests into WebSocket connections. Use
+// NewUpgrader to build one.
+type Upgrader struct {
+	subprotocols []string
+	checkOrigin  func(req *request.Request) bool
+	compression  bool
+	readLimit    int64
+}
+
+// Option configures an Upgrader.
+type Option func(*Upgrader)
+
+// WithSubprotocols lists the subprotocols the server speaks, in order of
+// preference. The first one the client also offers is chosen.
+func WithSubprotocols(protocols ...string) Option {
+	return func(u *Upgrader) {
+		u.subprotocols = protocols
+	}
+}
+
+// WithOriginCheck replaces the default origin check, which only accepts
+// requests without an Origin header or whose Origin host matches Host.
+func WithOriginCheck(fn func(req *request.Request) bool) Option {
+	return func(u *Upgrader) {
+		u.checkOrigin = fn
+	}
+}
+
+// WithCompression accepts the permessage-deflate extension when the
+// client offers it.
+func WithCompression() Option {
+	return func(u *Upgrader) {
+		u.compression = true
+	}
+}
+
+// WithReadLimit replaces DefaultReadLimit.
+func WithReadLimit(n int64) Option {
+	return func(u *Upgrader) {
+		u.readLimit = n
+	}
+}
+
+// NewUpgrader returns an Upgrader.
+func NewUpgrader(opts ...Option) *Upgrader {
+	u := &Upgrader{checkOrigin: sameOrigin, readLimit: DefaultReadLimit}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// Headers already set on w, such as cookies, go out with the 101 response.
+// If the request is not an acceptable handshake, Upgrade writes an error
+// response and returns an error.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		return nil, u.reject(w, req, response.StatusMethodNotAllowed, errors.New("websocket handshake must use GET"))
+	}
+	if !headers.HasToken(req.Headers.Get("connection"), "upgrade") || !headers.HasToken(req.Headers.Get("upgrade"), "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return nil, u.reject(w, req, response.StatusUpgradeRequired, errors.New("not a websocket handshake"))
+	}
+	if req.Headers.Get("sec-websocket-version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, u.reject(w, req, response.StatusUpgradeRequired, errors.New("unsupported websocket version"))
+	}
+	key := strings.TrimSpace(req.Headers.Get("sec-websocket-key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, u.reject(w, req, response.StatusBadRequest, errors.New("invalid Sec-WebSocket-Key"))
+	}
+	if !u.checkOrigin(req) {
+		return nil, u.reject(w, req, response.StatusForbidden, fmt.Errorf("origin %s is not allowed", req.Headers.Get("origin")))
+	}
+
+	subprotocol := u.selectSubprotocol(req.Headers.Get("sec-websocket-protocol"))
+	var deflate *deflateParams
+	if u.compression {
+		if p, ok := negotiateDeflate(req.Headers.Get("sec-websocket-extensions")); ok {
+			deflate = &p
+		}
+	}
+
+	nc, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, u.reject(w, req, response.StatusInternalError, err)
+	}
+
+	h := w.Header()
+	for _, name := range []string{"Content-Length", "Transfer-Encoding", "Content-Type"} {
+		h.Delete(name)
+	}
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if deflate != nil {
+		h.Set("Sec-WebSocket-Extensions", deflate.header())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", response.StatusSwitchingProtocols, response.StatusText(response.StatusSwitchingProtocols))
+	for key, value := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	for _, value := range w.SetCookieValues() {
+		fmt.Fprintf(&b, "set-cookie: %s\r\n", value)
+	}
+	b.WriteString("\r\n")
+	if _, err := nc.Write([]byte(b.String())); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return newConn(nc, buffered, subprotocol, u.readLimit, deflate), nil
+}
+
+func (u *Upgrader) reject(w *response.Writer, req *request.Request, status response.StatusCode, err error) error {
+	server.NegotiatedErrors(w, req, status, err)
+	return fmt.Errorf("websocket: %w", err)
+}
+
+// selectSubprotocol picks the server's most preferred subprotocol among
+// those in a Sec-WebSocket-Protocol value.
+func (u *Upgrader) selectSubprotocol(offered string) string {
+	for _, protocol := range u.subprotocols {
+		if headers.HasToken(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// sameOrigin accepts requests from browsers on the same host, and from
+// clients that send no Origin at all.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("host"))
+}
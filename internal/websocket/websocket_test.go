@@ -0,0 +1,279 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RayanMalki/tcptohttp/internal/headers"
+	"github.com/RayanMalki/tcptohttp/internal/request"
+	"github.com/RayanMalki/tcptohttp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func handshakeRequest(extra map[string]string) *request.Request {
+	h := headers.NewHeaders()
+	h.Set("Host", "example.com")
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "keep-alive, Upgrade")
+	h.Set("Sec-WebSocket-Version", "13")
+	h.Set("Sec-WebSocket-Key", testKey)
+	for key, value := range extra {
+		if value == "" {
+			h.Delete(key)
+		} else {
+			h.Set(key, value)
+		}
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/ws", HttpVersion: "1.1"},
+		Headers:     h,
+	}
+}
+
+// testClient is the client end of a connection that went through Upgrade.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dial serves one handshake on a loopback listener, passing the upgraded
+// connection to handler, and returns the client end and the handshake
+// response.
+func dial(t *testing.T, u *Upgrader, handler func(*Conn), extra map[string]string) (*testClient, *http.Response) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		req, err := request.RequestFromReader(bufio.NewReader(nc))
+		if err != nil {
+			nc.Close()
+			return
+		}
+		w := response.NewWriter(nc)
+		ws, err := u.Upgrade(w, req)
+		if err != nil {
+			w.Finish()
+			nc.Close()
+			return
+		}
+		handler(ws)
+	}()
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var b strings.Builder
+	b.WriteString("GET /ws HTTP/1.1\r\n")
+	for key, value := range handshakeRequest(extra).Headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+	_, err = io.WriteString(nc, b.String())
+	require.NoError(t, err)
+
+	c := &testClient{t: t, conn: nc, br: bufio.NewReader(nc)}
+	resp, err := http.ReadResponse(c.br, nil)
+	require.NoError(t, err)
+	return c, resp
+}
+
+// send writes a masked frame whose first byte is b0 (FIN, RSV and opcode).
+func (c *testClient) send(b0 byte, payload []byte) {
+	c.t.Helper()
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	frame = append(frame, mask[:]...)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes(mask, frame[start:])
+	_, err := c.conn.Write(frame)
+	require.NoError(c.t, err)
+}
+
+// read reads a frame from the server, which must not be masked.
+func (c *testClient) read() (frameHeader, []byte) {
+	c.t.Helper()
+	h, err := readFrameHeader(c.br)
+	require.NoError(c.t, err)
+	require.False(c.t, h.masked, "server frames are never masked")
+	data := make([]byte, h.length)
+	_, err = io.ReadFull(c.br, data)
+	require.NoError(c.t, err)
+	return h, data
+}
+
+// expectClose reads the server's close frame and checks that the server
+// then closes the connection.
+func (c *testClient) expectClose(code CloseCode) {
+	c.t.Helper()
+	h, data := c.read()
+	require.Equal(c.t, opClose, h.opcode)
+	require.GreaterOrEqual(c.t, len(data), 2)
+	assert.Equal(c.t, code, CloseCode(int(data[0])<<8|int(data[1])))
+	_, err := c.br.ReadByte()
+	assert.Equal(c.t, io.EOF, err)
+}
+
+func echo(c *Conn) {
+	for {
+		typ, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(typ, data); err != nil {
+			return
+		}
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey(testKey))
+}
+
+func TestUpgrade(t *testing.T) {
+	u := NewUpgrader(WithSubprotocols("v2.dashboard", "v1.dashboard"))
+	c, resp := dial(t, u, echo, map[string]string{
+		"Sec-WebSocket-Protocol": "v1.dashboard, v2.dashboard",
+		"Origin":                 "http://example.com",
+	})
+
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "v2.dashboard", resp.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	c.send(finBit|byte(opText), []byte("hello"))
+	h, data := c.read()
+	assert.Equal(t, opText, h.opcode)
+	assert.True(t, h.fin)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		extra  map[string]string
+		opts   []Option
+		status int
+		header string // a response header that must be present
+	}{
+		{name: "method", method: "POST", status: 405, header: "Allow"},
+		{name: "no upgrade", extra: map[string]string{"Upgrade": ""}, status: 426, header: "Upgrade"},
+		{name: "no connection token", extra: map[string]string{"Connection": "keep-alive"}, status: 426},
+		{name: "version", extra: map[string]string{"Sec-WebSocket-Version": "8"}, status: 426, header: "Sec-WebSocket-Version"},
+		{name: "missing key", extra: map[string]string{"Sec-WebSocket-Key": ""}, status: 400},
+		{name: "short key", extra: map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, status: 400},
+		{name: "cross origin", extra: map[string]string{"Origin": "https://evil.example"}, status: 403},
+		{
+			name:   "origin check",
+			extra:  map[string]string{"Origin": "https://dashboards.example"},
+			opts:   []Option{WithOriginCheck(func(*request.Request) bool { return false })},
+			status: 403,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := handshakeRequest(tt.extra)
+			if tt.method != "" {
+				req.RequestLine.Method = tt.method
+			}
+			var out bytes.Buffer
+			w := response.NewWriter(&out)
+
+			conn, err := NewUpgrader(tt.opts...).Upgrade(w, req)
+			require.Error(t, err)
+			assert.Nil(t, conn)
+			require.NoError(t, w.Finish())
+
+			resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.header != "" {
+				assert.NotEmpty(t, resp.Header.Get(tt.header))
+			}
+			assert.Empty(t, resp.Header.Get("Sec-WebSocket-Accept"))
+		})
+	}
+}
+
+func TestUpgradeOriginCheckOverride(t *testing.T) {
+	u := NewUpgrader(WithOriginCheck(func(req *request.Request) bool {
+		return req.Headers.Get("origin") == "https://dashboards.example"
+	}))
+	_, resp := dial(t, u, echo, map[string]string{"Origin": "https://dashboards.example"})
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+}
+
+func TestUpgradeWithoutCommonSubprotocol(t *testing.T) {
+	u := NewUpgrader(WithSubprotocols("graphql-ws"))
+	_, resp := dial(t, u, echo, map[string]string{"Sec-WebSocket-Protocol": "mqtt"})
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Empty(t, resp.Header.Values("Sec-WebSocket-Protocol"))
+}
+
+func TestUpgradeReadsBufferedFrames(t *testing.T) {
+	// A client may send its first frame right behind the handshake.
+	frame := []byte{finBit | byte(opText), maskBit | 2, 0, 0, 0, 0, 'h', 'i'}
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		w := response.NewWriter(hijackable{server, frame})
+		ws, err := NewUpgrader().Upgrade(w, handshakeRequest(nil))
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		_, data, _ := ws.ReadMessage()
+		done <- string(data)
+	}()
+
+	go io.Copy(io.Discard, client)
+	assert.Equal(t, "hi", <-done)
+}
+
+// hijackable is a connection that already read extra bytes past the
+// request, like the server's own connections.
+type hijackable struct {
+	net.Conn
+	buffered []byte
+}
+
+func (h hijackable) Hijack() (net.Conn, []byte, error) {
+	return h.Conn, h.buffered, nil
+}